@@ -1,5 +1,26 @@
 const { useState, useEffect } = React;
 
+const API_URL = 'https://expense-tracker-with-backend.onrender.com';
+
+// Amounts arrive as exact decimal strings tagged with an ISO 4217 currency.
+const formatMoney = (amount, currency) =>
+  new Intl.NumberFormat('en-IN', { style: 'currency', currency: currency || 'INR' })
+    .format(Number(amount));
+
+// errorMessage reads the {"error": {"code", "message", "fields"}} envelope of
+// a failed response, falling back to fallback when the body is not one.
+const errorMessage = async (response, fallback) => {
+  try {
+    const { error } = await response.json();
+    if (error && error.fields && error.fields.length) {
+      return error.fields.map(f => `${f.field}: ${f.message}`).join('\n');
+    }
+    return (error && error.message) || fallback;
+  } catch {
+    return fallback;
+  }
+};
+
 class ErrorBoundary extends React.Component {
   constructor(props) {
     super(props);
@@ -33,6 +54,13 @@ function TransactionForm({ addTransaction }) {
     type: 'income',
     dateTime: new Date().toISOString().slice(0, 16)
   });
+  // Submitting the same form again, e.g. after a timeout, reuses the key;
+  // any edit makes it a new request.
+  const [idempotencyKey, setIdempotencyKey] = useState(() => crypto.randomUUID());
+
+  useEffect(() => {
+    setIdempotencyKey(crypto.randomUUID());
+  }, [formData]);
 
   const handleSubmit = async (e) => {
     e.preventDefault();
@@ -42,22 +70,27 @@ function TransactionForm({ addTransaction }) {
     }
 
     try {
-      const response = await fetch('https://expense-tracker-with-backend.onrender.com/transactions', {
+      const response = await fetch(`${API_URL}/transactions`, {
         method: 'POST',
-        headers: { 'Content-Type': 'application/json' },
+        headers: {
+          'Content-Type': 'application/json',
+          // A retried submission replays the first response instead of
+          // creating the transaction twice.
+          'Idempotency-Key': idempotencyKey
+        },
         body: JSON.stringify({
           ...formData,
-          amount: parseFloat(formData.amount),
+          amount: formData.amount, // sent as a decimal string so it stays exact
           dateTime: new Date(formData.dateTime).toISOString()
         })
       });
 
-      const responseData = await response.json();
-      
       if (!response.ok) {
-        throw new Error(responseData.error || 'Failed to save transaction');
+        throw new Error(await errorMessage(response, 'Failed to save transaction'));
       }
 
+      const responseData = await response.json();
+
       addTransaction({
         ...responseData,
         id: responseData._id // Map MongoDB _id to id
@@ -174,7 +207,7 @@ function TransactionList({ transactions, deleteTransaction }) {
             <span className={`font-medium ${
               transaction.type === 'income' ? 'text-emerald-400' : 'text-rose-400'
             }`}>
-              {transaction.type === 'income' ? '+' : '-'}${transaction.amount.toFixed(2)}
+              {transaction.type === 'income' ? '+' : '-'}{formatMoney(transaction.amount, transaction.currency)}
             </span>
             <button
               onClick={() => deleteTransaction(transaction.id)}
@@ -190,21 +223,22 @@ function TransactionList({ transactions, deleteTransaction }) {
   );
 }
 
-function BalanceStats({ balance, income, expense }) {
+function BalanceStats({ summary }) {
+  const { balance, income, expense, currency } = summary;
   return (
     <div className="glassmorphism max-w-md mx-auto p-6 rounded-2xl shadow-xl">
       <div className="text-center space-y-4">
         <h2 className="text-2xl font-bold text-white">
-          ${balance.toFixed(2)}
+          {formatMoney(balance, currency)}
         </h2>
         <div className="grid grid-cols-2 gap-4">
           <div className="p-3 bg-emerald-600/20 rounded-lg">
             <div className="text-emerald-400 text-sm">Income</div>
-            <div className="text-white font-medium">+${income.toFixed(2)}</div>
+            <div className="text-white font-medium">+{formatMoney(income, currency)}</div>
           </div>
           <div className="p-3 bg-rose-600/20 rounded-lg">
             <div className="text-rose-400 text-sm">Expense</div>
-            <div className="text-white font-medium">-${expense.toFixed(2)}</div>
+            <div className="text-white font-medium">-{formatMoney(expense, currency)}</div>
           </div>
         </div>
       </div>
@@ -217,25 +251,53 @@ function App() {
   const [filter, setFilter] = useState('all');
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState(null);
+  const [summary, setSummary] = useState({ income: '0', expense: '0', balance: '0', currency: 'INR' });
+  const [lastDeleted, setLastDeleted] = useState(null);
+
+  // Totals are computed by the server, which converts every transaction into
+  // the base currency exactly.
+  const refreshSummary = async () => {
+    try {
+      const response = await fetch(`${API_URL}/summary`);
+      if (response.ok) {
+        setSummary(await response.json());
+      }
+    } catch (err) {
+      console.error('Summary error:', err);
+    }
+  };
+
+  useEffect(() => {
+    refreshSummary();
+  }, [transactions]);
 
   useEffect(() => {
     const fetchTransactions = async () => {
       try {
-        const response = await fetch('https://expense-tracker-with-backend.onrender.com/transactions');
-        
-        if (!response.ok) {
-          const errorData = await response.json();
-          throw new Error(errorData.error || 'Failed to load transactions');
-        }
+        const data = [];
+        let next = '/transactions?limit=500';
 
-        const data = await response.json();
+        // The list is paginated; follow the next links until the last page.
+        while (next) {
+          const response = await fetch(`${API_URL}${next}`);
+
+          if (!response.ok) {
+            throw new Error(await errorMessage(response, 'Failed to load transactions'));
+          }
+
+          const page = await response.json();
+          data.push(...page.transactions);
+          next = page.next;
+        }
         
         const formattedTransactions = data.map(t => ({
           id: t._id, // Map MongoDB _id to id
           description: t.description,
           amount: t.amount,
+          currency: t.currency,
           type: t.type,
-          dateTime: t.dateTime // Already in ISO format from backend
+          dateTime: t.dateTime, // Already in ISO format from backend
+          version: t.version
         }));
 
         setTransactions(formattedTransactions);
@@ -252,16 +314,21 @@ function App() {
   }, []);
 
   const deleteTransaction = async (id) => {
+    const transaction = transactions.find(t => t.id === id);
     try {
-      const response = await fetch(`https://expense-tracker-with-backend.onrender.com/transactions/${id}`, {
-        method: 'DELETE'
+      // If-Match refuses the delete when another tab changed the
+      // transaction since it was loaded.
+      const response = await fetch(`${API_URL}/transactions/${id}`, {
+        method: 'DELETE',
+        headers: transaction ? { 'If-Match': `"${transaction.version}"` } : {}
       });
 
       if (!response.ok) {
-        const errorData = await response.json();
-        throw new Error(errorData.error || 'Failed to delete transaction');
+        throw new Error(await errorMessage(response, 'Failed to delete transaction'));
       }
 
+      // Deleted transactions go to the trash, so the delete can be undone.
+      setLastDeleted(transaction ? { ...transaction, trashedETag: response.headers.get('ETag') } : null);
       setTransactions(prev => prev.filter(t => t.id !== id));
       
     } catch (err) {
@@ -271,19 +338,33 @@ function App() {
     }
   };
 
+  const undoDelete = async () => {
+    const transaction = lastDeleted;
+    setLastDeleted(null);
+    try {
+      const response = await fetch(`${API_URL}/transactions/${transaction.id}/restore`, {
+        method: 'POST',
+        headers: transaction.trashedETag ? { 'If-Match': transaction.trashedETag } : {}
+      });
+
+      if (!response.ok) {
+        throw new Error(await errorMessage(response, 'Failed to restore transaction'));
+      }
+
+      const restored = await response.json();
+      setTransactions(prev =>
+        [{ ...transaction, trashedETag: undefined, version: restored.version }, ...prev].sort((a, b) => new Date(b.dateTime) - new Date(a.dateTime))
+      );
+    } catch (err) {
+      alert(err.message);
+      console.error('Restore error:', err);
+    }
+  };
+
   const filteredTransactions = transactions.filter(t => 
     filter === 'all' || t.type === filter
   );
 
-  const totalIncome = transactions
-    .filter(t => t.type === 'income')
-    .reduce((sum, t) => sum + t.amount, 0);
-
-  const totalExpense = transactions
-    .filter(t => t.type === 'expense')
-    .reduce((sum, t) => sum + t.amount, 0);
-
-  const balance = totalIncome - totalExpense;
 
   if (loading) return <div className="text-center p-8 text-gray-400">Loading transactions...</div>;
   if (error) return <div className="text-center p-8 text-red-400">Error: {error}</div>;
@@ -295,7 +376,7 @@ function App() {
         <p className="text-gray-400">Minimal Expense Tracker</p>
       </header>
 
-      <BalanceStats balance={balance} income={totalIncome} expense={totalExpense} />
+      <BalanceStats summary={summary} />
       <TransactionForm addTransaction={(t) => setTransactions([t, ...transactions])} />
       
       <div className="max-w-md mx-auto">
@@ -310,6 +391,15 @@ function App() {
         </select>
       </div>
 
+      {lastDeleted && (
+        <div className="max-w-md mx-auto glassmorphism p-3 rounded-xl flex items-center justify-between text-sm text-gray-300">
+          <span>Deleted "{lastDeleted.description}"</span>
+          <button onClick={undoDelete} className="text-indigo-400 hover:text-indigo-300 font-medium">
+            Undo
+          </button>
+        </div>
+      )}
+
       <TransactionList 
         transactions={filteredTransactions} 
         deleteTransaction={deleteTransaction} 
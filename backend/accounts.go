@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrAccountInUse is returned by AccountStore.DeleteAccount when transactions
+// still reference the account.
+var ErrAccountInUse = errors.New("account is used by transactions")
+
+// Account kinds.
+const (
+	AccountBank       = "bank"
+	AccountCash       = "cash"
+	AccountCreditCard = "credit_card"
+	AccountWallet     = "wallet"
+)
+
+const maxAccountNameLength = 100
+
+func validAccountKind(k string) bool {
+	switch k {
+	case AccountBank, AccountCash, AccountCreditCard, AccountWallet:
+		return true
+	}
+	return false
+}
+
+// Account is a place money is kept. Every transaction on an account is in
+// the account's currency.
+type Account struct {
+	ID       primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Name     string             `json:"name" bson:"name"`
+	Kind     string             `json:"kind" bson:"kind"`
+	Currency string             `json:"currency" bson:"currency"`
+	// OpeningBalance is the balance before the first transaction; it is
+	// negative for a credit card that starts with debt.
+	OpeningBalance Money `json:"openingBalance" bson:"openingBalance"`
+	// Balance is OpeningBalance plus every transaction on the account. It
+	// is computed for responses and not stored.
+	Balance *Money `json:"balance,omitempty" bson:"-"`
+}
+
+// AccountTotal is the signed sum of the transactions on one account.
+type AccountTotal struct {
+	AccountID primitive.ObjectID `bson:"_id"`
+	Total     Money              `bson:"total"`
+	Count     int                `bson:"count"`
+}
+
+// AccountStore keeps the accounts.
+type AccountStore interface {
+	// CreateAccount stores a and assigns it a new ID.
+	CreateAccount(ctx context.Context, a *Account) error
+	// ListAccounts returns every account ordered by name.
+	ListAccounts(ctx context.Context) ([]Account, error)
+	// GetAccount returns the account with the given ID or ErrNotFound.
+	GetAccount(ctx context.Context, id primitive.ObjectID) (Account, error)
+	// UpdateAccount replaces the account that has a.ID, or returns
+	// ErrNotFound.
+	UpdateAccount(ctx context.Context, a Account) error
+	// DeleteAccount removes an account no transaction refers to. It returns
+	// ErrAccountInUse otherwise.
+	DeleteAccount(ctx context.Context, id primitive.ObjectID) error
+	// AccountTotals sums the signed amounts of the transactions on every
+	// account that has any.
+	AccountTotals(ctx context.Context) ([]AccountTotal, error)
+}
+
+func sortAccounts(accounts []Account) {
+	sort.Slice(accounts, func(i, j int) bool {
+		a, b := accounts[i], accounts[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return bytes.Compare(a.ID[:], b.ID[:]) < 0
+	})
+}
+
+// accountTotals sums the transactions per account, for stores that have no
+// query engine to do it for them.
+func accountTotals(transactions []Transaction) ([]AccountTotal, error) {
+	index := make(map[primitive.ObjectID]int)
+	var totals []AccountTotal
+	for _, t := range transactions {
+		if t.AccountID == nil {
+			continue
+		}
+		i, ok := index[*t.AccountID]
+		if !ok {
+			i = len(totals)
+			index[*t.AccountID] = i
+			totals = append(totals, AccountTotal{AccountID: *t.AccountID})
+		}
+		total, err := totals[i].Total.checkedAdd(t.signedAmount())
+		if err != nil {
+			return nil, fmt.Errorf("total of account %s: %w", t.AccountID.Hex(), err)
+		}
+		totals[i].Total = total
+		totals[i].Count++
+	}
+	return totals, nil
+}
+
+// validate checks and normalises a.
+func (a *Account) validate() error {
+	var errs validationError
+
+	a.Name = strings.TrimSpace(a.Name)
+	switch n := utf8.RuneCountInString(a.Name); {
+	case n == 0:
+		errs.add("name", "is required")
+	case n > maxAccountNameLength:
+		errs.add("name", "must be at most %d characters", maxAccountNameLength)
+	}
+	if !validAccountKind(a.Kind) {
+		errs.add("kind", "must be %s, %s, %s or %s", AccountBank, AccountCash, AccountCreditCard, AccountWallet)
+	}
+
+	a.Currency = strings.ToUpper(strings.TrimSpace(a.Currency))
+	if err := validateCurrency(a.Currency); err != nil {
+		errs.add("currency", "must be a three-letter ISO 4217 code")
+	} else {
+		scale := currencyScale(a.Currency)
+		balance, ok := a.OpeningBalance.Rescale(scale)
+		switch {
+		case !ok:
+			errs.add("openingBalance", "must have at most %d decimal places for %s", scale, a.Currency)
+		case balance.Cmp(maxAmount) >= 0 || balance.Neg().Cmp(maxAmount) >= 0:
+			errs.add("openingBalance", "must be less than %s in magnitude", maxAmount)
+		default:
+			a.OpeningBalance = balance
+		}
+	}
+	a.Balance = nil
+	return errs.err()
+}
+
+// withBalances fills in the balance of every account.
+func (s *server) withBalances(ctx context.Context, accounts []Account) error {
+	totals, err := s.store.AccountTotals(ctx)
+	if err != nil {
+		return err
+	}
+	byID := make(map[primitive.ObjectID]Money, len(totals))
+	for _, t := range totals {
+		byID[t.AccountID] = t.Total
+	}
+	for i := range accounts {
+		a := &accounts[i]
+		balance, err := a.OpeningBalance.checkedAdd(byID[a.ID])
+		if err != nil {
+			return fmt.Errorf("balance of account %s: %w", a.ID.Hex(), err)
+		}
+		balance = balance.atLeastScale(currencyScale(a.Currency))
+		a.Balance = &balance
+	}
+	return nil
+}
+
+func writeAccount(w http.ResponseWriter, status int, a Account) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(a)
+}
+
+// getAccounts handles GET /accounts. Every account carries its current
+// balance.
+func (s *server) getAccounts(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	accounts, err := s.store.ListAccounts(ctx)
+	if err == nil {
+		err = s.withBalances(ctx, accounts)
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(accounts)
+}
+
+// createAccount handles POST /accounts.
+func (s *server) createAccount(w http.ResponseWriter, r *http.Request) {
+	var a Account
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	a.ID = primitive.NilObjectID
+	if err := a.validate(); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := s.store.CreateAccount(ctx, &a); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	s.audit(ctx, r, OpCreate, ResourceAccount, a.ID.Hex(), nil, a)
+
+	a.Balance = &a.OpeningBalance
+	writeAccount(w, http.StatusCreated, a)
+}
+
+// getAccount handles GET /accounts/{id}.
+func (s *server) getAccount(w http.ResponseWriter, r *http.Request) {
+	id, err := objectIDFromPath(r, "/accounts/")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	a, err := s.store.GetAccount(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "account not found")
+		return
+	}
+	if err == nil {
+		accounts := []Account{a}
+		err = s.withBalances(ctx, accounts)
+		a = accounts[0]
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	writeAccount(w, http.StatusOK, a)
+}
+
+// replaceAccount handles PUT /accounts/{id}. The currency of an account that
+// has transactions cannot change.
+func (s *server) replaceAccount(w http.ResponseWriter, r *http.Request) {
+	id, err := objectIDFromPath(r, "/accounts/")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	var a Account
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	a.ID = id
+	if err := a.validate(); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	current, err := s.store.GetAccount(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "account not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if a.Currency != current.Currency {
+		used, err := s.store.List(ctx, ListOptions{Filter: TransactionFilter{AccountIDs: []primitive.ObjectID{id}, Scope: scopeAll}, Limit: 1})
+		if err != nil {
+			writeInternalError(w, err)
+			return
+		}
+		if len(used) > 0 {
+			writeError(w, http.StatusConflict, CodeConflict, "cannot change the currency of an account that has transactions")
+			return
+		}
+	}
+
+	err = s.store.UpdateAccount(ctx, a)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "account not found")
+		return
+	}
+	if err == nil {
+		s.audit(ctx, r, OpUpdate, ResourceAccount, id.Hex(), current, a)
+		accounts := []Account{a}
+		err = s.withBalances(ctx, accounts)
+		a = accounts[0]
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	writeAccount(w, http.StatusOK, a)
+}
+
+// deleteAccount handles DELETE /accounts/{id}.
+func (s *server) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	id, err := objectIDFromPath(r, "/accounts/")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	current, err := s.store.GetAccount(ctx, id)
+	if err == nil {
+		err = s.store.DeleteAccount(ctx, id)
+	}
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, CodeNotFound, "account not found")
+	case errors.Is(err, ErrAccountInUse):
+		writeError(w, http.StatusConflict, CodeInUse, "account is used by transactions; delete or move them first")
+	case err != nil:
+		writeInternalError(w, err)
+	default:
+		s.audit(ctx, r, OpDelete, ResourceAccount, id.Hex(), current, nil)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
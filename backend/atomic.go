@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// unitOfWork is implemented by stores that can make several changes, and
+// the audit entries that record them, as one write.
+type unitOfWork interface {
+	// Atomically runs fn; store calls made with the context it is given
+	// take effect together when fn returns nil and are discarded otherwise.
+	// A unit that clashes with a concurrent write may be discarded and fn
+	// run again; if it keeps clashing, Atomically returns ErrWriteConflict.
+	Atomically(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// ErrWriteConflict is returned by Atomically when a unit of work kept
+// clashing with concurrent writes and was given up.
+var ErrWriteConflict = errors.New("write conflict with a concurrent change")
+
+// atomically runs fn as one unit of work if the store supports it, and
+// otherwise simply runs it. An audit entry that cannot be recorded fails
+// the unit, so no change is kept without its entry.
+func (s *server) atomically(ctx context.Context, fn func(ctx context.Context) error) error {
+	u, ok := s.store.(unitOfWork)
+	if !ok {
+		return fn(ctx)
+	}
+	return u.Atomically(ctx, func(ctx context.Context) error {
+		failed := new(error)
+		if err := fn(context.WithValue(ctx, auditFailureKey{}, failed)); err != nil {
+			return err
+		}
+		return *failed
+	})
+}
+
+// auditFailureKey marks a unit of work; its value keeps the first failure to
+// record an audit entry in it.
+type auditFailureKey struct{}
+
+// errRequestFailed rolls back the unit of work of a request that failed.
+var errRequestFailed = errors.New("request failed")
+
+// atomicRequests handles every request that may change data as one unit of
+// work, so that its changes and their audit entries are stored together. An
+// error response discards the changes. The response is held back until the
+// changes are stored, so a client never sees a success that was not kept.
+func (s *server) atomicRequests(next http.Handler) http.Handler {
+	if _, ok := s.store.(unitOfWork); !ok {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet, r.Method == http.MethodHead, r.Method == http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		case r.Method == http.MethodPost && r.URL.Path == "/transactions" && r.Header.Get("Idempotency-Key") != "":
+			// idempotent reserves the key before the unit of work, where a
+			// concurrent retry can see it, and then runs the unit itself.
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		buf := &bufferedResponse{header: w.Header().Clone()}
+		s.serveAtomically(buf, r, next)
+		buf.flush(w)
+	})
+}
+
+// serveAtomically handles r with next as one unit of work and writes the
+// response to buf. The request body is read up front, so that the handler
+// can be run again when the store retries the unit.
+func (s *server) serveAtomically(buf *bufferedResponse, r *http.Request, next http.Handler) {
+	if _, ok := s.store.(unitOfWork); !ok {
+		next.ServeHTTP(buf, r)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(buf, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	err = s.atomically(r.Context(), func(ctx context.Context) error {
+		buf.reset()
+		r := r.WithContext(ctx)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(buf, r)
+		switch {
+		case buf.err != nil:
+			// The store error behind a 500 tells the store whether the
+			// unit clashed with another and is worth running again.
+			return buf.err
+		case buf.status >= 400:
+			return errRequestFailed
+		}
+		return nil
+	})
+	switch {
+	case err == nil, errors.Is(err, errRequestFailed), buf.err != nil && errors.Is(err, buf.err):
+		// The response already reports the outcome.
+	case errors.Is(err, ErrWriteConflict):
+		buf.reset()
+		writeError(buf, http.StatusConflict, CodeConflict, "the request clashed with a concurrent change; retry it")
+	default:
+		buf.reset()
+		writeInternalError(buf, err)
+	}
+}
+
+// bufferedResponse keeps a response in memory until it is flushed.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	// err is the error behind an internal error response.
+	err error
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// reset discards the response so far, keeping the headers every response
+// carries.
+func (b *bufferedResponse) reset() {
+	b.status = 0
+	b.body.Reset()
+	b.err = nil
+	for _, k := range []string{"Content-Type", "ETag", "Location", "Idempotent-Replayed"} {
+		b.header.Del(k)
+	}
+}
+
+func (b *bufferedResponse) flush(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
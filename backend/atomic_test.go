@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileRequestStoresChangeWithAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	fs, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer()
+	s.store = fs
+
+	w := do(s.routes().ServeHTTP, http.MethodPost, "/transactions", rentBody, map[string]string{"X-User": "asha"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: got %d %s", w.Code, w.Body)
+	}
+
+	reopened, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	list, err := reopened.List(ctx, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := reopened.ListAudit(ctx, AuditFilter{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || len(entries) != 1 {
+		t.Fatalf("stored %d transactions and %d audit entries, want 1 of each", len(list), len(entries))
+	}
+	if entries[0].ResourceID != list[0].ID.Hex() || entries[0].Actor != "asha" {
+		t.Errorf("audit entry = %+v, want the creation of %s by asha", entries[0], list[0].ID.Hex())
+	}
+}
+
+func TestFileAtomicallyRollsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	fs, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	failure := errors.New("later step failed")
+
+	err = fs.Atomically(ctx, func(ctx context.Context) error {
+		tx := Transaction{Description: "Rent", Amount: mustMoney(t, "4500"), Currency: "INR", Type: TypeExpense}
+		if err := fs.Create(ctx, &tx); err != nil {
+			return err
+		}
+		if err := fs.AppendAudit(ctx, &AuditEntry{Operation: OpCreate, Resource: ResourceTransaction}); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("Atomically = %v, want %v", err, failure)
+	}
+
+	for _, store := range []*fileStore{fs, mustReopen(t, path)} {
+		list, _ := store.List(ctx, ListOptions{})
+		entries, _ := store.ListAudit(ctx, AuditFilter{}, 0)
+		if len(list) != 0 || len(entries) != 0 {
+			t.Errorf("kept %d transactions and %d audit entries after a rollback", len(list), len(entries))
+		}
+	}
+}
+
+func TestFileAtomicallyHidesUnitFromReaders(t *testing.T) {
+	fs, err := newFileStore(filepath.Join(t.TempDir(), "data.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	created, release := make(chan struct{}), make(chan struct{})
+	unit := make(chan error)
+	go func() {
+		unit <- fs.Atomically(ctx, func(ctx context.Context) error {
+			tx := Transaction{Description: "Rent", Amount: mustMoney(t, "4500"), Currency: "INR", Type: TypeExpense}
+			if err := fs.Create(ctx, &tx); err != nil {
+				return err
+			}
+			close(created)
+			<-release
+			return errors.New("later step failed")
+		})
+	}()
+	<-created
+
+	read := make(chan int)
+	go func() {
+		list, _ := fs.List(ctx, ListOptions{})
+		read <- len(list)
+	}()
+	select {
+	case n := <-read:
+		t.Fatalf("read %d transactions while the unit was running", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-unit; err == nil {
+		t.Fatal("Atomically succeeded, want the unit's error")
+	}
+	if n := <-read; n != 0 {
+		t.Errorf("read %d transactions after the unit was rolled back, want 0", n)
+	}
+}
+
+func mustReopen(t *testing.T, path string) *fileStore {
+	t.Helper()
+	s, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestMemoryAuditLogRetention(t *testing.T) {
+	tests := []struct {
+		limit, want int
+	}{
+		{0, 250},
+		{100, 100},
+	}
+	for _, tt := range tests {
+		s := newMemoryStore()
+		s.auditLimit = tt.limit
+		ctx := context.Background()
+		var first, last AuditEntry
+		for i := 0; i < 250; i++ {
+			last = AuditEntry{Operation: OpUpdate, Resource: ResourceTransaction}
+			if err := s.AppendAudit(ctx, &last); err != nil {
+				t.Fatal(err)
+			}
+			if i == 0 {
+				first = last
+			}
+		}
+		entries, err := s.ListAudit(ctx, AuditFilter{}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != tt.want {
+			t.Errorf("limit %d: kept %d entries, want %d", tt.limit, len(entries), tt.want)
+		}
+		if entries[0].ID != last.ID {
+			t.Errorf("limit %d: the newest entry was dropped", tt.limit)
+		}
+		if kept := entries[len(entries)-1].ID == first.ID; kept != (tt.limit == 0) {
+			t.Errorf("limit %d: oldest entry kept = %v", tt.limit, kept)
+		}
+	}
+}
+
+// failingAudit cannot record audit entries.
+type failingAudit struct {
+	*fileStore
+}
+
+func (failingAudit) AppendAudit(context.Context, *AuditEntry) error {
+	return errors.New("disk full")
+}
+
+func TestFileRequestFailsWithoutAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	s := newTestServer()
+	s.store = failingAudit{mustReopen(t, path)}
+
+	w := do(s.routes().ServeHTTP, http.MethodPost, "/transactions", rentBody, nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("create without audit: got %d %s, want 500", w.Code, w.Body)
+	}
+	if list, _ := mustReopen(t, path).List(context.Background(), ListOptions{}); len(list) != 0 {
+		t.Errorf("stored %d transactions whose creation was not audited", len(list))
+	}
+}
+
+// BenchmarkFileCreateWithLargeAuditLog measures a write request when the
+// data file holds a large audit log, which is rewritten with every change.
+func BenchmarkFileCreateWithLargeAuditLog(b *testing.B) {
+	fs, err := newFileStore(filepath.Join(b.TempDir(), "data.json"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+	err = fs.Atomically(ctx, func(ctx context.Context) error {
+		for i := 0; i < 10000; i++ {
+			tx := Transaction{Description: "Rent", Amount: Money{units: 450000, scale: 2}, Currency: "INR", Type: TypeExpense}
+			e := AuditEntry{Operation: OpCreate, Resource: ResourceTransaction, After: snapshotOf(tx)}
+			if err := fs.AppendAudit(ctx, &e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	s := newTestServer()
+	s.store = fs
+	routes := s.routes()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if w := do(routes.ServeHTTP, http.MethodPost, "/transactions", rentBody, nil); w.Code != http.StatusCreated {
+			b.Fatalf("create: got %d %s", w.Code, w.Body)
+		}
+	}
+}
+
+// errClash stands for a store error that makes a unit of work worth running
+// again.
+var errClash = errors.New("write conflict")
+
+// clashingStore fails the first clashes calls to Create with errClash and
+// runs a unit of work again, up to twice, when it fails with errClash.
+type clashingStore struct {
+	Store
+	clashes int
+}
+
+func (c *clashingStore) Atomically(ctx context.Context, fn func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if !errors.Is(err, errClash) {
+			return err
+		}
+		if attempt == 2 {
+			return ErrWriteConflict
+		}
+	}
+}
+
+func (c *clashingStore) Create(ctx context.Context, t *Transaction) error {
+	if c.clashes > 0 {
+		c.clashes--
+		return errClash
+	}
+	return c.Store.Create(ctx, t)
+}
+
+func TestRequestRetriedAfterClash(t *testing.T) {
+	tests := []struct {
+		name    string
+		clashes int
+		status  int
+		stored  int
+	}{
+		{"no clash", 0, http.StatusCreated, 1},
+		{"clash once", 1, http.StatusCreated, 1},
+		{"clash every time", 2, http.StatusConflict, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer()
+			s.store = &clashingStore{Store: s.store, clashes: tt.clashes}
+
+			w := do(s.routes().ServeHTTP, http.MethodPost, "/transactions", rentBody, nil)
+			if w.Code != tt.status {
+				t.Fatalf("create: got %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+			if w.Code == http.StatusConflict {
+				var body errorBody
+				decode(t, w, &body)
+				if body.Error.Code != CodeConflict {
+					t.Errorf("code = %q, want %q", body.Error.Code, CodeConflict)
+				}
+			}
+			list, err := s.store.List(context.Background(), ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list) != tt.stored {
+				t.Fatalf("stored %d transactions, want %d", len(list), tt.stored)
+			}
+			if tt.stored > 0 && list[0].Description != "Rent" {
+				t.Errorf("stored %+v, want the request body read again", list[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Audit operations.
+const (
+	OpCreate  = "create"
+	OpUpdate  = "update"
+	OpDelete  = "delete"
+	OpRestore = "restore"
+	OpPause   = "pause"
+	OpResume  = "resume"
+	OpSkip    = "skip"
+	OpRename  = "rename"
+	OpPurge   = "purge"
+)
+
+// Audited resources.
+const (
+	ResourceTransaction = "transaction"
+	ResourceAccount     = "account"
+	ResourceCategory    = "category"
+	ResourceRule        = "rule"
+	ResourceTag         = "tag"
+	ResourceBudget      = "budget"
+	ResourceRecurring   = "recurring"
+	ResourceRate        = "rate"
+	ResourceTrash       = "trash"
+)
+
+const (
+	// anonymousActor is recorded for requests without an X-User header.
+	anonymousActor = "anonymous"
+	// schedulerActor is recorded for changes made by the background jobs.
+	schedulerActor = "scheduler"
+	// migrationActor is recorded for changes made by migrate-amounts.
+	migrationActor = "migrate-amounts"
+	// legacyImportActor and rateImportActor are recorded for the records
+	// written by import-legacy and import-rates.
+	legacyImportActor = "import-legacy"
+	rateImportActor   = "import-rates"
+
+	maxActorLength     = 100
+	maxRequestIDLength = 100
+)
+
+// AuditEntry records one change. Entries are only ever appended.
+type AuditEntry struct {
+	ID        primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	Time      time.Time          `json:"time" bson:"time"`
+	Actor     string             `json:"actor" bson:"actor"`
+	ClientIP  string             `json:"clientIp,omitempty" bson:"clientIp,omitempty"`
+	RequestID string             `json:"requestId,omitempty" bson:"requestId,omitempty"`
+	Operation string             `json:"operation" bson:"operation"`
+	Resource  string             `json:"resource" bson:"resource"`
+	// ResourceID is the hex ID of the changed record, or another key for
+	// records without one, such as FROM/TO/DATE for exchange rates.
+	ResourceID string `json:"resourceId,omitempty" bson:"resourceId,omitempty"`
+	// Before and After are the record as the API returned it before and
+	// after the change; Before is empty on creation and After on deletion.
+	Before snapshot `json:"before,omitempty" bson:"before,omitempty"`
+	After  snapshot `json:"after,omitempty" bson:"after,omitempty"`
+}
+
+// AuditFilter narrows down the audit log. Empty fields match everything.
+type AuditFilter struct {
+	Actor      string
+	Operation  string
+	Resource   string
+	ResourceID string
+	From, To   *time.Time
+	// Before, when set, skips every entry up to and including this one in
+	// newest-first order.
+	Before *primitive.ObjectID
+}
+
+func (f AuditFilter) matches(e AuditEntry) bool {
+	switch {
+	case f.Actor != "" && e.Actor != f.Actor,
+		f.Operation != "" && e.Operation != f.Operation,
+		f.Resource != "" && e.Resource != f.Resource,
+		f.ResourceID != "" && e.ResourceID != f.ResourceID,
+		f.From != nil && e.Time.Before(*f.From),
+		f.To != nil && e.Time.After(*f.To),
+		f.Before != nil && bytes.Compare(e.ID[:], f.Before[:]) >= 0:
+		return false
+	}
+	return true
+}
+
+// AuditStore keeps the append-only audit log.
+type AuditStore interface {
+	// AppendAudit stores e and assigns it a new ID.
+	AppendAudit(ctx context.Context, e *AuditEntry) error
+	// ListAudit returns the entries matching f, newest first. Zero limit
+	// means no limit.
+	ListAudit(ctx context.Context, f AuditFilter, limit int) ([]AuditEntry, error)
+}
+
+// snapshot is a JSON document stored as a BSON subdocument, so the records
+// of every resource can be kept exactly as the API rendered them.
+type snapshot json.RawMessage
+
+func snapshotOf(v interface{}) snapshot {
+	if v == nil {
+		return nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return snapshot(raw)
+}
+
+func (s snapshot) MarshalJSON() ([]byte, error) {
+	if len(s) == 0 {
+		return []byte("null"), nil
+	}
+	return s, nil
+}
+
+func (s *snapshot) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*s = nil
+		return nil
+	}
+	*s = append((*s)[:0], b...)
+	return nil
+}
+
+func (s snapshot) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON(s, false, &doc); err != nil {
+		return 0, nil, err
+	}
+	return bson.MarshalValue(doc)
+}
+
+func (s *snapshot) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	if t != bsontype.EmbeddedDocument {
+		return fmt.Errorf("cannot decode %v into a snapshot", t)
+	}
+	raw, err := bson.MarshalExtJSON(bson.Raw(data), false, false)
+	if err != nil {
+		return err
+	}
+	*s = snapshot(raw)
+	return nil
+}
+
+// origin identifies who made a change.
+type origin struct {
+	Actor, ClientIP, RequestID string
+}
+
+// requestOrigin reads the actor from the X-User header, the client address
+// from the connection, and the request ID set by requestIDMiddleware. The
+// headers are not authenticated; they name who made a change among people
+// who share the ledger.
+func (s *server) requestOrigin(r *http.Request) origin {
+	o := origin{Actor: strings.TrimSpace(r.Header.Get("X-User")), RequestID: requestID(r.Context())}
+	if o.Actor == "" || utf8.RuneCountInString(o.Actor) > maxActorLength {
+		o.Actor = anonymousActor
+	}
+	o.ClientIP = s.clientIP(r)
+	return o
+}
+
+// clientIP returns the address of the connection. When that is one of the
+// trusted proxies, it instead returns the last address in X-Forwarded-For
+// that was not added by a trusted proxy, as clients can put anything in the
+// header before it reaches the first proxy.
+func (s *server) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !s.trustedProxy(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		host = hop
+		if !s.trustedProxy(hop) {
+			break
+		}
+	}
+	return host
+}
+
+func (s *server) trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range s.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type requestIDKey struct{}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDMiddleware gives every request an ID, taken from the X-Request-ID
+// header when the client sends a usable one, and echoes it in the response.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(r.Header.Get("X-Request-ID"))
+		if id == "" || len(id) > maxRequestIDLength || strings.ContainsAny(id, "\r\n") {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// audit records a change made by the request r. Inside a unit of work,
+// failing to record it fails the unit; otherwise the failure is logged, as
+// the change itself has already been made.
+func (s *server) audit(ctx context.Context, r *http.Request, op, resource, id string, before, after interface{}) {
+	s.appendAudit(ctx, s.requestOrigin(r), op, resource, id, before, after)
+}
+
+func (s *server) appendAudit(ctx context.Context, o origin, op, resource, id string, before, after interface{}) {
+	e := AuditEntry{
+		Time:       time.Now().UTC(),
+		Actor:      o.Actor,
+		ClientIP:   o.ClientIP,
+		RequestID:  o.RequestID,
+		Operation:  op,
+		Resource:   resource,
+		ResourceID: id,
+		Before:     snapshotOf(before),
+		After:      snapshotOf(after),
+	}
+	if err := s.store.AppendAudit(ctx, &e); err != nil {
+		log.Printf("Audit: recording %s %s %s: %v", op, resource, id, err)
+		if failed, ok := ctx.Value(auditFailureKey{}).(*error); ok && *failed == nil {
+			*failed = fmt.Errorf("recording %s %s %s: %w", op, resource, id, err)
+		}
+	}
+}
+
+// auditUpdates records an update for every transaction in before that has
+// a new version among those now matching f, so that a change made to many
+// transactions at once leaves an entry for each of them. Like audit, it
+// logs rather than reports failures.
+func (s *server) auditUpdates(ctx context.Context, o origin, before []Transaction, f TransactionFilter) {
+	if len(before) == 0 {
+		return
+	}
+	after, err := s.store.List(ctx, ListOptions{Filter: f})
+	if err != nil {
+		log.Printf("Audit: listing %d changed transactions: %v", len(before), err)
+		return
+	}
+	previous := make(map[primitive.ObjectID]Transaction, len(before))
+	for _, t := range before {
+		previous[t.ID] = t
+	}
+	for _, t := range after {
+		if p, ok := previous[t.ID]; ok && p.Version != t.Version {
+			s.appendAudit(ctx, o, OpUpdate, ResourceTransaction, t.ID.Hex(), p, t)
+		}
+	}
+}
+
+// auditPage is the response body of GET /audit.
+type auditPage struct {
+	Entries []AuditEntry `json:"entries"`
+	Next    string       `json:"next,omitempty"`
+}
+
+// getAudit handles GET /audit. It filters on actor, operation, resource,
+// resourceId, from and to, and pages newest first with limit and cursor.
+func (s *server) getAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := AuditFilter{
+		Actor:      q.Get("actor"),
+		Operation:  q.Get("operation"),
+		Resource:   q.Get("resource"),
+		ResourceID: q.Get("resourceId"),
+	}
+	var err error
+	if f.From, err = parseFilterTime(q, "from", false); err == nil {
+		f.To, err = parseFilterTime(q, "to", true)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if v := q.Get("cursor"); v != "" {
+		id, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "invalid cursor")
+			return
+		}
+		f.Before = &id
+	}
+	limit := defaultPageSize
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxPageSize {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("limit must be an integer between 1 and %d", maxPageSize))
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	entries, err := s.store.ListAudit(ctx, f, limit+1)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	page := auditPage{Entries: entries}
+	if len(entries) > limit {
+		page.Entries = entries[:limit]
+		next := url.Values{}
+		for k, v := range q {
+			next[k] = v
+		}
+		next.Set("cursor", page.Entries[limit-1].ID.Hex())
+		page.Next = r.URL.Path + "?" + next.Encode()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(page)
+}
+
+// getTransactionHistory handles GET /transactions/{id}/history, listing the
+// changes to one transaction oldest first. It also works for transactions in
+// the trash or already purged.
+func (s *server) getTransactionHistory(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/transactions/"), "/history")
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "invalid ID format")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	entries, err := s.store.ListAudit(ctx, AuditFilter{Resource: ResourceTransaction, ResourceID: objID.Hex()}, 0)
+	if err == nil && len(entries) == 0 {
+		// Transactions created before the audit log existed have no
+		// history yet.
+		_, err = s.store.Get(ctx, objID)
+	}
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "transaction not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(auditPage{Entries: entries})
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBulkChangesAuditEachTransaction(t *testing.T) {
+	s := newTestServer()
+	ctx := context.Background()
+	routes := s.routes().ServeHTTP
+
+	food := Category{Name: "Food", Kind: TypeExpense}
+	dining := Category{Name: "Dining", Kind: TypeExpense}
+	for _, c := range []*Category{&food, &dining} {
+		if err := s.store.CreateCategory(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var ids []string
+	for _, d := range []string{"Lunch", "Dinner", "Rent"} {
+		tx := Transaction{Description: d, Amount: mustMoney(t, "100"), Currency: "INR", Type: TypeExpense}
+		if d != "Rent" {
+			tx.CategoryID = &dining.ID
+			tx.Tags = []string{"trip"}
+		}
+		if err := s.store.Create(ctx, &tx); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, tx.ID.Hex())
+	}
+
+	w := do(routes, http.MethodPost, "/tags/rename", `{"from":"trip","to":"travel"}`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("rename: got %d %s", w.Code, w.Body)
+	}
+	w = do(routes, http.MethodDelete, "/categories/"+dining.ID.Hex()+"?reassignTo="+food.ID.Hex(), "", nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("delete category: got %d %s", w.Code, w.Body)
+	}
+
+	entries, err := s.store.ListAudit(ctx, AuditFilter{Operation: OpUpdate, Resource: ResourceTransaction}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := make(map[string]int)
+	for _, e := range entries {
+		updates[e.ResourceID]++
+	}
+	want := map[string]int{ids[0]: 2, ids[1]: 2}
+	if len(updates) != len(want) || updates[ids[0]] != 2 || updates[ids[1]] != 2 {
+		t.Errorf("updates per transaction = %v, want %v", updates, want)
+	}
+
+	var last Transaction
+	if err := json.Unmarshal(entries[0].After, &last); err != nil {
+		t.Fatal(err)
+	}
+	if last.CategoryID == nil || *last.CategoryID != food.ID {
+		t.Errorf("newest entry records category %v, want %s", last.CategoryID, food.ID.Hex())
+	}
+}
+
+func TestMigrateAmountsAuditsEachTransaction(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data.json")
+	fs := mustReopen(t, path)
+	legacy := Transaction{Description: "Legacy", Amount: mustMoney(t, "12.5"), Type: TypeIncome}
+	current := Transaction{Description: "Current", Amount: mustMoney(t, "7.00"), Currency: "INR", Type: TypeIncome}
+	for _, tx := range []*Transaction{&legacy, &current} {
+		if err := fs.Create(ctx, tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := config{StoreBackend: "file", DataFile: path, BaseCurrency: "INR"}
+	if code := runMigrateAmounts(cfg, nil); code != 0 {
+		t.Fatalf("migrate-amounts exited with %d", code)
+	}
+
+	entries, err := mustReopen(t, path).ListAudit(ctx, AuditFilter{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ResourceID != legacy.ID.Hex() || entries[0].Actor != migrationActor {
+		t.Errorf("audit entries = %+v, want one update of %s by %s", entries, legacy.ID.Hex(), migrationActor)
+	}
+}
+
+func TestGetAudit(t *testing.T) {
+	s := newTestServer()
+	ctx := context.Background()
+	routes := s.routes().ServeHTTP
+	day := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	// name is "operation resource day" for each entry, oldest first.
+	var names []string
+	for i, e := range []AuditEntry{
+		{Operation: OpCreate, Resource: ResourceTransaction},
+		{Operation: OpCreate, Resource: ResourceCategory},
+		{Operation: OpUpdate, Resource: ResourceTransaction},
+		{Operation: OpDelete, Resource: ResourceTransaction},
+		{Operation: OpUpdate, Resource: ResourceRate},
+	} {
+		e.Actor = "asha"
+		e.Time = day.AddDate(0, 0, i)
+		if err := s.store.AppendAudit(ctx, &e); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, e.Operation+" "+e.Resource+" "+e.Time.Format("2006-01-02"))
+	}
+	list := func(path string) auditPage {
+		t.Helper()
+		w := do(routes, http.MethodGet, path, "", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: got %d %s", path, w.Code, w.Body)
+		}
+		var page auditPage
+		decode(t, w, &page)
+		return page
+	}
+	listed := func(page auditPage) string {
+		var got []string
+		for _, e := range page.Entries {
+			got = append(got, e.Operation+" "+e.Resource+" "+e.Time.Format("2006-01-02"))
+		}
+		return strings.Join(got, ", ")
+	}
+
+	tests := []struct {
+		query string
+		// want are indexes into names, newest first.
+		want []int
+	}{
+		{"", []int{4, 3, 2, 1, 0}},
+		{"resource=transaction", []int{3, 2, 0}},
+		{"operation=create", []int{1, 0}},
+		{"operation=update&resource=transaction", []int{2}},
+		{"from=2025-03-02&to=2025-03-04", []int{3, 2, 1}},
+		{"from=2025-03-03T09:00:00Z", []int{4, 3, 2}},
+		{"actor=ravi", nil},
+	}
+	for _, tt := range tests {
+		var want []string
+		for _, i := range tt.want {
+			want = append(want, names[i])
+		}
+		if got := listed(list("/audit?" + tt.query)); got != strings.Join(want, ", ") {
+			t.Errorf("GET /audit?%s = %s, want %s", tt.query, got, strings.Join(want, ", "))
+		}
+	}
+
+	// Paging keeps the filters and lists every entry once, newest first.
+	var pages []string
+	for path := "/audit?resource=transaction&limit=2"; path != ""; {
+		page := list(path)
+		pages = append(pages, listed(page))
+		path = page.Next
+	}
+	want := []string{names[3] + ", " + names[2], names[0]}
+	if strings.Join(pages, " | ") != strings.Join(want, " | ") {
+		t.Errorf("pages = %q, want %q", pages, want)
+	}
+
+	for _, query := range []string{"cursor=nope", "limit=0", "from=yesterday"} {
+		if w := do(routes, http.MethodGet, "/audit?"+query, "", nil); w.Code != http.StatusBadRequest {
+			t.Errorf("GET /audit?%s: got %d %s, want 400", query, w.Code, w.Body)
+		}
+	}
+}
+
+func TestGetTransactionHistory(t *testing.T) {
+	s := newTestServer()
+	routes := s.routes().ServeHTTP
+	path := createRent(t, s.routes())
+	for _, step := range []struct{ method, body string }{
+		{http.MethodPatch, `{"description":"Rent for March"}`},
+		{http.MethodDelete, ""},
+		{http.MethodPost, ""},
+	} {
+		target := path
+		if step.method == http.MethodPost {
+			target += "/restore"
+		}
+		if w := do(routes, step.method, target, step.body, nil); w.Code >= 300 {
+			t.Fatalf("%s %s: got %d %s", step.method, target, w.Code, w.Body)
+		}
+	}
+
+	w := do(routes, http.MethodGet, path+"/history", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s/history: got %d %s", path, w.Code, w.Body)
+	}
+	var page auditPage
+	decode(t, w, &page)
+	var got []string
+	for _, e := range page.Entries {
+		got = append(got, e.Operation)
+	}
+	if want := []string{OpCreate, OpUpdate, OpDelete, OpRestore}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("history = %v, want %v oldest first", got, want)
+	}
+
+	// A transaction from before the audit log has an empty history.
+	tx := Transaction{Description: "Old", Amount: mustMoney(t, "10"), Currency: "INR", Type: TypeExpense}
+	if err := s.store.Create(context.Background(), &tx); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		id     string
+		status int
+	}{
+		{tx.ID.Hex(), http.StatusOK},
+		{"0123456789abcdef01234567", http.StatusNotFound},
+		{"nope", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := do(routes, http.MethodGet, "/transactions/"+tt.id+"/history", "", nil)
+		if w.Code != tt.status {
+			t.Errorf("GET /transactions/%s/history: got %d %s, want %d", tt.id, w.Code, w.Body, tt.status)
+		}
+	}
+}
+
+func TestRateChangesAudited(t *testing.T) {
+	s := newTestServer()
+	ctx := context.Background()
+	routes := s.routes().ServeHTTP
+	for _, body := range []string{
+		`[{"from":"USD","to":"INR","date":"2025-03-01","rate":"86.5"}]`,
+		`[{"from":"USD","to":"INR","date":"2025-03-01","rate":"87"},{"from":"USD","to":"INR","date":"2025-03-02","rate":"87.2"}]`,
+	} {
+		if w := do(routes, http.MethodPost, "/rates", body, nil); w.Code != http.StatusOK {
+			t.Fatalf("POST /rates: got %d %s", w.Code, w.Body)
+		}
+	}
+	if w := do(routes, http.MethodDelete, "/rates?from=USD&to=INR&date=2025-03-01", "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE /rates: got %d %s", w.Code, w.Body)
+	}
+
+	entries, err := s.store.ListAudit(ctx, AuditFilter{Resource: ResourceRate}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rate := func(s snapshot) string {
+		if s == nil {
+			return "-"
+		}
+		var r ExchangeRate
+		if err := json.Unmarshal(s, &r); err != nil {
+			t.Fatal(err)
+		}
+		return r.Rate.String()
+	}
+	var got []string
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		got = append(got, e.Operation+" "+e.ResourceID+" "+rate(e.Before)+" "+rate(e.After))
+	}
+	want := []string{
+		"create USD/INR/2025-03-01 - 86.5",
+		"update USD/INR/2025-03-01 86.5 87",
+		"create USD/INR/2025-03-02 - 87.2",
+		"delete USD/INR/2025-03-01 87 -",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("rate audit entries:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestImportRatesAuditsEachRate(t *testing.T) {
+	dir := t.TempDir()
+	path, csv := filepath.Join(dir, "data.json"), filepath.Join(dir, "rates.csv")
+	if err := os.WriteFile(csv, []byte("date,from,to,rate\n2025-03-01,USD,INR,86.5\n2025-03-01,EUR,INR,93.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config{StoreBackend: "file", DataFile: path, BaseCurrency: "INR"}
+	if code := runImportRates(cfg, []string{"-file", csv}); code != 0 {
+		t.Fatalf("import-rates exited with %d", code)
+	}
+
+	entries, err := mustReopen(t, path).ListAudit(context.Background(), AuditFilter{Resource: ResourceRate}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Actor != rateImportActor || entries[1].Actor != rateImportActor {
+		t.Errorf("audit entries = %+v, want two rates created by %s", entries, rateImportActor)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, remote, forwarded, want string
+		trusted                       []*net.IPNet
+	}{
+		{"no proxies configured", "203.0.113.5:4000", "198.51.100.7", "203.0.113.5", nil},
+		{"untrusted peer", "203.0.113.5:4000", "198.51.100.7", "203.0.113.5", proxies},
+		{"trusted peer", "10.1.2.3:4000", "198.51.100.7", "198.51.100.7", proxies},
+		{"spoofed first hop", "10.1.2.3:4000", "1.2.3.4, 198.51.100.7", "198.51.100.7", proxies},
+		{"chain of proxies", "192.0.2.1:4000", "198.51.100.7, 10.9.9.9", "198.51.100.7", proxies},
+		{"trusted peer without header", "10.1.2.3:4000", "", "10.1.2.3", proxies},
+	}
+	for _, tt := range tests {
+		s := &server{trustedProxies: tt.trusted}
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := s.clientIP(r); got != tt.want {
+			t.Errorf("%s: clientIP = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseTrustedProxiesRejectsGarbage(t *testing.T) {
+	if _, err := parseTrustedProxies("10.0.0.0/8,proxy.local"); err == nil {
+		t.Error("want an error for a host name")
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Budget group kinds.
+const (
+	GroupOverall     = "overall"     // every expense
+	GroupCategory    = "category"    // expenses in a category and its subcategories
+	GroupTag         = "tag"         // expenses carrying a tag
+	GroupDescription = "description" // expenses whose description contains a text
+	GroupLabel       = "label"       // split lines with a label
+)
+
+const (
+	maxBudgetNameLength = 100
+	defaultWarnAt       = 80
+	maxBudgetMonths     = 120
+)
+
+// Budget is a monthly spending limit, in the base currency, for a group of
+// expenses.
+type Budget struct {
+	ID     primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Name   string             `json:"name" bson:"name"`
+	Group  BudgetGroup        `json:"group" bson:"group"`
+	Amount Money              `json:"amount" bson:"amount"`
+	// WarnAt is the percentage of Amount from which a month is flagged as
+	// near the limit.
+	WarnAt int `json:"warnAt" bson:"warnAt"`
+}
+
+// BudgetGroup selects the expenses a budget covers.
+type BudgetGroup struct {
+	Kind       string              `json:"kind" bson:"kind"`
+	CategoryID *primitive.ObjectID `json:"categoryId,omitempty" bson:"categoryId,omitempty"`
+	Tag        string              `json:"tag,omitempty" bson:"tag,omitempty"`
+	// Contains is a case-insensitive substring of the description.
+	Contains string `json:"contains,omitempty" bson:"contains,omitempty"`
+	// Label counts only the split lines with that label.
+	Label string `json:"label,omitempty" bson:"label,omitempty"`
+}
+
+// BudgetStore keeps the budgets.
+type BudgetStore interface {
+	// CreateBudget stores b and assigns it a new ID.
+	CreateBudget(ctx context.Context, b *Budget) error
+	// ListBudgets returns every budget ordered by name.
+	ListBudgets(ctx context.Context) ([]Budget, error)
+	// GetBudget returns the budget with the given ID or ErrNotFound.
+	GetBudget(ctx context.Context, id primitive.ObjectID) (Budget, error)
+	// UpdateBudget replaces the budget that has b.ID, or returns ErrNotFound.
+	UpdateBudget(ctx context.Context, b Budget) error
+	// DeleteBudget removes the budget with the given ID or returns
+	// ErrNotFound.
+	DeleteBudget(ctx context.Context, id primitive.ObjectID) error
+}
+
+// sortBudgets orders budgets by name, then ID.
+func sortBudgets(budgets []Budget) {
+	sort.Slice(budgets, func(i, j int) bool {
+		a, b := budgets[i], budgets[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return bytes.Compare(a.ID[:], b.ID[:]) < 0
+	})
+}
+
+// validate checks b against the base currency and normalises it.
+func (b *Budget) validate(ctx context.Context, categories CategoryStore, currency string) error {
+	var errs validationError
+
+	b.Name = strings.TrimSpace(b.Name)
+	switch n := utf8.RuneCountInString(b.Name); {
+	case n == 0:
+		errs.add("name", "is required")
+	case n > maxBudgetNameLength:
+		errs.add("name", "must be at most %d characters", maxBudgetNameLength)
+	}
+
+	scale := currencyScale(currency)
+	switch {
+	case b.Amount.Sign() <= 0:
+		errs.add("amount", "must be greater than zero")
+	case b.Amount.Cmp(maxAmount) >= 0:
+		errs.add("amount", "must be less than %s", maxAmount)
+	default:
+		if amount, ok := b.Amount.Rescale(scale); ok {
+			b.Amount = amount
+		} else {
+			errs.add("amount", "must have at most %d decimal places for %s", scale, currency)
+		}
+	}
+
+	if b.WarnAt == 0 {
+		b.WarnAt = defaultWarnAt
+	}
+	if b.WarnAt < 1 || b.WarnAt > 100 {
+		errs.add("warnAt", "must be a percentage between 1 and 100")
+	}
+
+	g := &b.Group
+	switch g.Kind {
+	case GroupOverall:
+		g.CategoryID, g.Tag, g.Contains, g.Label = nil, "", "", ""
+	case GroupCategory:
+		g.Tag, g.Contains, g.Label = "", "", ""
+		if g.CategoryID == nil {
+			errs.add("group.categoryId", "is required")
+			break
+		}
+		c, err := categories.GetCategory(ctx, *g.CategoryID)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			errs.add("group.categoryId", "does not exist")
+		case err != nil:
+			return err
+		case c.Kind != TypeExpense:
+			errs.add("group.categoryId", "must be an expense category")
+		}
+	case GroupTag:
+		g.CategoryID, g.Contains, g.Label = nil, "", ""
+		g.Tag = normalizeTag(g.Tag)
+		if !validTag(g.Tag) {
+			errs.add("group.tag", "must be a valid tag")
+		}
+	case GroupDescription:
+		g.CategoryID, g.Tag, g.Label = nil, "", ""
+		g.Contains = strings.TrimSpace(g.Contains)
+		if g.Contains == "" {
+			errs.add("group.contains", "is required")
+		}
+	case GroupLabel:
+		g.CategoryID, g.Tag, g.Contains = nil, "", ""
+		g.Label = strings.TrimSpace(g.Label)
+		if g.Label == "" {
+			errs.add("group.label", "is required")
+		}
+	default:
+		errs.add("group.kind", "must be %s, %s, %s, %s or %s", GroupOverall, GroupCategory, GroupTag, GroupDescription, GroupLabel)
+	}
+	return errs.err()
+}
+
+// filter returns the expenses b covers between from and to.
+func (b Budget) filter(from, to time.Time) TransactionFilter {
+	f := TransactionFilter{Type: TypeExpense, From: &from, To: &to}
+	switch b.Group.Kind {
+	case GroupCategory:
+		f.CategoryIDs = []primitive.ObjectID{*b.Group.CategoryID}
+	case GroupTag:
+		f.TagsAll = []string{b.Group.Tag}
+	case GroupDescription:
+		f.Query = b.Group.Contains
+	case GroupLabel:
+		f.Labels = []string{b.Group.Label}
+	}
+	return f
+}
+
+// BudgetPeriod compares one month of spending against a budget.
+type BudgetPeriod struct {
+	Month     string `json:"month"` // YYYY-MM
+	Budget    Money  `json:"budget"`
+	Spent     Money  `json:"spent"`
+	Remaining Money  `json:"remaining"` // negative when over budget
+	Count     int    `json:"count"`
+	Over      bool   `json:"over"`
+	Near      bool   `json:"near"` // at or above WarnAt percent, not over
+}
+
+// BudgetStatus is the spending history of one budget.
+type BudgetStatus struct {
+	Budget  Budget         `json:"budget"`
+	Periods []BudgetPeriod `json:"periods"`
+}
+
+// budgetStatus computes the spending against b for every month from first to
+// last, both given as month starts.
+func (s *server) budgetStatus(ctx context.Context, b Budget, first, last time.Time) (BudgetStatus, error) {
+	filter := b.filter(first, granularityMonth.next(last).Add(-time.Nanosecond))
+	if err := s.expandCategoryFilter(ctx, &filter); err != nil {
+		return BudgetStatus{}, err
+	}
+	totals, err := s.store.DailyTotals(ctx, filter)
+	if err != nil {
+		return BudgetStatus{}, err
+	}
+	periods, err := aggregatePeriods(ctx, totals, granularityMonth, newConverter(s.store, s.rules.DefaultCurrency))
+	if err != nil {
+		return BudgetStatus{}, err
+	}
+	periods, err = fillPeriods(periods, granularityMonth, &first, &last)
+	if err != nil {
+		return BudgetStatus{}, err
+	}
+
+	scale := currencyScale(s.rules.DefaultCurrency)
+	warn, err := b.Amount.MulRound(Money{units: int64(b.WarnAt), scale: 2}, scale)
+	if err != nil {
+		return BudgetStatus{}, err
+	}
+
+	status := BudgetStatus{Budget: b, Periods: make([]BudgetPeriod, len(periods))}
+	for i, p := range periods {
+		spent := p.Expense.atLeastScale(scale)
+		remaining, err := b.Amount.checkedSub(spent)
+		if err != nil {
+			return BudgetStatus{}, err
+		}
+		over := spent.Cmp(b.Amount) > 0
+		status.Periods[i] = BudgetPeriod{
+			Month:     p.Start.Format("2006-01"),
+			Budget:    b.Amount,
+			Spent:     spent,
+			Remaining: remaining,
+			Count:     p.Count,
+			Over:      over,
+			Near:      !over && spent.Cmp(warn) >= 0,
+		}
+	}
+	return status, nil
+}
+
+func writeBudget(w http.ResponseWriter, status int, b Budget) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(b)
+}
+
+// getBudgets handles GET /budgets.
+func (s *server) getBudgets(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	budgets, err := s.store.ListBudgets(ctx)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(budgets)
+}
+
+// createBudget handles POST /budgets.
+func (s *server) createBudget(w http.ResponseWriter, r *http.Request) {
+	var b Budget
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	b.ID = primitive.NilObjectID
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := b.validate(ctx, s.store, s.rules.DefaultCurrency); err != nil {
+		writeCheckError(w, err)
+		return
+	}
+
+	if err := s.store.CreateBudget(ctx, &b); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	s.audit(ctx, r, OpCreate, ResourceBudget, b.ID.Hex(), nil, b)
+
+	writeBudget(w, http.StatusCreated, b)
+}
+
+// getBudget handles GET /budgets/{id}.
+func (s *server) getBudget(w http.ResponseWriter, r *http.Request) {
+	id, err := objectIDFromPath(r, "/budgets/")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	b, err := s.store.GetBudget(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "budget not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	writeBudget(w, http.StatusOK, b)
+}
+
+// replaceBudget handles PUT /budgets/{id}.
+func (s *server) replaceBudget(w http.ResponseWriter, r *http.Request) {
+	id, err := objectIDFromPath(r, "/budgets/")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	var b Budget
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	b.ID = id
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := b.validate(ctx, s.store, s.rules.DefaultCurrency); err != nil {
+		writeCheckError(w, err)
+		return
+	}
+
+	current, err := s.store.GetBudget(ctx, id)
+	if err == nil {
+		err = s.store.UpdateBudget(ctx, b)
+	}
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "budget not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	s.audit(ctx, r, OpUpdate, ResourceBudget, id.Hex(), current, b)
+
+	writeBudget(w, http.StatusOK, b)
+}
+
+// deleteBudget handles DELETE /budgets/{id}.
+func (s *server) deleteBudget(w http.ResponseWriter, r *http.Request) {
+	id, err := objectIDFromPath(r, "/budgets/")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	current, err := s.store.GetBudget(ctx, id)
+	if err == nil {
+		err = s.store.DeleteBudget(ctx, id)
+	}
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "budget not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	s.audit(ctx, r, OpDelete, ResourceBudget, id.Hex(), current, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getBudgetStatus handles GET /budgets/status. It reports every budget for
+// the months ending at ?month=YYYY-MM (default: the current month, UTC),
+// going back ?months= months (default 1).
+func (s *server) getBudgetStatus(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	last := granularityMonth.truncate(time.Now())
+	if v := q.Get("month"); v != "" {
+		month, err := time.Parse("2006-01", v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "month must be in YYYY-MM format")
+			return
+		}
+		last = month
+	}
+	months := 1
+	if v := q.Get("months"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxBudgetMonths {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "months must be an integer between 1 and "+strconv.Itoa(maxBudgetMonths))
+			return
+		}
+		months = n
+	}
+	first := last.AddDate(0, 1-months, 0)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	budgets, err := s.store.ListBudgets(ctx)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	statuses := make([]BudgetStatus, 0, len(budgets))
+	for _, b := range budgets {
+		status, err := s.budgetStatus(ctx, b, first, last)
+		if err != nil {
+			writeAggregateError(w, err)
+			return
+		}
+		statuses = append(statuses, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Currency string         `json:"currency"`
+		Budgets  []BudgetStatus `json:"budgets"`
+	}{s.rules.DefaultCurrency, statuses})
+}
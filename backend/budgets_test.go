@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBudgetStatus(t *testing.T) {
+	routes := newTestServer().routes()
+	if w := do(routes.ServeHTTP, http.MethodPost, "/rates", "date,from,to,rate\n2025-03-01,USD,INR,86.5\n", map[string]string{"Content-Type": "text/csv"}); w.Code != http.StatusOK {
+		t.Fatalf("upload rates: got %d %s", w.Code, w.Body)
+	}
+	for _, body := range []string{
+		`{"name":"Travel","group":{"kind":"tag","tag":"trip"},"amount":"10000"}`,
+		`{"name":"Everything","group":{"kind":"overall"},"amount":"20000"}`,
+	} {
+		if w := do(routes.ServeHTTP, http.MethodPost, "/budgets", body, nil); w.Code != http.StatusCreated {
+			t.Fatalf("create budget %s: got %d %s", body, w.Code, w.Body)
+		}
+	}
+	for _, body := range []string{
+		// Exactly the 80% warning threshold of Travel.
+		`{"description":"Train","amount":"8000","type":"expense","tags":["trip"],"dateTime":"2025-02-10T09:00:00Z"}`,
+		// 100 × 86.5 = 8650.00 in the base currency.
+		`{"description":"Hotel","amount":"100","currency":"USD","type":"expense","tags":["trip"],"dateTime":"2025-03-05T09:00:00Z"}`,
+		`{"description":"Taxi","amount":"1500","type":"expense","tags":["trip"],"dateTime":"2025-03-31T23:59:59Z"}`,
+		`{"description":"Rent","amount":"2000","type":"expense","dateTime":"2025-03-01T00:00:00Z"}`,
+		// Income never counts against a budget.
+		`{"description":"Refund","amount":"5000","type":"income","tags":["trip"],"dateTime":"2025-03-06T09:00:00Z"}`,
+		// Outside the months asked for.
+		`{"description":"Ferry","amount":"700","type":"expense","tags":["trip"],"dateTime":"2025-04-01T00:00:00Z"}`,
+	} {
+		if w := do(routes.ServeHTTP, http.MethodPost, "/transactions", body, nil); w.Code != http.StatusCreated {
+			t.Fatalf("create transaction %s: got %d %s", body, w.Code, w.Body)
+		}
+	}
+
+	w := do(routes.ServeHTTP, http.MethodGet, "/budgets/status?month=2025-03&months=3", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /budgets/status: got %d %s", w.Code, w.Body)
+	}
+	var report struct {
+		Currency string
+		Budgets  []BudgetStatus
+	}
+	decode(t, w, &report)
+	if report.Currency != "INR" {
+		t.Errorf("currency = %q, want INR", report.Currency)
+	}
+
+	// want lists "month budget spent remaining count over near" per period.
+	want := map[string][]string{
+		"Travel": {
+			"2025-01 10000.00 0.00 10000.00 0 false false",
+			"2025-02 10000.00 8000.00 2000.00 1 false true",
+			"2025-03 10000.00 10150.00 -150.00 2 true false",
+		},
+		"Everything": {
+			"2025-01 20000.00 0.00 20000.00 0 false false",
+			"2025-02 20000.00 8000.00 12000.00 1 false false",
+			"2025-03 20000.00 12150.00 7850.00 3 false false",
+		},
+	}
+	if len(report.Budgets) != len(want) {
+		t.Fatalf("got %d budgets, want %d", len(report.Budgets), len(want))
+	}
+	for _, status := range report.Budgets {
+		var got []string
+		for _, p := range status.Periods {
+			got = append(got, fmt.Sprintf("%s %s %s %s %d %t %t", p.Month, p.Budget, p.Spent, p.Remaining, p.Count, p.Over, p.Near))
+		}
+		if strings.Join(got, "\n") != strings.Join(want[status.Budget.Name], "\n") {
+			t.Errorf("%s:\n%s\nwant:\n%s", status.Budget.Name, strings.Join(got, "\n"), strings.Join(want[status.Budget.Name], "\n"))
+		}
+	}
+}
+
+func TestBudgetStatusMissingRate(t *testing.T) {
+	routes := newTestServer().routes()
+	if w := do(routes.ServeHTTP, http.MethodPost, "/budgets", `{"name":"Everything","group":{"kind":"overall"},"amount":"20000"}`, nil); w.Code != http.StatusCreated {
+		t.Fatalf("create budget: got %d %s", w.Code, w.Body)
+	}
+	if w := do(routes.ServeHTTP, http.MethodPost, "/transactions", `{"description":"Hotel","amount":"100","currency":"USD","type":"expense","dateTime":"2025-03-05T09:00:00Z"}`, nil); w.Code != http.StatusCreated {
+		t.Fatalf("create transaction: got %d %s", w.Code, w.Body)
+	}
+
+	w := do(routes.ServeHTTP, http.MethodGet, "/budgets/status?month=2025-03", "", nil)
+	var body errorBody
+	decode(t, w, &body)
+	if w.Code != http.StatusUnprocessableEntity || body.Error.Code != CodeMissingRate {
+		t.Errorf("GET /budgets/status: got %d %s, want 422 %q", w.Code, w.Body, CodeMissingRate)
+	}
+}
+
+func TestBudgetStatusOverflow(t *testing.T) {
+	s := newTestServer()
+	if w := do(s.routes().ServeHTTP, http.MethodPost, "/budgets", `{"name":"Everything","group":{"kind":"overall"},"amount":"999999999999"}`, nil); w.Code != http.StatusCreated {
+		t.Fatalf("create budget: got %d %s", w.Code, w.Body)
+	}
+	// Stored without validation, the amount has more decimal places than
+	// the budget can be rescaled to.
+	tx := Transaction{
+		Description: "Dust", Amount: Money{units: 1, scale: 7}, Currency: "INR", Type: TypeExpense,
+		DateTime: time.Date(2025, 3, 5, 9, 0, 0, 0, time.UTC),
+	}
+	if err := s.store.Create(context.Background(), &tx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The handler is called without the panic recovery of the routes.
+	w := do(s.getBudgetStatus, http.MethodGet, "/budgets/status?month=2025-03", "", nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("GET /budgets/status: got %d %s, want 500", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrCategoryInUse is returned by CategoryStore.DeleteCategory when
+// transactions, rules, budgets or recurring templates still reference the
+// category and no replacement was given.
+var ErrCategoryInUse = errors.New("category is in use")
+
+// ErrCategoryHasChildren is returned by CategoryStore.DeleteCategory when the
+// category still has subcategories.
+var ErrCategoryHasChildren = errors.New("category has subcategories")
+
+const maxCategoryNameLength = 50
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// Category groups transactions, e.g. "Food" with a "Restaurants"
+// subcategory. Kind matches the type of the transactions it may hold.
+type Category struct {
+	ID       primitive.ObjectID  `json:"_id,omitempty" bson:"_id,omitempty"`
+	Name     string              `json:"name" bson:"name"`
+	ParentID *primitive.ObjectID `json:"parentId,omitempty" bson:"parentId,omitempty"`
+	Color    string              `json:"color,omitempty" bson:"color,omitempty"`
+	Kind     string              `json:"kind" bson:"kind"`
+}
+
+// CategoryStore keeps the category tree.
+type CategoryStore interface {
+	// CreateCategory stores c and assigns it a new ID.
+	CreateCategory(ctx context.Context, c *Category) error
+	// ListCategories returns every category ordered by name.
+	ListCategories(ctx context.Context) ([]Category, error)
+	// GetCategory returns the category with the given ID or ErrNotFound.
+	GetCategory(ctx context.Context, id primitive.ObjectID) (Category, error)
+	// UpdateCategory replaces the category that has c.ID, or returns
+	// ErrNotFound.
+	UpdateCategory(ctx context.Context, c Category) error
+	// DeleteCategory removes a category without subcategories. Transactions,
+	// rules, budgets and recurring templates that reference it are moved to
+	// reassignTo; if reassignTo is nil and any exist, it returns
+	// ErrCategoryInUse.
+	DeleteCategory(ctx context.Context, id primitive.ObjectID, reassignTo *primitive.ObjectID) error
+}
+
+// categoryInput is the request body accepted by the category handlers.
+type categoryInput struct {
+	Name     string `json:"name"`
+	ParentID string `json:"parentId,omitempty"`
+	Color    string `json:"color,omitempty"`
+	Kind     string `json:"kind"`
+}
+
+// toCategory validates in and checks it against the existing categories. id
+// is the category being updated, or NilObjectID for a new one.
+func (in categoryInput) toCategory(id primitive.ObjectID, existing []Category) (Category, error) {
+	var errs validationError
+	c := Category{
+		ID:    id,
+		Name:  strings.TrimSpace(in.Name),
+		Color: strings.ToLower(in.Color),
+		Kind:  in.Kind,
+	}
+
+	switch n := utf8.RuneCountInString(c.Name); {
+	case n == 0:
+		errs.add("name", "is required")
+	case n > maxCategoryNameLength:
+		errs.add("name", "must be at most %d characters", maxCategoryNameLength)
+	}
+	if c.Color != "" && !colorPattern.MatchString(c.Color) {
+		errs.add("color", "must be a hex colour such as #4f46e5")
+	}
+	if !validTransactionType(c.Kind) {
+		errs.add("kind", "must be %q or %q", TypeIncome, TypeExpense)
+	}
+
+	byID := make(map[primitive.ObjectID]Category, len(existing))
+	for _, e := range existing {
+		byID[e.ID] = e
+	}
+
+	if in.ParentID != "" {
+		parentID, err := primitive.ObjectIDFromHex(in.ParentID)
+		parent, ok := byID[parentID]
+		switch {
+		case err != nil:
+			errs.add("parentId", "must be a valid ID")
+		case !ok:
+			errs.add("parentId", "does not exist")
+		case parent.Kind != c.Kind:
+			errs.add("parentId", "must be a category of the same kind")
+		case isDescendant(byID, parentID, id):
+			errs.add("parentId", "must not be the category itself or one of its subcategories")
+		default:
+			c.ParentID = &parentID
+		}
+	}
+
+	for _, e := range existing {
+		if e.ID != id && e.Kind == c.Kind && strings.EqualFold(e.Name, c.Name) && equalParent(e.ParentID, c.ParentID) {
+			errs.add("name", "is already used by another %s category with the same parent", c.Kind)
+			break
+		}
+	}
+
+	return c, errs.err()
+}
+
+// isDescendant reports whether id is ancestor or one of its subcategories.
+func isDescendant(byID map[primitive.ObjectID]Category, id, ancestor primitive.ObjectID) bool {
+	if ancestor.IsZero() {
+		return false
+	}
+	for seen := 0; seen <= len(byID); seen++ {
+		if id == ancestor {
+			return true
+		}
+		c, ok := byID[id]
+		if !ok || c.ParentID == nil {
+			return false
+		}
+		id = *c.ParentID
+	}
+	return false
+}
+
+// sortCategories orders categories by name, then ID.
+func sortCategories(categories []Category) {
+	sort.Slice(categories, func(i, j int) bool {
+		a, b := categories[i], categories[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return bytes.Compare(a.ID[:], b.ID[:]) < 0
+	})
+}
+
+func equalParent(a, b *primitive.ObjectID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// withSubcategories returns the IDs in ids together with the IDs of all of
+// their subcategories.
+func withSubcategories(categories []Category, ids []primitive.ObjectID) []primitive.ObjectID {
+	byID := make(map[primitive.ObjectID]Category, len(categories))
+	for _, c := range categories {
+		byID[c.ID] = c
+	}
+
+	var result []primitive.ObjectID
+	for _, c := range categories {
+		for _, id := range ids {
+			if isDescendant(byID, c.ID, id) {
+				result = append(result, c.ID)
+				break
+			}
+		}
+	}
+	// Keep unknown IDs so that they still filter, and match nothing.
+	for _, id := range ids {
+		if _, ok := byID[id]; !ok {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// expandCategoryFilter widens a category filter to include subcategories, so
+// that filtering on "Food" also covers "Food > Restaurants".
+func (s *server) expandCategoryFilter(ctx context.Context, f *TransactionFilter) error {
+	if len(f.CategoryIDs) == 0 {
+		return nil
+	}
+	categories, err := s.store.ListCategories(ctx)
+	if err != nil {
+		return err
+	}
+	f.CategoryIDs = withSubcategories(categories, f.CategoryIDs)
+	return nil
+}
+
+// checkReferences verifies that the records t points at exist and fit it.
+// Problems are reported as a *validationError; any other error comes from
+// the store.
+func (s *server) checkReferences(ctx context.Context, t Transaction) error {
+	var errs validationError
+	if t.CategoryID != nil {
+		c, err := s.store.GetCategory(ctx, *t.CategoryID)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			errs.add("categoryId", "does not exist")
+		case err != nil:
+			return err
+		case c.Kind != t.Type:
+			errs.add("categoryId", "is a category for %s transactions", c.Kind)
+		}
+	}
+	if t.AccountID != nil {
+		a, err := s.store.GetAccount(ctx, *t.AccountID)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			errs.add("accountId", "does not exist")
+		case err != nil:
+			return err
+		case a.Currency != t.Currency:
+			errs.add("currency", "must be %s, the currency of the account", a.Currency)
+		}
+	}
+	return errs.err()
+}
+
+// writeCheckError responds to a failed checkReferences call.
+func writeCheckError(w http.ResponseWriter, err error) {
+	if _, ok := err.(*validationError); ok {
+		writeBadRequest(w, err)
+		return
+	}
+	writeInternalError(w, err)
+}
+
+func writeCategory(w http.ResponseWriter, status int, c Category) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(c)
+}
+
+// getCategories handles GET /categories.
+func (s *server) getCategories(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	categories, err := s.store.ListCategories(ctx)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(categories)
+}
+
+// createCategory handles POST /categories.
+func (s *server) createCategory(w http.ResponseWriter, r *http.Request) {
+	var requestBody categoryInput
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	existing, err := s.store.ListCategories(ctx)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	c, err := requestBody.toCategory(primitive.NilObjectID, existing)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	if err := s.store.CreateCategory(ctx, &c); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	s.audit(ctx, r, OpCreate, ResourceCategory, c.ID.Hex(), nil, c)
+
+	writeCategory(w, http.StatusCreated, c)
+}
+
+// getCategory handles GET /categories/{id}.
+func (s *server) getCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := objectIDFromPath(r, "/categories/")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	c, err := s.store.GetCategory(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "category not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	writeCategory(w, http.StatusOK, c)
+}
+
+// replaceCategory handles PUT /categories/{id}. Changing the kind is refused
+// while transactions of the old kind still use the category.
+func (s *server) replaceCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := objectIDFromPath(r, "/categories/")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	var requestBody categoryInput
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	current, err := s.store.GetCategory(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "category not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	existing, err := s.store.ListCategories(ctx)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	c, err := requestBody.toCategory(id, existing)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	if c.Kind != current.Kind {
+		used, err := s.store.List(ctx, ListOptions{Filter: TransactionFilter{CategoryIDs: []primitive.ObjectID{id}, Scope: scopeAll}, Limit: 1})
+		if err != nil {
+			writeInternalError(w, err)
+			return
+		}
+		if len(used) > 0 {
+			writeError(w, http.StatusConflict, CodeConflict, "cannot change the kind of a category that is used by transactions")
+			return
+		}
+		for _, e := range existing {
+			if e.ParentID != nil && *e.ParentID == id {
+				writeError(w, http.StatusConflict, CodeConflict, "cannot change the kind of a category that has subcategories")
+				return
+			}
+		}
+	}
+
+	err = s.store.UpdateCategory(ctx, c)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "category not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	s.audit(ctx, r, OpUpdate, ResourceCategory, id.Hex(), current, c)
+
+	writeCategory(w, http.StatusOK, c)
+}
+
+// deleteCategory handles DELETE /categories/{id}. A category that is still
+// referenced anywhere can only be deleted with ?reassignTo={id}, which moves
+// the references to another category of the same kind.
+func (s *server) deleteCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := objectIDFromPath(r, "/categories/")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var reassignTo *primitive.ObjectID
+	if v := r.URL.Query().Get("reassignTo"); v != "" {
+		target, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "invalid reassignTo ID format")
+			return
+		}
+		if target == id {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "reassignTo must be a different category")
+			return
+		}
+
+		current, err := s.store.GetCategory(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, http.StatusNotFound, CodeNotFound, "category not found")
+			return
+		}
+		if err != nil {
+			writeInternalError(w, err)
+			return
+		}
+		c, err := s.store.GetCategory(ctx, target)
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "reassignTo category not found")
+			return
+		}
+		if err != nil {
+			writeInternalError(w, err)
+			return
+		}
+		if c.Kind != current.Kind {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "reassignTo must be a category of the same kind")
+			return
+		}
+		reassignTo = &target
+	}
+
+	// Transactions moved to reassignTo are each audited as an update.
+	var moved []Transaction
+	current, err := s.store.GetCategory(ctx, id)
+	if err == nil && reassignTo != nil {
+		moved, err = s.store.List(ctx, ListOptions{Filter: TransactionFilter{CategoryIDs: []primitive.ObjectID{id}, Scope: scopeAll}})
+	}
+	if err == nil {
+		err = s.store.DeleteCategory(ctx, id, reassignTo)
+	}
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, CodeNotFound, "category not found")
+	case errors.Is(err, ErrCategoryHasChildren):
+		writeError(w, http.StatusConflict, CodeInUse, "category has subcategories; delete or move them first")
+	case errors.Is(err, ErrCategoryInUse):
+		writeError(w, http.StatusConflict, CodeInUse, "category is used by transactions, rules, budgets or recurring transactions; pass reassignTo to move them to another category")
+	case err != nil:
+		writeInternalError(w, err)
+	default:
+		s.audit(ctx, r, OpDelete, ResourceCategory, id.Hex(), current, nil)
+		if reassignTo != nil {
+			s.auditUpdates(ctx, s.requestOrigin(r), moved, TransactionFilter{CategoryIDs: []primitive.ObjectID{*reassignTo}, Scope: scopeAll})
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// createTestCategory creates a category through the API and returns its ID.
+func createTestCategory(t *testing.T, routes http.Handler, body string) primitive.ObjectID {
+	t.Helper()
+	w := do(routes.ServeHTTP, http.MethodPost, "/categories", body, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create category %s: got %d %s", body, w.Code, w.Body)
+	}
+	var c Category
+	decode(t, w, &c)
+	return c.ID
+}
+
+func TestDeleteCategoryReassigns(t *testing.T) {
+	s := newTestServer()
+	routes := s.routes()
+	ctx := context.Background()
+	food := createTestCategory(t, routes, `{"name":"Food","kind":"expense"}`)
+	dining := createTestCategory(t, routes, `{"name":"Dining","kind":"expense"}`)
+
+	body := fmt.Sprintf(`{"description":"Swiggy","amount":"300","type":"expense","dateTime":"2025-03-10T20:00:00Z","categoryId":%q}`, food.Hex())
+	w := do(routes.ServeHTTP, http.MethodPost, "/transactions", body, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create transaction: got %d %s", w.Code, w.Body)
+	}
+	var tx Transaction
+	decode(t, w, &tx)
+	rule := Rule{Name: "Swiggy", Match: RuleMatch{Contains: "swiggy"}, CategoryID: &food}
+	if err := s.store.CreateRule(ctx, &rule); err != nil {
+		t.Fatal(err)
+	}
+	budget := Budget{Name: "Food", Group: BudgetGroup{Kind: GroupCategory, CategoryID: &food}, Amount: mustMoney(t, "5000")}
+	if err := s.store.CreateBudget(ctx, &budget); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := do(routes.ServeHTTP, http.MethodDelete, "/categories/"+food.Hex()+"?reassignTo="+dining.Hex(), "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE with reassignTo: got %d %s, want 204", w.Code, w.Body)
+	}
+
+	if w := do(routes.ServeHTTP, http.MethodGet, "/categories/"+food.Hex(), "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("GET deleted category: got %d, want 404", w.Code)
+	}
+	got, err := s.store.Get(ctx, tx.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CategoryID == nil || *got.CategoryID != dining || got.Version != tx.Version+1 {
+		t.Errorf("transaction category = %v at version %d, want %s at version %d", got.CategoryID, got.Version, dining.Hex(), tx.Version+1)
+	}
+	rules, err := s.store.ListRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].CategoryID == nil || *rules[0].CategoryID != dining {
+		t.Errorf("rules = %+v, want the rule moved to %s", rules, dining.Hex())
+	}
+	budgets, err := s.store.ListBudgets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(budgets) != 1 || budgets[0].Group.CategoryID == nil || *budgets[0].Group.CategoryID != dining {
+		t.Errorf("budgets = %+v, want the budget moved to %s", budgets, dining.Hex())
+	}
+}
+
+func TestDeleteCategoryInUse(t *testing.T) {
+	tests := []struct {
+		name string
+		// use references the category from some record.
+		use func(t *testing.T, s *server, id primitive.ObjectID)
+	}{
+		{"by a transaction", func(t *testing.T, s *server, id primitive.ObjectID) {
+			body := fmt.Sprintf(`{"description":"Swiggy","amount":"300","type":"expense","dateTime":"2025-03-10T20:00:00Z","categoryId":%q}`, id.Hex())
+			if w := do(s.routes().ServeHTTP, http.MethodPost, "/transactions", body, nil); w.Code != http.StatusCreated {
+				t.Fatalf("create transaction: got %d %s", w.Code, w.Body)
+			}
+		}},
+		{"by a rule", func(t *testing.T, s *server, id primitive.ObjectID) {
+			if err := s.store.CreateRule(context.Background(), &Rule{Name: "Swiggy", Match: RuleMatch{Contains: "swiggy"}, CategoryID: &id}); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"by a budget", func(t *testing.T, s *server, id primitive.ObjectID) {
+			b := Budget{Name: "Food", Group: BudgetGroup{Kind: GroupCategory, CategoryID: &id}, Amount: mustMoney(t, "5000")}
+			if err := s.store.CreateBudget(context.Background(), &b); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer()
+			routes := s.routes()
+			food := createTestCategory(t, routes, `{"name":"Food","kind":"expense"}`)
+			tt.use(t, s, food)
+
+			w := do(routes.ServeHTTP, http.MethodDelete, "/categories/"+food.Hex(), "", nil)
+			var body errorBody
+			decode(t, w, &body)
+			if w.Code != http.StatusConflict || body.Error.Code != CodeInUse {
+				t.Errorf("DELETE without reassignTo: got %d %q, want 409 %q", w.Code, body.Error.Code, CodeInUse)
+			}
+			if w := do(routes.ServeHTTP, http.MethodGet, "/categories/"+food.Hex(), "", nil); w.Code != http.StatusOK {
+				t.Errorf("GET category after a refused delete: got %d, want 200", w.Code)
+			}
+		})
+	}
+}
+
+func TestDeleteCategoryRejectsBadReassignTo(t *testing.T) {
+	routes := newTestServer().routes()
+	food := createTestCategory(t, routes, `{"name":"Food","kind":"expense"}`)
+	salary := createTestCategory(t, routes, `{"name":"Salary","kind":"income"}`)
+
+	for _, reassignTo := range []string{"123", food.Hex(), salary.Hex(), primitive.NewObjectID().Hex()} {
+		if w := do(routes.ServeHTTP, http.MethodDelete, "/categories/"+food.Hex()+"?reassignTo="+reassignTo, "", nil); w.Code != http.StatusBadRequest {
+			t.Errorf("DELETE ?reassignTo=%s: got %d %s, want 400", reassignTo, w.Code, w.Body)
+		}
+	}
+}
+
+func TestCreateCategoryNameConflict(t *testing.T) {
+	routes := newTestServer().routes()
+	food := createTestCategory(t, routes, `{"name":"Food","kind":"expense"}`)
+	createTestCategory(t, routes, fmt.Sprintf(`{"name":"Snacks","kind":"expense","parentId":%q}`, food.Hex()))
+
+	tests := []struct {
+		body     string
+		conflict bool
+	}{
+		{`{"name":"Food","kind":"expense"}`, true},
+		{`{"name":"  FOOD ","kind":"expense"}`, true},
+		{fmt.Sprintf(`{"name":"snacks","kind":"expense","parentId":%q}`, food.Hex()), true},
+		// The same name is allowed for the other kind or under another parent.
+		{`{"name":"Food","kind":"income"}`, false},
+		{`{"name":"Snacks","kind":"expense"}`, false},
+	}
+	for _, tt := range tests {
+		w := do(routes.ServeHTTP, http.MethodPost, "/categories", tt.body, nil)
+		if !tt.conflict {
+			if w.Code != http.StatusCreated {
+				t.Errorf("POST %s: got %d %s, want 201", tt.body, w.Code, w.Body)
+			}
+			continue
+		}
+		var body errorBody
+		decode(t, w, &body)
+		if w.Code != http.StatusBadRequest || body.Error.Code != CodeValidationFailed || len(body.Error.Fields) != 1 || body.Error.Fields[0].Field != "name" {
+			t.Errorf("POST %s: got %d %s, want 400 on name", tt.body, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config collects the runtime settings read from the environment.
+type config struct {
+	Port         string
+	StoreBackend string
+	MongoURI     string
+	DataFile     string
+	BaseCurrency string
+	FutureDates  futureDatePolicy
+	// SchedulerInterval is how often due recurring transactions are
+	// created.
+	SchedulerInterval time.Duration
+	// TrashRetention is how long deleted transactions can be restored
+	// before they are purged; zero keeps them forever.
+	TrashRetention time.Duration
+	// RequireIfMatch makes If-Match mandatory on changes to transactions.
+	RequireIfMatch bool
+	// IdempotencyTTL is how long responses to requests with an
+	// Idempotency-Key are kept for replay.
+	IdempotencyTTL time.Duration
+	// TrustedProxies are the reverse proxies allowed to name the client in
+	// X-Forwarded-For.
+	TrustedProxies []*net.IPNet
+	// AuditMaxEntries is how many audit entries the memory and file
+	// backends keep; zero keeps all of them.
+	AuditMaxEntries int
+}
+
+func loadConfig() (config, error) {
+	cfg := config{
+		Port:         getenv("PORT", "8080"),
+		StoreBackend: getenv("STORE_BACKEND", "mongo"),
+		MongoURI:     os.Getenv("MONGODB_URI"),
+		DataFile:     getenv("DATA_FILE", "neofinance-data.json"),
+		BaseCurrency: getenv("BASE_CURRENCY", "INR"),
+	}
+
+	if err := validateCurrency(cfg.BaseCurrency); err != nil {
+		return config{}, err
+	}
+	var err error
+	if cfg.FutureDates, err = parseFutureDatePolicy(os.Getenv("FUTURE_DATE_POLICY")); err != nil {
+		return config{}, err
+	}
+	if cfg.SchedulerInterval, err = time.ParseDuration(getenv("SCHEDULER_INTERVAL", "1m")); err != nil || cfg.SchedulerInterval <= 0 {
+		return config{}, fmt.Errorf("SCHEDULER_INTERVAL must be a positive duration such as 1m")
+	}
+	days, err := strconv.Atoi(getenv("TRASH_RETENTION_DAYS", "30"))
+	if err != nil || days < 0 {
+		return config{}, fmt.Errorf("TRASH_RETENTION_DAYS must be a whole number of days, or 0 to keep deleted transactions forever")
+	}
+	cfg.TrashRetention = time.Duration(days) * 24 * time.Hour
+	if cfg.IdempotencyTTL, err = time.ParseDuration(getenv("IDEMPOTENCY_TTL", "24h")); err != nil || cfg.IdempotencyTTL <= 0 {
+		return config{}, fmt.Errorf("IDEMPOTENCY_TTL must be a positive duration such as 24h")
+	}
+	if cfg.RequireIfMatch, err = strconv.ParseBool(getenv("REQUIRE_IF_MATCH", "false")); err != nil {
+		return config{}, fmt.Errorf("REQUIRE_IF_MATCH must be true or false")
+	}
+	if cfg.TrustedProxies, err = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES")); err != nil {
+		return config{}, err
+	}
+	if cfg.AuditMaxEntries, err = strconv.Atoi(getenv("AUDIT_MAX_ENTRIES", "0")); err != nil || cfg.AuditMaxEntries < 0 {
+		return config{}, fmt.Errorf("AUDIT_MAX_ENTRIES must be a whole number of entries, or 0 to keep the whole audit log")
+	}
+	return cfg, nil
+}
+
+// parseTrustedProxies reads a comma-separated list of IP addresses and CIDR
+// networks.
+func parseTrustedProxies(v string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES: %q is not an IP address or CIDR network", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: %q is not an IP address or CIDR network", item)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// getenv returns the value of the environment variable key, or fallback when
+// it is unset or empty.
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// validationRules returns the transaction checks configured by cfg.
+func (cfg config) validationRules() validationRules {
+	return validationRules{DefaultCurrency: cfg.BaseCurrency, FutureDates: cfg.FutureDates}
+}
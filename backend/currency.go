@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// currencyScales lists ISO 4217 currencies whose minor unit is not two
+// decimal places. Every other currency uses two.
+var currencyScales = map[string]int{
+	"BHD": 3, "BIF": 0, "CLF": 4, "CLP": 0, "DJF": 0, "GNF": 0, "IQD": 3,
+	"ISK": 0, "JOD": 3, "JPY": 0, "KMF": 0, "KRW": 0, "KWD": 3, "LYD": 3,
+	"OMR": 3, "PYG": 0, "RWF": 0, "TND": 3, "UGX": 0, "UYI": 0, "UYW": 4,
+	"VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+}
+
+var currencyCodePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// currencyScale returns the number of decimal places used by code.
+func currencyScale(code string) int {
+	if scale, ok := currencyScales[code]; ok {
+		return scale
+	}
+	return 2
+}
+
+// validateCurrency checks that code looks like an ISO 4217 alphabetic code.
+func validateCurrency(code string) error {
+	if !currencyCodePattern.MatchString(code) {
+		return fmt.Errorf("currency must be a three-letter ISO 4217 code, got %q", code)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// Error codes. They are part of the API and must not change; clients branch
+// on the code, while the message is for people.
+const (
+	CodeBadRequest           = "bad_request"            // malformed query, path or header
+	CodeInvalidBody          = "invalid_body"           // request body is not the expected JSON
+	CodeValidationFailed     = "validation_failed"      // see fields
+	CodeNotFound             = "not_found"              // no such resource or route
+	CodeMethodNotAllowed     = "method_not_allowed"     // route exists, method does not
+	CodeConflict             = "conflict"               // the change clashes with stored data
+	CodeTransferLeg          = "transfer_leg"           // a transfer leg changed on its own
+	CodeInUse                = "in_use"                 // a referenced record cannot be deleted
+	CodePreconditionFailed   = "precondition_failed"    // If-Match does not match the version
+	CodePreconditionRequired = "precondition_required"  // If-Match is missing
+	CodeIdempotencyMismatch  = "idempotency_key_reused" // same key, different body
+	CodeInProgress           = "in_progress"            // same key, first request still running
+	CodeMissingRate          = "missing_rate"           // no exchange rate to convert with
+	CodeInternal             = "internal_error"         // details are only logged
+)
+
+// apiError is the body of every error response:
+//
+//	{"error": {"code": "...", "message": "...", "fields": [...], "requestId": "..."}}
+type apiError struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []fieldError `json:"fields,omitempty"`
+	// RequestID repeats the X-Request-ID of the response, so a reported
+	// error can be found in the logs.
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// writeError responds with status and an error envelope.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeAPIError(w, status, apiError{Code: code, Message: message})
+}
+
+func writeAPIError(w http.ResponseWriter, status int, e apiError) {
+	e.RequestID = w.Header().Get("X-Request-ID")
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error apiError `json:"error"`
+	}{e})
+}
+
+// writeInternalError logs err, which may carry database details, and
+// responds with 500 without them. A buffered response also keeps err, which
+// fails its unit of work.
+func writeInternalError(w http.ResponseWriter, err error) {
+	if b, ok := w.(*bufferedResponse); ok {
+		b.err = err
+	}
+	writeAPIError(w, http.StatusInternalServerError, internalError(w, err))
+}
+
+// internalError logs err and returns the error reported for it in place of
+// the details.
+func internalError(w http.ResponseWriter, err error) apiError {
+	log.Printf("Request %s: %v", w.Header().Get("X-Request-ID"), err)
+	return apiError{Code: CodeInternal, Message: "internal server error", RequestID: w.Header().Get("X-Request-ID")}
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter) {
+	writeError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+}
+
+// writeNotFound answers requests for routes that do not exist.
+func writeNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, CodeNotFound, "no route for "+r.URL.Path)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// errorBody is the envelope every error response uses.
+type errorBody struct {
+	Error apiError `json:"error"`
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	s := newTestServer()
+
+	w := do(s.getTransaction, http.MethodGet, "/transactions/65f000000000000000000000", "", nil)
+	var notFound errorBody
+	decode(t, w, &notFound)
+	if w.Code != http.StatusNotFound || notFound.Error.Code != CodeNotFound {
+		t.Errorf("missing transaction: got %d %+v", w.Code, notFound.Error)
+	}
+
+	w = do(s.createTransaction, http.MethodPost, "/transactions", `{"description":"","amount":"1","type":"income","dateTime":"2025-01-01T00:00:00Z"}`, nil)
+	var invalid errorBody
+	decode(t, w, &invalid)
+	if w.Code != http.StatusBadRequest || invalid.Error.Code != CodeValidationFailed {
+		t.Fatalf("invalid transaction: got %d %+v", w.Code, invalid.Error)
+	}
+	if len(invalid.Error.Fields) != 1 || invalid.Error.Fields[0].Field != "description" {
+		t.Errorf("fields = %+v, want one for description", invalid.Error.Fields)
+	}
+}
+
+func TestErrorEnvelopeCarriesRequestID(t *testing.T) {
+	handler := requestIDMiddleware(corsMiddleware(writeNotFound))
+	r := httptest.NewRequest(http.MethodGet, "/nowhere", nil)
+	r.Header.Set("X-Request-ID", "req-42")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	var body errorBody
+	decode(t, w, &body)
+	if body.Error.RequestID != "req-42" {
+		t.Errorf("requestId = %q, want req-42", body.Error.RequestID)
+	}
+}
+
+func TestPanicBecomesInternalError(t *testing.T) {
+	handler := corsMiddleware(func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+	w := do(handler, http.MethodGet, "/", "", nil)
+
+	var body errorBody
+	decode(t, w, &body)
+	if w.Code != http.StatusInternalServerError || body.Error.Code != CodeInternal {
+		t.Errorf("got %d %+v", w.Code, body.Error)
+	}
+	if body.Error.Message != "internal server error" {
+		t.Errorf("message %q leaks details", body.Error.Message)
+	}
+}
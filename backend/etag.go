@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag renders a transaction version as a strong entity tag.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatches reports whether the If-Match header value matches version. It
+// holds a comma-separated list of entity tags, or * for any version. Weak
+// tags never match, as If-Match uses strong comparison.
+func ifMatches(header string, version int64) bool {
+	want := etag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == want {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch enforces the If-Match precondition of a change to current.
+// A stale version is refused with 412 and the current ETag, so the client
+// can fetch the transaction again; a missing header is refused with 428 when
+// REQUIRE_IF_MATCH is set. It reports whether the change may go ahead.
+func (s *server) checkIfMatch(w http.ResponseWriter, r *http.Request, current Transaction) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		if s.requireIfMatch {
+			writeError(w, http.StatusPreconditionRequired, CodePreconditionRequired, "If-Match header is required; send the ETag of the transaction")
+			return false
+		}
+		return true
+	}
+	if !ifMatches(header, current.Version) {
+		w.Header().Set("ETag", etag(current.Version))
+		writeError(w, http.StatusPreconditionFailed, CodePreconditionFailed, "transaction has been modified; it is now at version "+strconv.FormatInt(current.Version, 10))
+		return false
+	}
+	return true
+}
+
+// expectedVersion reads the version If-Match requires for a change to a
+// transaction that cannot be fetched first, such as one in the trash. It
+// returns anyVersion for * or, unless REQUIRE_IF_MATCH is set, a missing
+// header. Only a single entity tag can be checked this way. It reports
+// whether the change may go ahead.
+func (s *server) expectedVersion(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	switch header {
+	case "":
+		if s.requireIfMatch {
+			writeError(w, http.StatusPreconditionRequired, CodePreconditionRequired, "If-Match header is required; send the ETag of the transaction")
+			return 0, false
+		}
+		return anyVersion, true
+	case "*":
+		return anyVersion, true
+	}
+	if len(header) >= 2 && header[0] == '"' && header[len(header)-1] == '"' {
+		if v, err := strconv.ParseInt(header[1:len(header)-1], 10, 64); err == nil && v >= 0 {
+			return v, true
+		}
+	}
+	writeError(w, http.StatusBadRequest, CodeBadRequest, "If-Match must be a single ETag or *")
+	return 0, false
+}
+
+// writeVersionMismatch reports a change that lost a race with another one
+// made after the transaction was read.
+func writeVersionMismatch(w http.ResponseWriter) {
+	writeError(w, http.StatusPreconditionFailed, CodePreconditionFailed, "transaction has been modified by another request")
+}
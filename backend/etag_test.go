@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// createRent stores rentBody through the routes and returns its path.
+func createRent(t *testing.T, routes http.Handler) string {
+	t.Helper()
+	w := do(routes.ServeHTTP, http.MethodPost, "/transactions", rentBody, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: got %d %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("ETag"); got != `"1"` {
+		t.Fatalf("create ETag = %q, want \"1\"", got)
+	}
+	var created Transaction
+	decode(t, w, &created)
+	return "/transactions/" + created.ID.Hex()
+}
+
+func TestIfMatch(t *testing.T) {
+	s := newTestServer()
+	routes := s.routes()
+	path := createRent(t, routes)
+	update := `{"description":"Rent","amount":"4600","type":"expense","dateTime":"2025-03-29T10:17:00Z"}`
+
+	w := do(routes.ServeHTTP, http.MethodPut, path, update, map[string]string{"If-Match": `"7"`})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale If-Match: got %d %s, want 412", w.Code, w.Body)
+	}
+	var body errorBody
+	decode(t, w, &body)
+	if body.Error.Code != CodePreconditionFailed {
+		t.Errorf("code = %q, want %q", body.Error.Code, CodePreconditionFailed)
+	}
+	if got := w.Header().Get("ETag"); got != `"1"` {
+		t.Errorf("412 ETag = %q, want the current \"1\"", got)
+	}
+
+	w = do(routes.ServeHTTP, http.MethodPut, path, update, map[string]string{"If-Match": `"1"`})
+	if w.Code != http.StatusOK {
+		t.Fatalf("current If-Match: got %d %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("ETag"); got != `"2"` {
+		t.Errorf("ETag after update = %q, want \"2\"", got)
+	}
+
+	// The old version no longer matches, but * always does.
+	w = do(routes.ServeHTTP, http.MethodPatch, path, `{"description":"Flat rent"}`, map[string]string{"If-Match": `"1"`})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("replayed If-Match: got %d %s, want 412", w.Code, w.Body)
+	}
+	w = do(routes.ServeHTTP, http.MethodPatch, path, `{"description":"Flat rent"}`, map[string]string{"If-Match": "*"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("If-Match *: got %d %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("ETag"); got != `"3"` {
+		t.Errorf("ETag after patch = %q, want \"3\"", got)
+	}
+}
+
+func TestIfMatchRequired(t *testing.T) {
+	s := newTestServer()
+	s.requireIfMatch = true
+	routes := s.routes()
+	path := createRent(t, routes)
+
+	for _, method := range []string{http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		w := do(routes.ServeHTTP, method, path, rentBody, nil)
+		if w.Code != http.StatusPreconditionRequired {
+			t.Fatalf("%s without If-Match: got %d %s, want 428", method, w.Code, w.Body)
+		}
+		var body errorBody
+		decode(t, w, &body)
+		if body.Error.Code != CodePreconditionRequired {
+			t.Errorf("%s: code = %q, want %q", method, body.Error.Code, CodePreconditionRequired)
+		}
+	}
+
+	w := do(routes.ServeHTTP, http.MethodDelete, path, "", map[string]string{"If-Match": "*"})
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("delete with If-Match *: got %d %s", w.Code, w.Body)
+	}
+	trashed := w.Header().Get("ETag")
+	if trashed != `"2"` {
+		t.Errorf("ETag of the trashed transaction = %q, want \"2\"", trashed)
+	}
+
+	restore := path + "/restore"
+	if w := do(routes.ServeHTTP, http.MethodPost, restore, "", nil); w.Code != http.StatusPreconditionRequired {
+		t.Fatalf("restore without If-Match: got %d %s, want 428", w.Code, w.Body)
+	}
+	if w := do(routes.ServeHTTP, http.MethodPost, restore, "", map[string]string{"If-Match": `"1"`}); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("restore with a stale If-Match: got %d %s, want 412", w.Code, w.Body)
+	}
+	w = do(routes.ServeHTTP, http.MethodPost, restore, "", map[string]string{"If-Match": trashed})
+	if w.Code != http.StatusOK {
+		t.Fatalf("restore: got %d %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("ETag"); got != `"3"` {
+		t.Errorf("ETag after restore = %q, want \"3\"", got)
+	}
+}
+
+func TestIfMatchOnTransferDelete(t *testing.T) {
+	s := newTestServer()
+	s.requireIfMatch = true
+	routes := s.routes()
+
+	var ids [2]string
+	for i, name := range []string{"Checking", "Wallet"} {
+		w := do(routes.ServeHTTP, http.MethodPost, "/accounts", `{"name":"`+name+`","kind":"bank","currency":"INR"}`, nil)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create account: got %d %s", w.Code, w.Body)
+		}
+		var a Account
+		decode(t, w, &a)
+		ids[i] = a.ID.Hex()
+	}
+	w := do(routes.ServeHTTP, http.MethodPost, "/transfers",
+		`{"fromAccountId":"`+ids[0]+`","toAccountId":"`+ids[1]+`","amount":"500","dateTime":"2025-03-01T09:00:00Z"}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create transfer: got %d %s", w.Code, w.Body)
+	}
+	var transfer Transfer
+	decode(t, w, &transfer)
+	path := "/transfers/" + transfer.ID.Hex()
+
+	if w := do(routes.ServeHTTP, http.MethodDelete, path, "", nil); w.Code != http.StatusPreconditionRequired {
+		t.Fatalf("delete without If-Match: got %d %s, want 428", w.Code, w.Body)
+	}
+	if w := do(routes.ServeHTTP, http.MethodDelete, path, "", map[string]string{"If-Match": `"9"`}); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("delete with a stale If-Match: got %d %s, want 412", w.Code, w.Body)
+	}
+	if w := do(routes.ServeHTTP, http.MethodDelete, path, "", map[string]string{"If-Match": "*"}); w.Code != http.StatusNoContent {
+		t.Fatalf("delete with If-Match *: got %d %s", w.Code, w.Body)
+	}
+	if w := do(routes.ServeHTTP, http.MethodGet, path, "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("deleted transfer: got %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TransactionFilter restricts which transactions a store returns. Zero-valued
+// fields do not filter.
+type TransactionFilter struct {
+	Type      string
+	From      *time.Time // inclusive
+	To        *time.Time // inclusive
+	MinAmount *Money     // inclusive
+	MaxAmount *Money     // inclusive
+	Query     string     // case-insensitive substring of the description
+	// CategoryIDs matches transactions in any of the listed categories.
+	CategoryIDs []primitive.ObjectID
+	// TagsAll matches transactions carrying every listed tag, TagsAny those
+	// carrying at least one.
+	TagsAll []string
+	TagsAny []string
+	// AccountIDs matches transactions on any of the listed accounts.
+	AccountIDs []primitive.ObjectID
+	// TransferID matches the legs of one transfer.
+	TransferID *primitive.ObjectID
+	// Labels matches split transactions with a line of any of the labels.
+	// Aggregations then count only those lines.
+	Labels []string
+	// Scope selects live transactions (the default), the trash, or both.
+	Scope trashScope
+}
+
+// parseTransactionFilter reads the type, from, to, minAmount, maxAmount, q,
+// categoryId, accountId, label, tag, tagAll and tagAny query parameters.
+// categoryId, accountId, label and the tag parameters may be repeated; tagAll
+// and tagAny also take comma-separated lists.
+func parseTransactionFilter(q url.Values) (TransactionFilter, error) {
+	var f TransactionFilter
+
+	if t := q.Get("type"); t != "" {
+		if !validTransactionType(t) && t != TypeTransfer {
+			return f, fmt.Errorf("type must be %s, %s or %s", TypeIncome, TypeExpense, TypeTransfer)
+		}
+		f.Type = t
+	}
+
+	var err error
+	if f.From, err = parseFilterTime(q, "from", false); err != nil {
+		return f, err
+	}
+	if f.To, err = parseFilterTime(q, "to", true); err != nil {
+		return f, err
+	}
+	if f.From != nil && f.To != nil && f.From.After(*f.To) {
+		return f, fmt.Errorf("from must not be after to")
+	}
+
+	if f.MinAmount, err = parseFilterAmount(q, "minAmount"); err != nil {
+		return f, err
+	}
+	if f.MaxAmount, err = parseFilterAmount(q, "maxAmount"); err != nil {
+		return f, err
+	}
+	if f.MinAmount != nil && f.MaxAmount != nil && f.MinAmount.Cmp(*f.MaxAmount) > 0 {
+		return f, fmt.Errorf("minAmount must not be greater than maxAmount")
+	}
+
+	f.Query = strings.TrimSpace(q.Get("q"))
+
+	for _, v := range q["categoryId"] {
+		id, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			return f, fmt.Errorf("categoryId must be a valid ID")
+		}
+		f.CategoryIDs = append(f.CategoryIDs, id)
+	}
+	for _, v := range q["accountId"] {
+		id, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			return f, fmt.Errorf("accountId must be a valid ID")
+		}
+		f.AccountIDs = append(f.AccountIDs, id)
+	}
+	for _, v := range q["label"] {
+		if v = strings.TrimSpace(v); v == "" {
+			return f, fmt.Errorf("label must not be empty")
+		}
+		f.Labels = append(f.Labels, v)
+	}
+
+	for _, key := range []string{"tag", "tagAll"} {
+		tags, err := parseTagList(q, key)
+		if err != nil {
+			return f, err
+		}
+		f.TagsAll = append(f.TagsAll, tags...)
+	}
+	if f.TagsAny, err = parseTagList(q, "tagAny"); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// parseFilterTime parses an RFC3339 timestamp or a YYYY-MM-DD date. A bare
+// date used as an upper bound covers the whole day.
+func parseFilterTime(q url.Values, key string, endOfDay bool) (*time.Time, error) {
+	v := q.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an RFC3339 timestamp or a YYYY-MM-DD date", key)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return &t, nil
+}
+
+func parseFilterAmount(q url.Values, key string) (*Money, error) {
+	v := q.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+	amount, err := ParseMoney(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a decimal number", key)
+	}
+	return &amount, nil
+}
+
+// matches reports whether t passes the filter. Stores that cannot push the
+// filter down to a query engine use it directly.
+func (f TransactionFilter) matches(t Transaction) bool {
+	if !f.Scope.includes(t.DeletedAt) {
+		return false
+	}
+	if f.Type != "" && t.Type != f.Type {
+		return false
+	}
+	if f.From != nil && t.DateTime.Before(*f.From) {
+		return false
+	}
+	if f.To != nil && t.DateTime.After(*f.To) {
+		return false
+	}
+	if f.MinAmount != nil && t.Amount.Cmp(*f.MinAmount) < 0 {
+		return false
+	}
+	if f.MaxAmount != nil && t.Amount.Cmp(*f.MaxAmount) > 0 {
+		return false
+	}
+	if f.Query != "" && !strings.Contains(strings.ToLower(t.Description), strings.ToLower(f.Query)) {
+		return false
+	}
+	if len(f.CategoryIDs) > 0 && !containsID(f.CategoryIDs, t.CategoryID) {
+		return false
+	}
+	for _, tag := range f.TagsAll {
+		if !hasTag(t.Tags, tag) {
+			return false
+		}
+	}
+	if len(f.TagsAny) > 0 && !hasAnyTag(t.Tags, f.TagsAny) {
+		return false
+	}
+	if len(f.AccountIDs) > 0 && !containsID(f.AccountIDs, t.AccountID) {
+		return false
+	}
+	if f.TransferID != nil && !equalParent(f.TransferID, t.TransferID) {
+		return false
+	}
+	if len(f.Labels) > 0 && !hasSplitLabel(t, f.Labels) {
+		return false
+	}
+	return true
+}
+
+func hasAnyTag(tags, wanted []string) bool {
+	for _, tag := range wanted {
+		if hasTag(tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsID(ids []primitive.ObjectID, id *primitive.ObjectID) bool {
+	if id == nil {
+		return false
+	}
+	for _, v := range ids {
+		if v == *id {
+			return true
+		}
+	}
+	return false
+}
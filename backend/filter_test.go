@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestListFilters(t *testing.T) {
+	s := newTestServer()
+	routes := s.routes()
+	ctx := context.Background()
+	housing, food := primitive.NewObjectID(), primitive.NewObjectID()
+	checking, wallet := primitive.NewObjectID(), primitive.NewObjectID()
+
+	for _, tx := range []Transaction{
+		{Description: "Rent", Amount: mustMoney(t, "4500.00"), Type: TypeExpense, DateTime: time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC),
+			CategoryID: &housing, AccountID: &checking, Tags: []string{"home", "monthly"}},
+		{Description: "Salary", Amount: mustMoney(t, "45000.00"), Type: TypeIncome, DateTime: time.Date(2025, 3, 5, 9, 0, 0, 0, time.UTC),
+			AccountID: &checking, Tags: []string{"work", "monthly"}},
+		{Description: "Swiggy dinner", Amount: mustMoney(t, "1300.00"), Type: TypeExpense, DateTime: time.Date(2025, 3, 10, 20, 0, 0, 0, time.UTC),
+			CategoryID: &food, AccountID: &wallet, Tags: []string{"food"}},
+		{Description: "Groceries", Amount: mustMoney(t, "800.50"), Type: TypeExpense, DateTime: time.Date(2025, 3, 31, 23, 30, 0, 0, time.UTC),
+			CategoryID: &food, Splits: []Split{{Amount: mustMoney(t, "500.50"), Label: "household"}, {Amount: mustMoney(t, "300.00"), Label: "snacks"}}},
+	} {
+		tx.Currency = "INR"
+		if err := s.store.Create(ctx, &tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"Groceries", "Rent", "Salary", "Swiggy dinner"}},
+		{"type=income", []string{"Salary"}},
+		{"type=expense", []string{"Groceries", "Rent", "Swiggy dinner"}},
+		{"from=2025-03-05", []string{"Groceries", "Salary", "Swiggy dinner"}},
+		{"to=2025-03-05", []string{"Rent", "Salary"}},
+		// A bare date as the upper bound covers the whole day.
+		{"from=2025-03-31&to=2025-03-31", []string{"Groceries"}},
+		{"from=2025-03-05T09:00:01Z&to=2025-03-10T20:00:00Z", []string{"Swiggy dinner"}},
+		{"minAmount=1300", []string{"Rent", "Salary", "Swiggy dinner"}},
+		{"maxAmount=800.5", []string{"Groceries"}},
+		{"minAmount=1000&maxAmount=5000", []string{"Rent", "Swiggy dinner"}},
+		{"q=SWIGGY", []string{"Swiggy dinner"}},
+		{"q=+DIN+", []string{"Swiggy dinner"}},
+		{"categoryId=" + food.Hex(), []string{"Groceries", "Swiggy dinner"}},
+		{"categoryId=" + food.Hex() + "&categoryId=" + housing.Hex(), []string{"Groceries", "Rent", "Swiggy dinner"}},
+		{"accountId=" + checking.Hex(), []string{"Rent", "Salary"}},
+		{"label=snacks", []string{"Groceries"}},
+		{"tag=monthly", []string{"Rent", "Salary"}},
+		{"tag=monthly&tag=home", []string{"Rent"}},
+		{"tagAll=monthly,work", []string{"Salary"}},
+		{"tagAny=food,home", []string{"Rent", "Swiggy dinner"}},
+		{"tagAny=FOOD,,", []string{"Swiggy dinner"}},
+		{"type=expense&tag=monthly&minAmount=100", []string{"Rent"}},
+	}
+	for _, tt := range tests {
+		w := do(routes.ServeHTTP, http.MethodGet, "/transactions?"+tt.query, "", nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("GET ?%s: got %d %s", tt.query, w.Code, w.Body)
+			continue
+		}
+		var page transactionPage
+		decode(t, w, &page)
+		var got []string
+		for _, tx := range page.Transactions {
+			got = append(got, tx.Description)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("GET ?%s = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestListFiltersRejectMalformedValues(t *testing.T) {
+	routes := newTestServer().routes()
+	for _, query := range []string{
+		"type=refund",
+		"from=yesterday",
+		"to=2025-13-01",
+		"from=2025-03-10&to=2025-03-01",
+		"minAmount=ten",
+		"maxAmount=1e3",
+		"minAmount=10&maxAmount=5",
+		"categoryId=123",
+		"accountId=" + strings.Repeat("z", 24),
+		"label=+",
+		"tag=no spaces",
+		"tagAny=food,-home",
+	} {
+		w := do(routes.ServeHTTP, http.MethodGet, "/transactions?"+url.PathEscape(query), "", nil)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("GET ?%s: got %d %s, want 400", query, w.Code, w.Body)
+			continue
+		}
+		var body errorBody
+		decode(t, w, &body)
+		if body.Error.Code != CodeBadRequest {
+			t.Errorf("GET ?%s: code = %q, want %q", query, body.Error.Code, CodeBadRequest)
+		}
+	}
+}
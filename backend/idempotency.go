@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// IdempotencyRecord remembers the response to a request sent with an
+// Idempotency-Key header, so a retry gets the same response instead of
+// repeating the change.
+type IdempotencyRecord struct {
+	Key string `json:"key" bson:"_id"`
+	// BodyHash is the SHA-256 of the request body; a retry must send the
+	// same body.
+	BodyHash string `json:"bodyHash" bson:"bodyHash"`
+	// Status is zero while the first request is still being handled.
+	Status   int               `json:"status,omitempty" bson:"status,omitempty"`
+	Header   map[string]string `json:"header,omitempty" bson:"header,omitempty"`
+	Response []byte            `json:"response,omitempty" bson:"response,omitempty"`
+	// ExpiresAt is when the key may be used again for another request:
+	// shortly after the reservation while the request is being handled,
+	// and after the TTL once the response is stored.
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+}
+
+// IdempotencyStore keeps the responses to requests with an Idempotency-Key.
+// Expired records are ignored and eventually removed.
+type IdempotencyStore interface {
+	// ReserveIdempotencyKey stores rec unless an unexpired record with the
+	// same key exists. It reports whether rec was stored and otherwise
+	// returns the existing record.
+	ReserveIdempotencyKey(ctx context.Context, rec IdempotencyRecord) (IdempotencyRecord, bool, error)
+	// CompleteIdempotencyKey records the response to the request that
+	// reserved key and keeps it until expiresAt.
+	CompleteIdempotencyKey(ctx context.Context, key string, status int, header map[string]string, response []byte, expiresAt time.Time) error
+	// ReleaseIdempotencyKey forgets key, so the request can be retried.
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
+
+// replayedHeaders are the response headers kept with a stored response.
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// idempotencyLease is how long a key stays reserved for a request that is
+// still being handled. It outlasts any handler, so a key is only held this
+// long by a request that never settled it, such as one cut short by a
+// crash.
+const idempotencyLease = time.Minute
+
+// idempotent handles r with next unless it carries an Idempotency-Key that
+// has been seen before. A retry with the same body gets the stored response
+// again, marked with Idempotent-Replayed; the same key with another body is
+// refused with 422, and a retry while the first request is still running
+// with 409. Only successful responses are kept, so a failed request can be
+// retried with the same key.
+func (s *server) idempotent(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		next(w, r)
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	sum := sha256.Sum256(body)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rec := IdempotencyRecord{
+		Key:       key,
+		BodyHash:  hex.EncodeToString(sum[:]),
+		ExpiresAt: time.Now().UTC().Add(idempotencyLease),
+	}
+	existing, reserved, err := s.store.ReserveIdempotencyKey(ctx, rec)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if !reserved {
+		switch {
+		case existing.BodyHash != rec.BodyHash:
+			writeError(w, http.StatusUnprocessableEntity, CodeIdempotencyMismatch, "Idempotency-Key has already been used with a different request body")
+		case existing.Status == 0:
+			writeError(w, http.StatusConflict, CodeInProgress, "a request with this Idempotency-Key is still being processed")
+		default:
+			for k, v := range existing.Header {
+				w.Header().Set(k, v)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(existing.Status)
+			w.Write(existing.Response)
+		}
+		return
+	}
+
+	// Unless the response is stored, the key is released, also when next
+	// panics. The request may have been cancelled by then; the key must
+	// still be settled. Like the reservation, this happens outside the
+	// unit of work of the request, which only holds its changes.
+	settleCtx, cancelSettle := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+	defer cancelSettle()
+	completed := false
+	defer func() {
+		if completed {
+			return
+		}
+		if err := s.store.ReleaseIdempotencyKey(settleCtx, key); err != nil {
+			log.Printf("Idempotency: releasing key %q: %v", key, err)
+		}
+	}()
+
+	// The response is held back until it is stored, so that a client never
+	// gets a success that a retry could not replay.
+	buf := &bufferedResponse{header: w.Header().Clone()}
+	s.serveAtomically(buf, r, next)
+	if buf.status == 0 {
+		buf.status = http.StatusOK
+	}
+	if buf.status >= 200 && buf.status <= 299 {
+		header := make(map[string]string)
+		for _, k := range replayedHeaders {
+			if v := buf.Header().Get(k); v != "" {
+				header[k] = v
+			}
+		}
+		expiresAt := time.Now().UTC().Add(s.idempotencyTTL)
+		if err := s.store.CompleteIdempotencyKey(settleCtx, key, buf.status, header, buf.body.Bytes(), expiresAt); err != nil {
+			buf.reset()
+			writeInternalError(buf, fmt.Errorf("storing response for Idempotency-Key %q: %v", key, err))
+		} else {
+			completed = true
+		}
+	}
+	buf.flush(w)
+}
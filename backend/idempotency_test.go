@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const rentBody = `{"description":"Rent","amount":"4500","type":"expense","dateTime":"2025-03-29T10:17:00Z"}`
+
+func TestIdempotentCreateReplays(t *testing.T) {
+	s := newTestServer()
+	create := func(w http.ResponseWriter, r *http.Request) { s.idempotent(w, r, s.createTransaction) }
+	key := map[string]string{"Idempotency-Key": "retry-1"}
+
+	first := do(create, http.MethodPost, "/transactions", rentBody, key)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first request: got %d %s", first.Code, first.Body)
+	}
+	retry := do(create, http.MethodPost, "/transactions", rentBody, key)
+	if retry.Code != http.StatusCreated {
+		t.Fatalf("retry: got %d %s", retry.Code, retry.Body)
+	}
+	if retry.Body.String() != first.Body.String() {
+		t.Errorf("retry body = %s, want %s", retry.Body, first.Body)
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("retry is not marked as replayed")
+	}
+	if got, want := retry.Header().Get("ETag"), first.Header().Get("ETag"); got != want {
+		t.Errorf("retry ETag = %q, want %q", got, want)
+	}
+
+	list, err := s.store.List(context.Background(), ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Errorf("stored %d transactions, want 1", len(list))
+	}
+}
+
+func TestIdempotencyKeyWithDifferentBody(t *testing.T) {
+	s := newTestServer()
+	create := func(w http.ResponseWriter, r *http.Request) { s.idempotent(w, r, s.createTransaction) }
+	key := map[string]string{"Idempotency-Key": "retry-2"}
+
+	if w := do(create, http.MethodPost, "/transactions", rentBody, key); w.Code != http.StatusCreated {
+		t.Fatalf("first request: got %d %s", w.Code, w.Body)
+	}
+	other := `{"description":"Rent","amount":"5000","type":"expense","dateTime":"2025-03-29T10:17:00Z"}`
+	if w := do(create, http.MethodPost, "/transactions", other, key); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("reused key: got %d %s, want 422", w.Code, w.Body)
+	}
+}
+
+func TestIdempotencyKeyReleasedOnFailure(t *testing.T) {
+	s := newTestServer()
+	create := func(w http.ResponseWriter, r *http.Request) { s.idempotent(w, r, s.createTransaction) }
+	key := map[string]string{"Idempotency-Key": "retry-3"}
+	invalid := `{"description":"Rent","amount":"0","type":"expense","dateTime":"2025-03-29T10:17:00Z"}`
+
+	if w := do(create, http.MethodPost, "/transactions", invalid, key); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid request: got %d %s", w.Code, w.Body)
+	}
+	if w := do(create, http.MethodPost, "/transactions", rentBody, key); w.Code != http.StatusCreated {
+		t.Fatalf("corrected request: got %d %s, want 201", w.Code, w.Body)
+	}
+}
+
+func TestIdempotencyKeyReleasedOnPanic(t *testing.T) {
+	s := newTestServer()
+	panicking := corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		s.idempotent(w, r, func(http.ResponseWriter, *http.Request) { panic("handler bug") })
+	})
+	create := func(w http.ResponseWriter, r *http.Request) { s.idempotent(w, r, s.createTransaction) }
+	key := map[string]string{"Idempotency-Key": "retry-4"}
+
+	if w := do(panicking, http.MethodPost, "/transactions", rentBody, key); w.Code != http.StatusInternalServerError {
+		t.Fatalf("panicking request: got %d %s, want 500", w.Code, w.Body)
+	}
+	if w := do(create, http.MethodPost, "/transactions", rentBody, key); w.Code != http.StatusCreated {
+		t.Fatalf("retry after a panic: got %d %s, want 201", w.Code, w.Body)
+	}
+}
+
+// failingCompletion cannot store responses.
+type failingCompletion struct {
+	Store
+}
+
+func (failingCompletion) CompleteIdempotencyKey(context.Context, string, int, map[string]string, []byte, time.Time) error {
+	return errors.New("disk full")
+}
+
+func TestIdempotencyCompletionFailure(t *testing.T) {
+	s := newTestServer()
+	s.store = failingCompletion{s.store}
+	create := func(w http.ResponseWriter, r *http.Request) { s.idempotent(w, r, s.createTransaction) }
+	key := map[string]string{"Idempotency-Key": "retry-5"}
+
+	w := do(create, http.MethodPost, "/transactions", rentBody, key)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("unstored response: got %d %s, want 500", w.Code, w.Body)
+	}
+	if w.Header().Get("ETag") != "" || w.Header().Get("Location") != "" {
+		t.Errorf("500 carries the headers of the lost response: %v", w.Header())
+	}
+	_, reserved, err := s.store.ReserveIdempotencyKey(context.Background(), IdempotencyRecord{Key: "retry-5", ExpiresAt: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reserved {
+		t.Error("the key is still held after the response could not be stored")
+	}
+}
+
+func TestIdempotencyReservationIsLeased(t *testing.T) {
+	s := newTestServer()
+	ctx := context.Background()
+	var leased IdempotencyRecord
+	create := func(w http.ResponseWriter, r *http.Request) {
+		s.idempotent(w, r, func(w http.ResponseWriter, r *http.Request) {
+			leased, _, _ = s.store.ReserveIdempotencyKey(ctx, IdempotencyRecord{Key: "retry-6"})
+			s.createTransaction(w, r)
+		})
+	}
+	key := map[string]string{"Idempotency-Key": "retry-6"}
+
+	if w := do(create, http.MethodPost, "/transactions", rentBody, key); w.Code != http.StatusCreated {
+		t.Fatalf("create: got %d %s", w.Code, w.Body)
+	}
+	if time.Until(leased.ExpiresAt) > idempotencyLease {
+		t.Errorf("in-progress key expires in %v, want at most %v", time.Until(leased.ExpiresAt), idempotencyLease)
+	}
+	stored, reserved, _ := s.store.ReserveIdempotencyKey(ctx, IdempotencyRecord{Key: "retry-6"})
+	if reserved || time.Until(stored.ExpiresAt) < s.idempotencyTTL-time.Minute {
+		t.Errorf("completed key expires in %v, want about %v", time.Until(stored.ExpiresAt), s.idempotencyTTL)
+	}
+
+	// A reservation left behind by a crash lapses after its lease.
+	crashed := IdempotencyRecord{Key: "retry-7", BodyHash: "x", ExpiresAt: time.Now().Add(-time.Second)}
+	if _, _, err := s.store.ReserveIdempotencyKey(ctx, crashed); err != nil {
+		t.Fatal(err)
+	}
+	if w := do(create, http.MethodPost, "/transactions", rentBody, map[string]string{"Idempotency-Key": "retry-7"}); w.Code != http.StatusCreated {
+		t.Errorf("key of a crashed request: got %d %s, want 201", w.Code, w.Body)
+	}
+}
+
+// unitStore runs units of work without isolating them, as a store with
+// transactions would for calls outside the unit. Create blocks until
+// release is closed.
+type unitStore struct {
+	Store
+	started, release chan struct{}
+	// reservedInUnit is set if a key was reserved inside a unit of work.
+	reservedInUnit atomic.Bool
+}
+
+// inUnitKey marks a context inside unitStore.Atomically.
+type inUnitKey struct{}
+
+func (u *unitStore) Atomically(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(context.WithValue(ctx, inUnitKey{}, true))
+}
+
+func (u *unitStore) Create(ctx context.Context, t *Transaction) error {
+	u.started <- struct{}{}
+	<-u.release
+	return u.Store.Create(ctx, t)
+}
+
+func (u *unitStore) ReserveIdempotencyKey(ctx context.Context, rec IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	if ctx.Value(inUnitKey{}) != nil {
+		u.reservedInUnit.Store(true)
+	}
+	return u.Store.ReserveIdempotencyKey(ctx, rec)
+}
+
+func TestIdempotencyConcurrentRetry(t *testing.T) {
+	s := newTestServer()
+	store := &unitStore{Store: s.store, started: make(chan struct{}), release: make(chan struct{})}
+	s.store = store
+	routes := s.routes()
+	key := map[string]string{"Idempotency-Key": "retry-8"}
+
+	first := make(chan int)
+	go func() {
+		first <- do(routes.ServeHTTP, http.MethodPost, "/transactions", rentBody, key).Code
+	}()
+	<-store.started
+
+	w := do(routes.ServeHTTP, http.MethodPost, "/transactions", rentBody, key)
+	var body errorBody
+	decode(t, w, &body)
+	if w.Code != http.StatusConflict || body.Error.Code != CodeInProgress {
+		t.Errorf("retry while the first request runs: got %d %s, want 409 %s", w.Code, w.Body, CodeInProgress)
+	}
+
+	close(store.release)
+	if code := <-first; code != http.StatusCreated {
+		t.Fatalf("first request: got %d, want 201", code)
+	}
+	if w := do(routes.ServeHTTP, http.MethodPost, "/transactions", rentBody, key); w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry after the first request: got %d %s, want a replay", w.Code, w.Body)
+	}
+	if store.reservedInUnit.Load() {
+		t.Error("the key was reserved inside the unit of work of the request")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// legacyTransaction is the shape of the transactions.json export written by
+// the original browser-only version of the app.
+type legacyTransaction struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	Amount      Money  `json:"amount"`
+	Type        string `json:"type"`
+	DateTime    int64  `json:"dateTime"` // epoch milliseconds
+}
+
+// legacyObjectID maps a legacy ID onto an ObjectID. The first four bytes hold
+// the transaction time in seconds, as in a regular ObjectID, and the remaining
+// eight hold the legacy ID, so importing the same row twice always yields the
+// same _id.
+func legacyObjectID(legacyID int64, at time.Time) primitive.ObjectID {
+	var id primitive.ObjectID
+	binary.BigEndian.PutUint32(id[0:4], uint32(at.Unix()))
+	binary.BigEndian.PutUint64(id[4:12], uint64(legacyID))
+	return id
+}
+
+// toTransaction converts a legacy row into a Transaction that satisfies
+// rules.
+func (l legacyTransaction) toTransaction(rules validationRules) (Transaction, error) {
+	legacyID, err := strconv.ParseInt(l.ID, 10, 64)
+	if err != nil {
+		return Transaction{}, fmt.Errorf("invalid id %q", l.ID)
+	}
+	if l.DateTime == 0 {
+		return Transaction{}, errors.New("missing dateTime")
+	}
+
+	at := time.UnixMilli(l.DateTime).UTC()
+	t := Transaction{
+		ID:          legacyObjectID(legacyID, at),
+		Description: strings.TrimSpace(l.Description),
+		Amount:      l.Amount,
+		Currency:    rules.DefaultCurrency,
+		Type:        l.Type,
+		DateTime:    at,
+	}
+	if err := rules.validate(t); err != nil {
+		return Transaction{}, err
+	}
+	t.Amount, _ = t.Amount.Rescale(currencyScale(t.Currency))
+	return t, nil
+}
+
+// importSummary counts the outcome of an import run.
+type importSummary struct {
+	Read     int
+	Imported int
+	Skipped  int
+	Failed   int
+}
+
+// importLegacy reads a legacy export from r and writes every row that is not
+// already present into the store, categorised by engine, auditing each one
+// in the same write. Problems with individual rows are reported to errOut and
+// counted as failures rather than aborting the run.
+func (s *server) importLegacy(ctx context.Context, engine *ruleEngine, r io.Reader, errOut io.Writer, dryRun bool) (importSummary, error) {
+	var rows []legacyTransaction
+	if err := json.NewDecoder(r).Decode(&rows); err != nil {
+		return importSummary{}, fmt.Errorf("invalid legacy file: %v", err)
+	}
+
+	summary := importSummary{Read: len(rows)}
+	for i, row := range rows {
+		t, err := row.toTransaction(s.rules)
+		if err != nil {
+			fmt.Fprintf(errOut, "row %d: %v\n", i+1, err)
+			summary.Failed++
+			continue
+		}
+
+		_, err = s.store.Get(ctx, t.ID)
+		if err == nil {
+			summary.Skipped++
+			continue
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return summary, err
+		}
+
+		engine.apply(&t)
+		if dryRun {
+			summary.Imported++
+			continue
+		}
+		err = s.atomically(ctx, func(ctx context.Context) error {
+			if err := s.store.Create(ctx, &t); err != nil {
+				return err
+			}
+			s.appendAudit(ctx, origin{Actor: legacyImportActor}, OpCreate, ResourceTransaction, t.ID.Hex(), nil, t)
+			return nil
+		})
+		if errors.Is(err, ErrDuplicateID) {
+			summary.Skipped++
+			continue
+		}
+		if err != nil {
+			return summary, err
+		}
+		summary.Imported++
+	}
+	return summary, nil
+}
+
+// runImportLegacy implements the import-legacy subcommand and returns the
+// process exit code.
+func runImportLegacy(cfg config, args []string) int {
+	fs := flag.NewFlagSet("import-legacy", flag.ContinueOnError)
+	file := fs.String("file", "transactions.json", "legacy export to import")
+	dryRun := fs.Bool("dry-run", false, "report what would be imported without writing anything")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import-legacy: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	ctx := context.Background()
+	store, err := openStore(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import-legacy: failed to open %s store: %v\n", cfg.StoreBackend, err)
+		return 1
+	}
+	defer store.Close(ctx)
+
+	engine, err := loadRuleEngine(ctx, store)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import-legacy: failed to load rules: %v\n", err)
+		return 1
+	}
+
+	s := &server{store: store, rules: cfg.validationRules()}
+	summary, err := s.importLegacy(ctx, engine, f, os.Stderr, *dryRun)
+	fmt.Printf("read %d, imported %d, skipped %d (already imported), failed %d\n",
+		summary.Read, summary.Imported, summary.Skipped, summary.Failed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import-legacy: %v\n", err)
+		return 1
+	}
+	if summary.Failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLegacyRowConversion(t *testing.T) {
+	row := legacyTransaction{ID: "1743263372436818502", Description: " Rent ", Amount: mustMoney(t, "4500"), Type: TypeExpense, DateTime: 1743243420000}
+	rules := validationRules{DefaultCurrency: "INR"}
+
+	first, err := row.toTransaction(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := row.toTransaction(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID != again.ID {
+		t.Errorf("the same row mapped to %s and %s", first.ID.Hex(), again.ID.Hex())
+	}
+	want := time.Date(2025, 3, 29, 10, 17, 0, 0, time.UTC)
+	if !first.DateTime.Equal(want) || first.DateTime.Location() != time.UTC {
+		t.Errorf("dateTime = %v, want %v", first.DateTime, want)
+	}
+	if !first.ID.Timestamp().Equal(want) {
+		t.Errorf("_id timestamp = %v, want the transaction time %v", first.ID.Timestamp(), want)
+	}
+	if first.Description != "Rent" || first.Amount.String() != "4500.00" || first.Currency != "INR" {
+		t.Errorf("converted row = %+v", first)
+	}
+
+	other := row
+	other.ID = "1743263372436818503"
+	if o, _ := other.toTransaction(rules); o.ID == first.ID {
+		t.Error("different legacy IDs mapped to the same _id")
+	}
+}
+
+func TestImportLegacy(t *testing.T) {
+	export, err := os.ReadFile("transactions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	badRows := `[
+		{"id": "1743263372436818502", "description": "Rent", "amount": 4500, "type": "expense", "dateTime": 1743243420000},
+		{"id": "abc", "description": "Swiggy", "amount": 1300, "type": "expense", "dateTime": 1743243060000},
+		{"id": "", "description": "Tea", "amount": 20, "type": "expense", "dateTime": 1743243060000},
+		{"id": "1743262885445313490", "description": "Salary", "amount": 45000, "type": "income"}
+	]`
+
+	tests := []struct {
+		name   string
+		input  string
+		dryRun bool
+		// runs is how often the import is run; want is the summary of the
+		// last run.
+		runs   int
+		want   importSummary
+		stored int
+		// failures are the rows reported as failed.
+		failures []string
+	}{
+		{"legacy export", string(export), false, 1, importSummary{Read: 3, Imported: 3}, 3, nil},
+		{"second run", string(export), false, 2, importSummary{Read: 3, Skipped: 3}, 3, nil},
+		{"dry run", string(export), true, 1, importSummary{Read: 3, Imported: 3}, 0, nil},
+		{"bad rows", badRows, false, 1, importSummary{Read: 4, Imported: 1, Failed: 3}, 1,
+			[]string{`row 2: invalid id "abc"`, `row 3: invalid id ""`, "row 4: missing dateTime"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			ctx := context.Background()
+			s := &server{store: store, rules: validationRules{DefaultCurrency: "INR"}}
+
+			var got importSummary
+			var errOut bytes.Buffer
+			for i := 0; i < tt.runs; i++ {
+				errOut.Reset()
+				var err error
+				got, err = s.importLegacy(ctx, &ruleEngine{}, strings.NewReader(tt.input), &errOut, tt.dryRun)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got != tt.want {
+				t.Errorf("summary = %+v, want %+v", got, tt.want)
+			}
+
+			list, err := store.List(ctx, ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list) != tt.stored {
+				t.Errorf("stored %d transactions, want %d", len(list), tt.stored)
+			}
+			entries, err := store.ListAudit(ctx, AuditFilter{Actor: legacyImportActor, Operation: OpCreate}, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != tt.stored {
+				t.Errorf("audited %d imported transactions, want %d", len(entries), tt.stored)
+			}
+			if reported := strings.TrimSpace(errOut.String()); reported != strings.Join(tt.failures, "\n") {
+				t.Errorf("reported failures:\n%s\nwant:\n%s", reported, strings.Join(tt.failures, "\n"))
+			}
+		})
+	}
+}
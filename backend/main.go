@@ -2,185 +2,490 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"runtime/debug"
+	"strings"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 )
 
 type Transaction struct {
-	ID          primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
-	Description string             `json:"description" bson:"description"`
-	Amount      float64            `json:"amount" bson:"amount"`
-	Type        string             `json:"type" bson:"type"`
-	DateTime    time.Time          `json:"dateTime" bson:"dateTime"`
+	ID          primitive.ObjectID  `json:"_id,omitempty" bson:"_id,omitempty"`
+	Description string              `json:"description" bson:"description"`
+	Amount      Money               `json:"amount" bson:"amount"`
+	Currency    string              `json:"currency" bson:"currency"`
+	Type        string              `json:"type" bson:"type"`
+	DateTime    time.Time           `json:"dateTime" bson:"dateTime"`
+	CategoryID  *primitive.ObjectID `json:"categoryId,omitempty" bson:"categoryId,omitempty"`
+	Metadata    map[string]string   `json:"metadata,omitempty" bson:"metadata,omitempty"`
+	Tags        []string            `json:"tags,omitempty" bson:"tags,omitempty"`
+	// RuleID names the rule that chose CategoryID, if one did.
+	RuleID *primitive.ObjectID `json:"ruleId,omitempty" bson:"ruleId,omitempty"`
+	// RecurringID names the template the transaction was created from.
+	RecurringID *primitive.ObjectID `json:"recurringId,omitempty" bson:"recurringId,omitempty"`
+	AccountID   *primitive.ObjectID `json:"accountId,omitempty" bson:"accountId,omitempty"`
+	// TransferID and Direction are set on the two legs of a transfer.
+	TransferID *primitive.ObjectID `json:"transferId,omitempty" bson:"transferId,omitempty"`
+	Direction  string              `json:"direction,omitempty" bson:"direction,omitempty"`
+	// Splits divides the amount between several labels.
+	Splits []Split `json:"splits,omitempty" bson:"splits,omitempty"`
+	// DeletedAt is set while the transaction is in the trash.
+	DeletedAt *time.Time `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	// Version goes up with every change and is sent as the ETag.
+	Version int64 `json:"version" bson:"version"`
 }
 
-var collection *mongo.Collection
+// server holds the dependencies shared by the HTTP handlers.
+type server struct {
+	store Store
+	rules validationRules
+	// trashRetention is how long deleted transactions stay restorable.
+	trashRetention time.Duration
+	// requireIfMatch refuses changes to a transaction without If-Match.
+	requireIfMatch bool
+	// idempotencyTTL is how long an Idempotency-Key is remembered.
+	idempotencyTTL time.Duration
+	// trustedProxies are the networks of the reverse proxies whose
+	// X-Forwarded-For header names the client.
+	trustedProxies []*net.IPNet
+}
 
+// corsMiddleware allows browsers on other origins to call the API. It also
+// turns a panicking handler into a 500 error response.
 func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				writeInternalError(w, fmt.Errorf("panic: %v\n%s", v, debug.Stack()))
+			}
+		}()
+
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-User, X-Request-ID, If-Match, Idempotency-Key")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, ETag, Idempotent-Replayed")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
+		if !validateRequestBody(w, r) {
+			return
+		}
 
 		next.ServeHTTP(w, r)
 	}
 }
 
-func connectDB() error {
-	uri := os.Getenv("MONGODB_URI")
-	if uri == "" {
-		return fmt.Errorf("MONGODB_URI environment variable not set")
-	}
+// transactionPage is the response body of GET /transactions. Next is the URL
+// of the following page, or empty on the last page.
+type transactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	Next         string        `json:"next,omitempty"`
+}
 
-	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
-	clientOptions := options.Client().
-		ApplyURI(uri).
-		SetServerAPIOptions(serverAPI).
-		SetTLSConfig(&tls.Config{InsecureSkipVerify: true})
+func (s *server) getTransactions(w http.ResponseWriter, r *http.Request) {
+	s.listTransactions(w, r, scopeLive)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// listTransactions writes one page of the transactions in scope.
+func (s *server) listTransactions(w http.ResponseWriter, r *http.Request, scope trashScope) {
+	opts, err := listOptionsFromQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	opts.Filter.Scope = scope
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	client, err := mongo.Connect(ctx, clientOptions)
+	if err := s.expandCategoryFilter(ctx, &opts.Filter); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	// Fetch one extra transaction to find out whether another page exists.
+	pageSize := opts.Limit
+	opts.Limit++
+	transactions, err := s.store.List(ctx, opts)
 	if err != nil {
-		return fmt.Errorf("failed to connect to MongoDB: %v", err)
+		writeInternalError(w, err)
+		return
+	}
+
+	page := transactionPage{Transactions: transactions}
+	if len(transactions) > pageSize {
+		page.Transactions = transactions[:pageSize]
+		page.Next = nextPageLink(r, page.Transactions[pageSize-1])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false) // keep & in next links readable
+	enc.Encode(page)
+}
+
+// transactionInput is the request body accepted by the create and update
+// handlers.
+type transactionInput struct {
+	Description string            `json:"description"`
+	Amount      Money             `json:"amount"`
+	Currency    string            `json:"currency,omitempty"`
+	Type        string            `json:"type"`
+	DateTime    string            `json:"dateTime"`
+	CategoryID  string            `json:"categoryId,omitempty"`
+	AccountID   string            `json:"accountId,omitempty"`
+	Splits      []Split           `json:"splits,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+}
+
+// inputFromTransaction returns the request body that would produce t.
+func inputFromTransaction(t Transaction) transactionInput {
+	in := transactionInput{
+		Description: t.Description,
+		Amount:      t.Amount,
+		Currency:    t.Currency,
+		Type:        t.Type,
+		DateTime:    t.DateTime.Format(time.RFC3339Nano),
+		Metadata:    t.Metadata,
+		Tags:        t.Tags,
+		Splits:      t.Splits,
+	}
+	if t.CategoryID != nil {
+		in.CategoryID = t.CategoryID.Hex()
+	}
+	if t.AccountID != nil {
+		in.AccountID = t.AccountID.Hex()
+	}
+	return in
+}
+
+// toTransaction validates in against rules and converts it into a
+// Transaction without an ID. All problems are reported in a single
+// *validationError.
+func (in transactionInput) toTransaction(rules validationRules) (Transaction, error) {
+	var errs validationError
+
+	t := Transaction{
+		Description: strings.TrimSpace(in.Description),
+		Amount:      in.Amount,
+		Currency:    strings.ToUpper(strings.TrimSpace(in.Currency)),
+		Type:        in.Type,
+		Metadata:    in.Metadata,
+	}
+	if len(t.Metadata) == 0 {
+		t.Metadata = nil
+	}
+	if t.Currency == "" {
+		t.Currency = rules.DefaultCurrency
+	}
+
+	if in.DateTime == "" {
+		errs.add("dateTime", "is required")
+	} else if parsedTime, err := time.Parse(time.RFC3339, in.DateTime); err != nil {
+		errs.add("dateTime", "must be an RFC3339 timestamp")
+	} else {
+		t.DateTime = parsedTime
+	}
+
+	if in.CategoryID != "" {
+		if id, err := primitive.ObjectIDFromHex(in.CategoryID); err != nil {
+			errs.add("categoryId", "must be a valid ID")
+		} else {
+			t.CategoryID = &id
+		}
+	}
+	if in.AccountID != "" {
+		if id, err := primitive.ObjectIDFromHex(in.AccountID); err != nil {
+			errs.add("accountId", "must be a valid ID")
+		} else {
+			t.AccountID = &id
+		}
+	}
+
+	rules.check(&errs, t)
+	validateMetadata(&errs, t.Metadata)
+	t.Tags = normalizeTags(&errs, in.Tags)
+	t.Splits = normalizeSplits(&errs, in.Splits, t)
+	if err := errs.err(); err != nil {
+		return Transaction{}, err
+	}
+	t.Amount, _ = t.Amount.Rescale(currencyScale(t.Currency))
+	return t, nil
+}
+
+// transactionIDFromPath extracts the ObjectID following /transactions/ in the
+// request path.
+func transactionIDFromPath(r *http.Request) (primitive.ObjectID, error) {
+	return objectIDFromPath(r, "/transactions/")
+}
+
+// objectIDFromPath extracts the ObjectID following prefix in the request
+// path.
+func objectIDFromPath(r *http.Request, prefix string) (primitive.ObjectID, error) {
+	id := strings.TrimPrefix(r.URL.Path, prefix)
+	if id == "" {
+		return primitive.NilObjectID, errors.New("missing ID")
 	}
 
-	err = client.Ping(ctx, nil)
+	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return fmt.Errorf("failed to ping MongoDB: %v", err)
+		return primitive.NilObjectID, errors.New("invalid ID format")
 	}
+	return objID, nil
+}
 
-	collection = client.Database("neofinance").Collection("transactions")
-	return nil
+func writeTransaction(w http.ResponseWriter, status int, t Transaction) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(t.Version))
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(t)
 }
 
-func getTransactions(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (s *server) createTransaction(w http.ResponseWriter, r *http.Request) {
+	var requestBody transactionInput
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	newTransaction, err := requestBody.toTransaction(s.rules)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	cursor, err := collection.Find(ctx, bson.M{})
+	engine, err := loadRuleEngine(ctx, s.store)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+		writeInternalError(w, err)
 		return
 	}
-	defer cursor.Close(ctx)
+	engine.apply(&newTransaction)
 
-	var transactions []Transaction
-	if err = cursor.All(ctx, &transactions); err != nil {
-		http.Error(w, fmt.Sprintf("decoding error: %v", err), http.StatusInternalServerError)
+	if err := s.checkReferences(ctx, newTransaction); err != nil {
+		writeCheckError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(transactions)
+	if err := s.store.Create(ctx, &newTransaction); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	s.audit(ctx, r, OpCreate, ResourceTransaction, newTransaction.ID.Hex(), nil, newTransaction)
+
+	writeTransaction(w, http.StatusCreated, newTransaction)
 }
 
-func createTransaction(w http.ResponseWriter, r *http.Request) {
-	var requestBody struct {
-		Description string  `json:"description"`
-		Amount      float64 `json:"amount"`
-		Type        string  `json:"type"`
-		DateTime    string  `json:"dateTime"`
+// getTransaction handles GET /transactions/{id}.
+func (s *server) getTransaction(w http.ResponseWriter, r *http.Request) {
+	objID, err := transactionIDFromPath(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	t, err := s.store.Get(ctx, objID)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "transaction not found")
+		return
 	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	writeTransaction(w, http.StatusOK, t)
+}
 
+// replaceTransaction handles PUT /transactions/{id}. The body must be a
+// complete transaction, validated exactly like createTransaction.
+func (s *server) replaceTransaction(w http.ResponseWriter, r *http.Request) {
+	objID, err := transactionIDFromPath(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	var requestBody transactionInput
 	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
 		return
 	}
 
-	if requestBody.Description == "" || requestBody.Amount == 0 || requestBody.Type == "" || requestBody.DateTime == "" {
-		http.Error(w, "missing required fields", http.StatusBadRequest)
+	updated, err := requestBody.toTransaction(s.rules)
+	if err != nil {
+		writeBadRequest(w, err)
 		return
 	}
+	updated.ID = objID
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
 
-	parsedTime, err := time.Parse(time.RFC3339, requestBody.DateTime)
+	current, err := s.store.Get(ctx, objID)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "transaction not found")
+		return
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("invalid date format: %v", err), http.StatusBadRequest)
+		writeInternalError(w, err)
+		return
+	}
+	if current.TransferID != nil {
+		writeTransferLegConflict(w, current)
+		return
+	}
+	if !s.checkIfMatch(w, r, current) {
 		return
 	}
 
-	newTransaction := Transaction{
-		Description: requestBody.Description,
-		Amount:      requestBody.Amount,
-		Type:        requestBody.Type,
-		DateTime:    parsedTime,
+	s.saveTransaction(ctx, w, r, current, updated)
+}
+
+// patchTransaction handles PATCH /transactions/{id}. The body is a JSON merge
+// patch (RFC 7396) applied to the stored transaction; the result must pass the
+// same validation as createTransaction.
+func (s *server) patchTransaction(w http.ResponseWriter, r *http.Request) {
+	objID, err := transactionIDFromPath(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	var patch map[string]interface{}
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber() // keep amounts exact
+	if err := dec.Decode(&patch); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
+		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	result, err := collection.InsertOne(ctx, newTransaction)
+	current, err := s.store.Get(ctx, objID)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "transaction not found")
+		return
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("insert error: %v", err), http.StatusInternalServerError)
+		writeInternalError(w, err)
+		return
+	}
+	if current.TransferID != nil {
+		writeTransferLegConflict(w, current)
+		return
+	}
+	if !s.checkIfMatch(w, r, current) {
 		return
 	}
 
-	newTransaction.ID = result.InsertedID.(primitive.ObjectID)
+	var requestBody transactionInput
+	if err := applyMergePatch(inputFromTransaction(current), patch, &requestBody); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid patch: %v", err))
+		return
+	}
 
-	response := struct {
-		ID          string    `json:"_id"`
-		Description string    `json:"description"`
-		Amount      float64   `json:"amount"`
-		Type        string    `json:"type"`
-		DateTime    time.Time `json:"dateTime"`
-	}{
-		ID:          newTransaction.ID.Hex(),
-		Description: newTransaction.Description,
-		Amount:      newTransaction.Amount,
-		Type:        newTransaction.Type,
-		DateTime:    newTransaction.DateTime,
+	updated, err := requestBody.toTransaction(s.rules)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
 	}
+	updated.ID = objID
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(response)
+	s.saveTransaction(ctx, w, r, current, updated)
 }
 
-func deleteTransaction(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Path[len("/transactions/"):]
-	if id == "" {
-		http.Error(w, "missing transaction ID", http.StatusBadRequest)
+// saveTransaction stores t in place of current, which is not a transfer leg,
+// and writes it as the response.
+func (s *server) saveTransaction(ctx context.Context, w http.ResponseWriter, r *http.Request, current, t Transaction) {
+	// The category still counts as chosen by a rule unless it was changed.
+	if equalParent(current.CategoryID, t.CategoryID) {
+		t.RuleID = current.RuleID
+	}
+	t.RecurringID = current.RecurringID
+	t.Version = current.Version
+
+	if err := s.checkReferences(ctx, t); err != nil {
+		writeCheckError(w, err)
 		return
 	}
 
-	objID, err := primitive.ObjectIDFromHex(id)
+	err := s.store.Update(ctx, &t)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "transaction not found")
+		return
+	}
+	if errors.Is(err, ErrVersionMismatch) {
+		writeVersionMismatch(w)
+		return
+	}
 	if err != nil {
-		http.Error(w, "invalid ID format", http.StatusBadRequest)
+		writeInternalError(w, err)
 		return
 	}
+	s.audit(ctx, r, OpUpdate, ResourceTransaction, t.ID.Hex(), current, t)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	writeTransaction(w, http.StatusOK, t)
+}
 
-	result, err := collection.DeleteOne(ctx, bson.M{"_id": objID})
+func (s *server) deleteTransaction(w http.ResponseWriter, r *http.Request) {
+	objID, err := transactionIDFromPath(r)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("delete error: %v", err), http.StatusInternalServerError)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
 		return
 	}
 
-	if result.DeletedCount == 0 {
-		http.Error(w, "transaction not found", http.StatusNotFound)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	current, err := s.store.Get(ctx, objID)
+	if err == nil && current.TransferID != nil {
+		writeTransferLegConflict(w, current)
+		return
+	}
+	if err == nil && !s.checkIfMatch(w, r, current) {
+		return
+	}
+	if err == nil {
+		err = s.store.Trash(ctx, objID, current.Version, time.Now().UTC())
+	}
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "transaction not found")
+		return
+	}
+	if errors.Is(err, ErrVersionMismatch) {
+		writeVersionMismatch(w)
 		return
 	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	s.audit(ctx, r, OpDelete, ResourceTransaction, objID.Hex(), current, nil)
 
+	// The ETag of the transaction in the trash, for If-Match on restore.
+	w.Header().Set("ETag", etag(current.Version+1))
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -192,51 +497,331 @@ func healthCheck(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func main() {
-	if err := connectDB(); err != nil {
-		log.Fatalf("Database connection failed: %v", err)
-	}
-	defer func() {
-		if err := collection.Database().Client().Disconnect(context.Background()); err != nil {
-			log.Printf("Error disconnecting from MongoDB: %v", err)
-		}
-	}()
-
+// routes registers the handlers of every route described in openapi.json.
+func (s *server) routes() http.Handler {
 	mux := http.NewServeMux()
 
 	// Apply CORS middleware to all handlers
+	mux.HandleFunc("/", corsMiddleware(writeNotFound))
 	mux.HandleFunc("/health", corsMiddleware(healthCheck))
+	mux.HandleFunc("/openapi.json", corsMiddleware(getOpenAPI))
+	mux.HandleFunc("/docs", corsMiddleware(getDocs))
 	mux.HandleFunc("/transactions", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			getTransactions(w, r)
+			s.getTransactions(w, r)
 		case http.MethodPost:
-			createTransaction(w, r)
+			s.idempotent(w, r, s.createTransaction)
 		default:
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeMethodNotAllowed(w)
 		}
 	}))
 
 	mux.HandleFunc("/transactions/", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/history") {
+			if r.Method != http.MethodGet {
+				writeMethodNotAllowed(w)
+				return
+			}
+			s.getTransactionHistory(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/restore") {
+			if r.Method != http.MethodPost {
+				writeMethodNotAllowed(w)
+				return
+			}
+			s.restoreTransaction(w, r)
+			return
+		}
 		switch r.Method {
+		case http.MethodGet:
+			s.getTransaction(w, r)
+		case http.MethodPut:
+			s.replaceTransaction(w, r)
+		case http.MethodPatch:
+			s.patchTransaction(w, r)
 		case http.MethodDelete:
-			deleteTransaction(w, r)
+			s.deleteTransaction(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/audit", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.getAudit(w, r)
 		default:
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeMethodNotAllowed(w)
 		}
 	}))
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	mux.HandleFunc("/trash", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.getTrash(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/summary", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.getSummary(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/reports/periods", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.getPeriodReport(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/reports/labels", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.getLabelReport(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/rates", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.getRates(w, r)
+		case http.MethodPost:
+			s.putRates(w, r)
+		case http.MethodDelete:
+			s.deleteRate(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/categories", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.getCategories(w, r)
+		case http.MethodPost:
+			s.createCategory(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/categories/", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.getCategory(w, r)
+		case http.MethodPut:
+			s.replaceCategory(w, r)
+		case http.MethodDelete:
+			s.deleteCategory(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/tags", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.getTags(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/tags/rename", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			s.renameTags(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/budgets", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.getBudgets(w, r)
+		case http.MethodPost:
+			s.createBudget(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/budgets/status", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.getBudgetStatus(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/budgets/", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.getBudget(w, r)
+		case http.MethodPut:
+			s.replaceBudget(w, r)
+		case http.MethodDelete:
+			s.deleteBudget(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/recurring", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.getRecurringList(w, r)
+		case http.MethodPost:
+			s.createRecurring(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/recurring/", corsMiddleware(s.handleRecurringItem))
+
+	mux.HandleFunc("/accounts", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.getAccounts(w, r)
+		case http.MethodPost:
+			s.createAccount(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/accounts/", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.getAccount(w, r)
+		case http.MethodPut:
+			s.replaceAccount(w, r)
+		case http.MethodDelete:
+			s.deleteAccount(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/transfers", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			s.createTransfer(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/transfers/", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.getTransfer(w, r)
+		case http.MethodDelete:
+			s.deleteTransfer(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/rules", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.getRules(w, r)
+		case http.MethodPost:
+			s.createRule(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/rules/apply", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			s.applyRules(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	mux.HandleFunc("/rules/", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.getRule(w, r)
+		case http.MethodPut:
+			s.replaceRule(w, r)
+		case http.MethodDelete:
+			s.deleteRule(w, r)
+		default:
+			writeMethodNotAllowed(w)
+		}
+	}))
+
+	return s.atomicRequests(mux)
+}
+
+func main() {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import-legacy":
+			os.Exit(runImportLegacy(cfg, os.Args[2:]))
+		case "import-rates":
+			os.Exit(runImportRates(cfg, os.Args[2:]))
+		case "migrate-amounts":
+			os.Exit(runMigrateAmounts(cfg, os.Args[2:]))
+		default:
+			log.Fatalf("Unknown command %q", os.Args[1])
+		}
 	}
 
+	store, err := openStore(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("Failed to open %s store: %v", cfg.StoreBackend, err)
+	}
+	defer func() {
+		if err := store.Close(context.Background()); err != nil {
+			log.Printf("Error closing store: %v", err)
+		}
+	}()
+
+	s := &server{
+		store:          store,
+		rules:          cfg.validationRules(),
+		trashRetention: cfg.TrashRetention,
+		requireIfMatch: cfg.RequireIfMatch,
+		idempotencyTTL: cfg.IdempotencyTTL,
+		trustedProxies: cfg.TrustedProxies,
+	}
+
+	schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+	defer stopScheduler()
+	go s.runScheduler(schedulerCtx, cfg.SchedulerInterval)
+
 	server := &http.Server{
-		Addr:    ":" + port,
-		Handler: h2c.NewHandler(mux, &http2.Server{}),
+		Addr:    ":" + cfg.Port,
+		Handler: h2c.NewHandler(requestIDMiddleware(s.routes()), &http2.Server{}),
 	}
 
-	log.Printf("Server starting on port %s", port)
+	log.Printf("Server starting on port %s", cfg.Port)
 	if err := server.ListenAndServe(); err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
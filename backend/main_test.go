@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestServer returns a server backed by a fresh memory store.
+func newTestServer() *server {
+	return &server{
+		store:          newMemoryStore(),
+		rules:          validationRules{DefaultCurrency: "INR"},
+		trashRetention: 30 * 24 * time.Hour,
+		idempotencyTTL: time.Hour,
+	}
+}
+
+// do sends a request with body to handler and returns the recorded response.
+func do(handler http.HandlerFunc, method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+	var rd io.Reader
+	if body != "" {
+		rd = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, target, rd)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// decode unmarshals the body of w into v, failing the test on error.
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+}
+
+func TestPatchTransaction(t *testing.T) {
+	s := newTestServer()
+	routes := s.routes()
+	food := Category{Name: "Food", Kind: TypeExpense}
+	if err := s.store.CreateCategory(context.Background(), &food); err != nil {
+		t.Fatal(err)
+	}
+	body := `{"description":"Dinner","amount":"1300","type":"expense","dateTime":"2025-03-10T20:00:00Z",` +
+		`"categoryId":"` + food.ID.Hex() + `","tags":["food"],"metadata":{"ref":"A1","note":"team"}}`
+	w := do(routes.ServeHTTP, http.MethodPost, "/transactions", body, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: got %d %s", w.Code, w.Body)
+	}
+	var created Transaction
+	decode(t, w, &created)
+	path := "/transactions/" + created.ID.Hex()
+
+	tests := []struct {
+		patch string
+		// want is "description amount category tags metadata" after the
+		// patch.
+		want string
+	}{
+		{`{"amount":"1450.5"}`, "Dinner 1450.50 Food food note=team,ref=A1"},
+		{`{"metadata":{"ref":null}}`, "Dinner 1450.50 Food food note=team"},
+		{`{"tags":null,"categoryId":null}`, "Dinner 1450.50 - - note=team"},
+		{`{"description":"Team dinner","metadata":null}`, "Team dinner 1450.50 - - -"},
+	}
+	for _, tt := range tests {
+		w := do(routes.ServeHTTP, http.MethodPatch, path, tt.patch, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("PATCH %s: got %d %s", tt.patch, w.Code, w.Body)
+		}
+		var got Transaction
+		decode(t, w, &got)
+		category, tags, metadata := "-", "-", "-"
+		if got.CategoryID != nil {
+			category = food.Name
+		}
+		if len(got.Tags) > 0 {
+			tags = strings.Join(got.Tags, ",")
+		}
+		if len(got.Metadata) > 0 {
+			var pairs []string
+			for k, v := range got.Metadata {
+				pairs = append(pairs, k+"="+v)
+			}
+			sort.Strings(pairs)
+			metadata = strings.Join(pairs, ",")
+		}
+		if summary := strings.Join([]string{got.Description, got.Amount.String(), category, tags, metadata}, " "); summary != tt.want {
+			t.Errorf("PATCH %s = %s, want %s", tt.patch, summary, tt.want)
+		}
+	}
+}
+
+func TestChangeTransactionValidation(t *testing.T) {
+	routes := newTestServer().routes()
+	path := createRent(t, routes)
+	invalid := `{"description":" ","amount":"0","type":"expense","dateTime":"2025-03-29T10:17:00Z"}`
+
+	fieldsOf := func(method, target string) string {
+		w := do(routes.ServeHTTP, method, target, invalid, nil)
+		var body errorBody
+		decode(t, w, &body)
+		if w.Code != http.StatusBadRequest || body.Error.Code != CodeValidationFailed {
+			t.Errorf("%s %s: got %d %s, want 400 %s", method, target, w.Code, w.Body, CodeValidationFailed)
+		}
+		var fields []string
+		for _, f := range body.Error.Fields {
+			fields = append(fields, f.Field+": "+f.Message)
+		}
+		return strings.Join(fields, "; ")
+	}
+	want := fieldsOf(http.MethodPost, "/transactions")
+	if want == "" {
+		t.Fatal("create reported no field errors")
+	}
+	for _, method := range []string{http.MethodPut, http.MethodPatch} {
+		if got := fieldsOf(method, path); got != want {
+			t.Errorf("%s errors = %s, want those of create: %s", method, got, want)
+		}
+	}
+
+	// A patch that leaves the stored transaction invalid fails the same way.
+	w := do(routes.ServeHTTP, http.MethodPatch, path, `{"type":"refund"}`, nil)
+	var body errorBody
+	decode(t, w, &body)
+	if w.Code != http.StatusBadRequest || len(body.Error.Fields) != 1 || body.Error.Fields[0].Field != "type" {
+		t.Errorf("PATCH to an unknown type: got %d %s, want 400 on type", w.Code, w.Body)
+	}
+}
+
+func TestChangeMissingTransaction(t *testing.T) {
+	routes := newTestServer().routes()
+	path := "/transactions/" + primitive.NewObjectID().Hex()
+	for _, tt := range []struct{ method, body string }{
+		{http.MethodPut, rentBody},
+		{http.MethodPatch, `{"amount":"10"}`},
+	} {
+		w := do(routes.ServeHTTP, tt.method, path, tt.body, nil)
+		var body errorBody
+		decode(t, w, &body)
+		if w.Code != http.StatusNotFound || body.Error.Code != CodeNotFound {
+			t.Errorf("%s of a missing transaction: got %d %s, want 404", tt.method, w.Code, w.Body)
+		}
+	}
+}
+
+// staleGets reads transactions one version behind, as a request does when
+// another one changes the transaction between its read and its write.
+type staleGets struct {
+	Store
+}
+
+func (s staleGets) Get(ctx context.Context, id primitive.ObjectID) (Transaction, error) {
+	t, err := s.Store.Get(ctx, id)
+	t.Version--
+	return t, err
+}
+
+func TestChangeAfterConcurrentUpdate(t *testing.T) {
+	s := newTestServer()
+	path := createRent(t, s.routes())
+	s.store = staleGets{s.store}
+	routes := s.routes()
+
+	for _, tt := range []struct{ method, body string }{
+		{http.MethodPut, rentBody},
+		{http.MethodPatch, `{"amount":"10"}`},
+	} {
+		// If-Match names the version the request read, which the store no
+		// longer holds.
+		w := do(routes.ServeHTTP, tt.method, path, tt.body, map[string]string{"If-Match": `"0"`})
+		var body errorBody
+		decode(t, w, &body)
+		if w.Code != http.StatusPreconditionFailed || body.Error.Code != CodePreconditionFailed {
+			t.Errorf("%s after a concurrent update: got %d %s, want 412", tt.method, w.Code, w.Body)
+		}
+	}
+}
+
+func TestGetTransaction(t *testing.T) {
+	routes := newTestServer().routes()
+	path := createRent(t, routes)
+
+	tests := []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{path, http.StatusOK, ""},
+		{"/transactions/not-an-id", http.StatusBadRequest, CodeBadRequest},
+		{"/transactions/" + strings.Repeat("z", 24), http.StatusBadRequest, CodeBadRequest},
+		{"/transactions/" + primitive.NewObjectID().Hex(), http.StatusNotFound, CodeNotFound},
+	}
+	for _, tt := range tests {
+		w := do(routes.ServeHTTP, http.MethodGet, tt.path, "", nil)
+		if w.Code != tt.status {
+			t.Errorf("GET %s: got %d %s, want %d", tt.path, w.Code, w.Body, tt.status)
+			continue
+		}
+		if tt.code == "" {
+			var got Transaction
+			decode(t, w, &got)
+			if "/transactions/"+got.ID.Hex() != path || got.Description != "Rent" {
+				t.Errorf("GET %s = %+v, want the rent", tt.path, got)
+			}
+			continue
+		}
+		var body errorBody
+		decode(t, w, &body)
+		if body.Error.Code != tt.code {
+			t.Errorf("GET %s: code = %q, want %q", tt.path, body.Error.Code, tt.code)
+		}
+	}
+}
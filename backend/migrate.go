@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// amountMigrator is implemented by stores that may still hold amounts written
+// as floating point numbers before Money existed.
+type amountMigrator interface {
+	// MigrateAmounts rewrites every such amount as an exact decimal rounded to
+	// the minor unit of its currency, sets currency on transactions that have
+	// none, and returns how many transactions changed.
+	MigrateAmounts(ctx context.Context, currency string) (int, error)
+}
+
+// runMigrateAmounts implements the migrate-amounts subcommand and returns the
+// process exit code.
+func runMigrateAmounts(cfg config, args []string) int {
+	fs := flag.NewFlagSet("migrate-amounts", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx := context.Background()
+	store, err := openStore(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate-amounts: failed to open %s store: %v\n", cfg.StoreBackend, err)
+		return 1
+	}
+	defer store.Close(ctx)
+
+	migrator, ok := store.(amountMigrator)
+	if !ok {
+		fmt.Printf("%s store has no stored amounts to migrate\n", cfg.StoreBackend)
+		return 0
+	}
+
+	// Every migrated transaction is audited, in the same write where the
+	// store supports it.
+	s := &server{store: store}
+	var n int
+	err = s.atomically(ctx, func(ctx context.Context) error {
+		all := TransactionFilter{Scope: scopeAll}
+		before, err := store.List(ctx, ListOptions{Filter: all})
+		if err != nil {
+			return err
+		}
+		if n, err = migrator.MigrateAmounts(ctx, cfg.BaseCurrency); err != nil {
+			return err
+		}
+		s.auditUpdates(ctx, origin{Actor: migrationActor}, before, all)
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate-amounts: %v\n", err)
+		return 1
+	}
+	fmt.Printf("migrated %d transactions; those without a currency now use %s\n", n, cfg.BaseCurrency)
+	return 0
+}
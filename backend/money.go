@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// maxMoneyScale is the largest number of decimal places a Money value keeps.
+const maxMoneyScale = 9
+
+// Money is an exact decimal amount worth units × 10^-scale. Amounts are
+// never held in floating point: JSON carries them as decimal strings such as
+// "4500.00" and MongoDB stores them as Decimal128.
+type Money struct {
+	units int64
+	scale int
+}
+
+var errMoneyRange = errors.New("amount out of range")
+
+// ParseMoney parses a plain decimal number such as "12", "-0.5" or "4500.00".
+// The scale of the result is the number of digits after the decimal point.
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	neg := false
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+
+	intPart, fracPart, hasPoint := strings.Cut(s, ".")
+	if intPart == "" && fracPart == "" || hasPoint && fracPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return Money{}, fmt.Errorf("invalid amount %q", s)
+	}
+	if len(fracPart) > maxMoneyScale {
+		return Money{}, fmt.Errorf("amount %q has more than %d decimal places", s, maxMoneyScale)
+	}
+
+	digits := strings.TrimLeft(intPart+fracPart, "0")
+	if digits == "" {
+		return Money{scale: len(fracPart)}, nil
+	}
+	units, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return Money{}, errMoneyRange
+	}
+	if neg {
+		units = -units
+	}
+	return Money{units: units, scale: len(fracPart)}, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// moneyFromBig converts units × 10^exp into a Money value, failing if it does
+// not fit.
+func moneyFromBig(units *big.Int, exp int) (Money, error) {
+	units = new(big.Int).Set(units)
+	for ; exp > 0; exp-- {
+		units.Mul(units, big.NewInt(10))
+	}
+	if -exp > maxMoneyScale || !units.IsInt64() {
+		return Money{}, errMoneyRange
+	}
+	return Money{units: units.Int64(), scale: -exp}, nil
+}
+
+// Scale returns the number of decimal places of m.
+func (m Money) Scale() int { return m.scale }
+
+// Sign returns -1, 0 or +1 depending on the sign of m.
+func (m Money) Sign() int {
+	switch {
+	case m.units < 0:
+		return -1
+	case m.units > 0:
+		return 1
+	}
+	return 0
+}
+
+func (m Money) IsZero() bool { return m.units == 0 }
+
+// Neg returns -m.
+func (m Money) Neg() Money { return Money{units: -m.units, scale: m.scale} }
+
+// big returns the unscaled value of m at the given scale, which must not be
+// smaller than m.scale.
+func (m Money) big(scale int) *big.Int {
+	v := big.NewInt(m.units)
+	for i := m.scale; i < scale; i++ {
+		v.Mul(v, big.NewInt(10))
+	}
+	return v
+}
+
+// Add returns m + o at the larger of the two scales. It panics if the result
+// overflows, which would require amounts far beyond any real ledger; sums of
+// amounts that have not been bounded by validation use checkedAdd.
+func (m Money) Add(o Money) Money {
+	sum, err := m.checkedAdd(o)
+	if err != nil {
+		panic(err)
+	}
+	return sum
+}
+
+// checkedAdd returns m + o at the larger of the two scales, or errMoneyRange
+// if the result does not fit.
+func (m Money) checkedAdd(o Money) (Money, error) {
+	scale := m.scale
+	if o.scale > scale {
+		scale = o.scale
+	}
+	return moneyFromBig(new(big.Int).Add(m.big(scale), o.big(scale)), -scale)
+}
+
+// Sub returns m - o.
+func (m Money) Sub(o Money) Money { return m.Add(o.Neg()) }
+
+// checkedSub returns m - o, or errMoneyRange if the result does not fit.
+func (m Money) checkedSub(o Money) (Money, error) { return m.checkedAdd(o.Neg()) }
+
+// Cmp compares m and o numerically and returns -1, 0 or +1.
+func (m Money) Cmp(o Money) int {
+	scale := m.scale
+	if o.scale > scale {
+		scale = o.scale
+	}
+	return m.big(scale).Cmp(o.big(scale))
+}
+
+// Rescale returns m with exactly scale decimal places. It reports false if
+// that would drop non-zero digits.
+func (m Money) Rescale(scale int) (Money, bool) {
+	if scale >= m.scale {
+		r, err := moneyFromBig(m.big(scale), -scale)
+		return r, err == nil
+	}
+	div := int64(math.Pow10(m.scale - scale))
+	if m.units%div != 0 {
+		return Money{}, false
+	}
+	return Money{units: m.units / div, scale: scale}, true
+}
+
+// Round returns m rounded half away from zero to scale decimal places. It
+// fails if the result does not fit, which adding places can cause.
+func (m Money) Round(scale int) (Money, error) {
+	return roundBig(big.NewInt(m.units), m.scale, scale)
+}
+
+// atLeastScale pads m with zeros up to scale decimal places. Values that
+// already have more places are returned unchanged.
+func (m Money) atLeastScale(scale int) Money {
+	if m.scale >= scale {
+		return m
+	}
+	r, _ := m.Rescale(scale)
+	return r
+}
+
+// String formats m as a plain decimal with m.Scale() decimal places.
+func (m Money) String() string {
+	digits := strconv.FormatInt(m.units, 10)
+	sign := ""
+	if m.units < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	if m.scale == 0 {
+		return sign + digits
+	}
+	if len(digits) <= m.scale {
+		digits = strings.Repeat("0", m.scale-len(digits)+1) + digits
+	}
+	point := len(digits) - m.scale
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + m.String() + `"`), nil
+}
+
+// UnmarshalJSON accepts a decimal string or a JSON number. Numbers are parsed
+// from their literal text, so they never pass through float64.
+func (m *Money) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if bytes.Equal(b, []byte("null")) {
+		return nil
+	}
+	s := string(b)
+	if len(b) >= 2 && b[0] == '"' && b[len(b)-1] == '"' {
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return err
+		}
+		s = unquoted
+	} else if strings.ContainsAny(s, "eE") {
+		parsed, err := parseExponent(s)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	}
+
+	parsed, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// parseExponent parses a JSON number in exponent notation such as "1.5e3",
+// as written by some JSON encoders. The exponent is checked against the
+// digits before any are produced, so a short literal such as "1e7000000"
+// is rejected at once. Trailing zeros after the decimal point are dropped.
+func parseExponent(s string) (Money, error) {
+	mantissa, expPart, _ := strings.Cut(strings.ToLower(s), "e")
+	neg := false
+	if strings.HasPrefix(mantissa, "-") {
+		neg = true
+		mantissa = mantissa[1:]
+	}
+	intPart, fracPart, hasPoint := strings.Cut(mantissa, ".")
+	if intPart == "" || hasPoint && fracPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return Money{}, fmt.Errorf("invalid amount %s", s)
+	}
+	exp, err := strconv.ParseInt(expPart, 10, 32)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return Money{}, errMoneyRange
+		}
+		return Money{}, fmt.Errorf("invalid amount %s", s)
+	}
+
+	digits := strings.TrimLeft(intPart+fracPart, "0")
+	if digits == "" {
+		return Money{}, nil
+	}
+	scale := int64(len(fracPart)) - exp
+	for scale > 0 && digits[len(digits)-1] == '0' {
+		digits = digits[:len(digits)-1]
+		scale--
+	}
+	if scale > maxMoneyScale {
+		return Money{}, fmt.Errorf("amount %s has more than %d decimal places", s, maxMoneyScale)
+	}
+	if scale < 0 {
+		// An int64 holds at most 19 digits.
+		if int64(len(digits))-scale > 19 {
+			return Money{}, errMoneyRange
+		}
+		digits += strings.Repeat("0", int(-scale))
+		scale = 0
+	}
+	units, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return Money{}, errMoneyRange
+	}
+	if neg {
+		units = -units
+	}
+	return Money{units: units, scale: int(scale)}, nil
+}
+
+// MarshalBSONValue stores m as a Decimal128, which keeps both its value and
+// its scale.
+func (m Money) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	d, ok := primitive.ParseDecimal128FromBigInt(big.NewInt(m.units), -m.scale)
+	if !ok {
+		return 0, nil, errMoneyRange
+	}
+	return bson.TypeDecimal128, bsoncore.AppendDecimal128(nil, d), nil
+}
+
+// UnmarshalBSONValue reads a Decimal128 as well as the doubles and integers
+// written before amounts were stored exactly.
+func (m *Money) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+	switch t {
+	case bson.TypeDecimal128:
+		units, exp, err := raw.Decimal128().BigInt()
+		if err != nil {
+			return err
+		}
+		parsed, err := moneyFromBig(units, exp)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	case bson.TypeDouble:
+		parsed, err := moneyFromFloat(raw.Double())
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	case bson.TypeInt32:
+		*m = Money{units: int64(raw.Int32())}
+		return nil
+	case bson.TypeInt64:
+		*m = Money{units: raw.Int64()}
+		return nil
+	case bson.TypeNull:
+		*m = Money{}
+		return nil
+	default:
+		return fmt.Errorf("cannot decode %s into an amount", t)
+	}
+}
+
+// moneyFromFloat converts a legacy floating point amount using the shortest
+// decimal representation that round-trips, which is what was originally
+// typed in for any value that was not itself the result of arithmetic.
+func moneyFromFloat(f float64) (Money, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return Money{}, errMoneyRange
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if _, frac, ok := strings.Cut(s, "."); ok && len(frac) > maxMoneyScale {
+		s = strconv.FormatFloat(f, 'f', maxMoneyScale, 64)
+	}
+	return ParseMoney(s)
+}
+
+// MulRound returns m × factor rounded half away from zero to scale decimal
+// places.
+func (m Money) MulRound(factor Money, scale int) (Money, error) {
+	product := new(big.Int).Mul(big.NewInt(m.units), big.NewInt(factor.units))
+	return roundBig(product, m.scale+factor.scale, scale)
+}
+
+// DivRound returns m ÷ divisor rounded half away from zero to scale decimal
+// places.
+func (m Money) DivRound(divisor Money, scale int) (Money, error) {
+	if divisor.IsZero() {
+		return Money{}, errors.New("division by zero")
+	}
+	// m.units×10^-m.scale ÷ divisor.units×10^-divisor.scale, expressed in
+	// units of 10^-scale.
+	num := new(big.Int).Mul(big.NewInt(m.units), pow10(divisor.scale+scale))
+	den := new(big.Int).Mul(big.NewInt(divisor.units), pow10(m.scale))
+	return moneyFromBig(quoRound(num, den), -scale)
+}
+
+// roundBig rounds units × 10^-from to scale decimal places.
+func roundBig(units *big.Int, from, scale int) (Money, error) {
+	if from <= scale {
+		return moneyFromBig(new(big.Int).Mul(units, pow10(scale-from)), -scale)
+	}
+	return moneyFromBig(quoRound(units, pow10(from-scale)), -scale)
+}
+
+// quoRound divides num by den, rounding half away from zero.
+func quoRound(num, den *big.Int) *big.Int {
+	if den.Sign() < 0 {
+		num, den = new(big.Int).Neg(num), new(big.Int).Neg(den)
+	}
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(r), big.NewInt(2)).Cmp(den) >= 0 {
+		if num.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
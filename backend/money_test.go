@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseMoney(t *testing.T) {
+	valid := []struct{ in, want string }{
+		{"12", "12"},
+		{"-0.5", "-0.5"},
+		{"+4500.00", "4500.00"},
+		{" 7.10 ", "7.10"},
+		{".25", "0.25"},
+		{"0007", "7"},
+		{"0.00", "0.00"},
+	}
+	for _, tt := range valid {
+		m, err := ParseMoney(tt.in)
+		if err != nil {
+			t.Errorf("ParseMoney(%q): %v", tt.in, err)
+			continue
+		}
+		if m.String() != tt.want {
+			t.Errorf("ParseMoney(%q) = %s, want %s", tt.in, m, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "-", ".", "1.", "1e3", "1,000", "0x10", "12.3.4", "1.0000000000000000000", "99999999999999999999"} {
+		if m, err := ParseMoney(in); err == nil {
+			t.Errorf("ParseMoney(%q) = %s, want an error", in, m)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var v struct{ Amount Money }
+	for _, in := range []string{`{"Amount":"12.50"}`, `{"Amount":12.50}`} {
+		if err := json.Unmarshal([]byte(in), &v); err != nil {
+			t.Errorf("unmarshal %s: %v", in, err)
+			continue
+		}
+		if v.Amount.Cmp(mustMoney(t, "12.5")) != 0 {
+			t.Errorf("unmarshal %s = %s, want 12.5", in, v.Amount)
+		}
+	}
+	out, err := json.Marshal(struct{ Amount Money }{mustMoney(t, "4500.00")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"Amount":"4500.00"}` {
+		t.Errorf("marshal = %s, want the amount as a string", out)
+	}
+}
+
+func TestMoneyJSONExponent(t *testing.T) {
+	valid := []struct{ in, want string }{
+		{"1e3", "1000"},
+		{"1.5E2", "150"},
+		{"1.50e1", "15"},
+		{"-2.5e-1", "-0.25"},
+		{"12.5e+0", "12.5"},
+		{"0e999999", "0"},
+		{"9.223372036854775807e18", "9223372036854775807"},
+	}
+	for _, tt := range valid {
+		var m Money
+		if err := json.Unmarshal([]byte(tt.in), &m); err != nil {
+			t.Errorf("unmarshal %s: %v", tt.in, err)
+			continue
+		}
+		if m.String() != tt.want {
+			t.Errorf("unmarshal %s = %s, want %s", tt.in, m, tt.want)
+		}
+	}
+
+	for _, in := range []string{"1e7000000", "1e-7000000", "1e99999999999", "1e19", "1e-10", "1.e3", "e3", "1e", "1ex"} {
+		start := time.Now()
+		var m Money
+		if err := json.Unmarshal([]byte(in), &m); err == nil {
+			t.Errorf("unmarshal %s = %s, want an error", in, m)
+		}
+		if d := time.Since(start); d > 100*time.Millisecond {
+			t.Errorf("unmarshal %s took %v", in, d)
+		}
+	}
+}
+
+func TestMoneyRound(t *testing.T) {
+	tests := []struct {
+		in    string
+		scale int
+		want  string
+	}{
+		{"12.345", 2, "12.35"},
+		{"-12.345", 2, "-12.35"},
+		{"12.344", 2, "12.34"},
+		{"0.5", 0, "1"},
+		{"-0.5", 0, "-1"},
+		{"7", 2, "7.00"},
+		{"7.10", 1, "7.1"},
+	}
+	for _, tt := range tests {
+		got, err := mustMoney(t, tt.in).Round(tt.scale)
+		if err != nil {
+			t.Errorf("Round(%s, %d): %v", tt.in, tt.scale, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("Round(%s, %d) = %s, want %s", tt.in, tt.scale, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyRoundOverflow(t *testing.T) {
+	if got, err := mustMoney(t, "9000000000000000000").Round(2); err == nil {
+		t.Errorf("Round = %s, want an error", got)
+	}
+}
+
+func TestFileMigrateAmountsReportsOverflow(t *testing.T) {
+	ctx := context.Background()
+	s, err := newFileStore(filepath.Join(t.TempDir(), "data.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := Transaction{Description: "Legacy", Amount: mustMoney(t, "9000000000000000000"), Type: TypeIncome}
+	if err := s.Create(ctx, &tx); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.MigrateAmounts(ctx, "INR")
+	if err == nil {
+		t.Fatalf("MigrateAmounts migrated %d transactions, want an error", n)
+	}
+	got, err := s.Get(ctx, tx.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Currency != "" || got.Version != tx.Version {
+		t.Errorf("transaction changed by a failed migration: %+v", got)
+	}
+}
+
+func TestConvertedTotalsOverflow(t *testing.T) {
+	s := newTestServer()
+	ctx := context.Background()
+	// Every amount and the rate are valid, and each day converts without
+	// overflowing, but eleven days together exceed what Money can hold.
+	if err := s.store.PutRates(ctx, []ExchangeRate{{From: "USD", To: "INR", Date: "2025-01-01", Rate: mustMoney(t, "9000")}}); err != nil {
+		t.Fatal(err)
+	}
+	for day := 1; day <= 11; day++ {
+		tx := Transaction{
+			Description: "Wire", Amount: mustMoney(t, "999999999999"), Currency: "USD", Type: TypeIncome,
+			DateTime: time.Date(2025, 3, day, 9, 0, 0, 0, time.UTC),
+		}
+		if err := s.store.Create(ctx, &tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The handlers are called without the panic recovery of the routes.
+	for target, handler := range map[string]http.HandlerFunc{
+		"/summary":                          s.getSummary,
+		"/reports/periods?granularity=year": s.getPeriodReport,
+		"/reports/labels":                   s.getLabelReport,
+	} {
+		w := do(handler, http.MethodGet, target, "", nil)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("GET %s: got %d %s, want 500", target, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// openAPIDocument describes every route. It is served at /openapi.json and
+// request bodies are validated against it, so the documentation cannot drift
+// from what the handlers accept.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// docsPage renders openAPIDocument in a browser without external assets.
+//
+//go:embed docs.html
+var docsPage []byte
+
+var apiSpec = mustLoadSpec(openAPIDocument)
+
+func mustLoadSpec(doc []byte) *spec {
+	sp, err := loadSpec(doc)
+	if err != nil {
+		panic("openapi.json: " + err.Error())
+	}
+	return sp
+}
+
+// spec is the part of the OpenAPI document needed to validate requests.
+type spec struct {
+	routes  []specRoute
+	schemas map[string]*schema
+}
+
+// specRoute is one path of the document, such as /transactions/{id}.
+type specRoute struct {
+	template   string
+	segments   []string
+	params     int // number of {placeholder} segments
+	operations map[string]*operation
+}
+
+type operation struct {
+	RequestBody *struct {
+		Required bool `json:"required"`
+		Content  map[string]struct {
+			Schema *schema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+}
+
+// schema is the subset of JSON Schema used by the document: type, enum,
+// required, properties, additionalProperties, items, anyOf, $ref and the
+// length, pattern, item count and range keywords. Annotations such as
+// format and description are not checked.
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 schemaTypes        `json:"type"`
+	Enum                 []interface{}      `json:"enum"`
+	Required             []string           `json:"required"`
+	Properties           map[string]*schema `json:"properties"`
+	AdditionalProperties *schema            `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	AnyOf                []*schema          `json:"anyOf"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Pattern              string             `json:"pattern"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+	Minimum              *json.Number       `json:"minimum"`
+	Maximum              *json.Number       `json:"maximum"`
+
+	// never is set for the schema false, which no value matches.
+	never   bool
+	pattern *regexp.Regexp
+}
+
+func (s *schema) UnmarshalJSON(b []byte) error {
+	switch string(bytes.TrimSpace(b)) {
+	case "true":
+		*s = schema{}
+		return nil
+	case "false":
+		*s = schema{never: true}
+		return nil
+	}
+	type plain schema
+	if err := json.Unmarshal(b, (*plain)(s)); err != nil {
+		return err
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("pattern %q: %v", s.Pattern, err)
+		}
+		s.pattern = re
+	}
+	return nil
+}
+
+// schemaTypes is the type keyword, a single type or a list of them.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*t = schemaTypes{one}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(t))
+}
+
+const schemaRefPrefix = "#/components/schemas/"
+
+// loadSpec parses an OpenAPI document and checks that every $ref in it
+// resolves.
+func loadSpec(doc []byte) (*spec, error) {
+	var raw struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]*schema `json:"schemas"`
+		} `json:"components"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber() // compare enum members exactly
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	sp := &spec{schemas: raw.Components.Schemas}
+	var check func(s *schema) error
+	check = func(s *schema) error {
+		if s == nil {
+			return nil
+		}
+		if s.Ref != "" {
+			if _, ok := sp.schemas[strings.TrimPrefix(s.Ref, schemaRefPrefix)]; !ok || !strings.HasPrefix(s.Ref, schemaRefPrefix) {
+				return fmt.Errorf("unresolved $ref %q", s.Ref)
+			}
+		}
+		children := append([]*schema{s.AdditionalProperties, s.Items}, s.AnyOf...)
+		for _, p := range s.Properties {
+			children = append(children, p)
+		}
+		for _, c := range children {
+			if err := check(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for name, s := range sp.schemas {
+		if err := check(s); err != nil {
+			return nil, fmt.Errorf("schema %s: %v", name, err)
+		}
+	}
+
+	for template, item := range raw.Paths {
+		route := specRoute{
+			template:   template,
+			segments:   strings.Split(strings.Trim(template, "/"), "/"),
+			operations: make(map[string]*operation),
+		}
+		for _, seg := range route.segments {
+			if strings.HasPrefix(seg, "{") {
+				route.params++
+			}
+		}
+		for method, body := range item {
+			switch method {
+			case "get", "put", "post", "delete", "patch":
+			default:
+				continue // parameters, summary and the like
+			}
+			var op operation
+			if err := json.Unmarshal(body, &op); err != nil {
+				return nil, fmt.Errorf("%s %s: %v", method, template, err)
+			}
+			if op.RequestBody != nil {
+				for mediaType, content := range op.RequestBody.Content {
+					if err := check(content.Schema); err != nil {
+						return nil, fmt.Errorf("%s %s %s: %v", method, template, mediaType, err)
+					}
+				}
+			}
+			route.operations[strings.ToUpper(method)] = &op
+		}
+		sp.routes = append(sp.routes, route)
+	}
+	// Prefer literal segments, so /budgets/status wins over /budgets/{id}.
+	sort.Slice(sp.routes, func(i, j int) bool {
+		if sp.routes[i].params != sp.routes[j].params {
+			return sp.routes[i].params < sp.routes[j].params
+		}
+		return sp.routes[i].template < sp.routes[j].template
+	})
+	return sp, nil
+}
+
+// operation returns the operation serving method on path, or nil if the
+// document does not describe one.
+func (sp *spec) operation(method, path string) *operation {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, route := range sp.routes {
+		if len(route.segments) != len(segments) {
+			continue
+		}
+		matched := true
+		for i, seg := range route.segments {
+			if strings.HasPrefix(seg, "{") {
+				matched = segments[i] != ""
+			} else {
+				matched = seg == segments[i]
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			return route.operations[method]
+		}
+	}
+	return nil
+}
+
+// validateRequestBody checks the body of r against the schema the document
+// gives for its operation, writing the error response if it does not match.
+// The body is put back for the handler. Requests the document does not
+// describe, and bodies in a media type other than JSON such as the CSV
+// accepted by POST /rates, are left to the handler. It reports whether the
+// request may go ahead.
+func validateRequestBody(w http.ResponseWriter, r *http.Request) bool {
+	op := apiSpec.operation(r.Method, r.URL.Path)
+	if op == nil || op.RequestBody == nil {
+		return true
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	content, ok := op.RequestBody.Content[mediaType]
+	if ok && !strings.HasSuffix(mediaType, "json") {
+		return true
+	}
+	if !ok {
+		// The handlers read any other body as JSON.
+		if content, ok = op.RequestBody.Content["application/json"]; !ok {
+			return true
+		}
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
+		return false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			writeError(w, http.StatusBadRequest, CodeInvalidBody, "request body is required")
+			return false
+		}
+		return true
+	}
+
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber() // keep amounts exact
+	if err := dec.Decode(&v); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
+		return false
+	}
+
+	var errs validationError
+	apiSpec.validate(&errs, content.Schema, "", v)
+	if err := errs.err(); err != nil {
+		writeBadRequest(w, err)
+		return false
+	}
+	return true
+}
+
+// validate records in errs every way v, found at field path, breaks s.
+func (sp *spec) validate(errs *validationError, s *schema, path string, v interface{}) {
+	if s == nil {
+		return
+	}
+	if s.Ref != "" {
+		s = sp.schemas[strings.TrimPrefix(s.Ref, schemaRefPrefix)]
+	}
+	field := path
+	if field == "" {
+		field = "body"
+	}
+	if s.never {
+		errs.add(field, "is not allowed")
+		return
+	}
+	if len(s.AnyOf) > 0 {
+		var first validationError
+		for i, alt := range s.AnyOf {
+			var altErrs validationError
+			sp.validate(&altErrs, alt, path, v)
+			if altErrs.err() == nil {
+				return
+			}
+			if i == 0 {
+				first = altErrs
+			}
+		}
+		errs.Fields = append(errs.Fields, first.Fields...)
+		return
+	}
+	if len(s.Type) > 0 && !s.Type.match(v) {
+		errs.add(field, "must be %s", s.Type)
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		errs.add(field, "must be %s", enumList(s.Enum))
+		return
+	}
+
+	switch v := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength {
+			errs.add(field, "must be at least %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			errs.add(field, "must be at most %d characters", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			errs.add(field, "must match %s", s.Pattern)
+		}
+	case json.Number:
+		f, _ := v.Float64()
+		if s.Minimum != nil {
+			if min, _ := s.Minimum.Float64(); f < min {
+				errs.add(field, "must be at least %s", *s.Minimum)
+			}
+		}
+		if s.Maximum != nil {
+			if max, _ := s.Maximum.Float64(); f > max {
+				errs.add(field, "must be at most %s", *s.Maximum)
+			}
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			errs.add(field, "must have at least %d entries", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			errs.add(field, "must have at most %d entries", *s.MaxItems)
+		}
+		for i, item := range v {
+			sp.validate(errs, s.Items, path+"["+strconv.Itoa(i)+"]", item)
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				errs.add(joinField(path, name), "is required")
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := s.Properties[name]; ok {
+				sp.validate(errs, prop, joinField(path, name), v[name])
+			} else {
+				sp.validate(errs, s.AdditionalProperties, joinField(path, name), v[name])
+			}
+		}
+	}
+}
+
+func joinField(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// match reports whether v, decoded with UseNumber, has one of the types.
+func (t schemaTypes) match(v interface{}) bool {
+	for _, typ := range t {
+		switch v := v.(type) {
+		case nil:
+			if typ == "null" {
+				return true
+			}
+		case bool:
+			if typ == "boolean" {
+				return true
+			}
+		case string:
+			if typ == "string" {
+				return true
+			}
+		case json.Number:
+			if typ == "number" {
+				return true
+			}
+			if _, err := v.Int64(); err == nil && typ == "integer" {
+				return true
+			}
+		case []interface{}:
+			if typ == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if typ == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// String lists the types for an error message, such as "a string or a
+// number".
+func (t schemaTypes) String() string {
+	names := make([]string, len(t))
+	for i, typ := range t {
+		switch typ {
+		case "null":
+			names[i] = "null"
+		case "array", "integer", "object":
+			names[i] = "an " + typ
+		default:
+			names[i] = "a " + typ
+		}
+	}
+	return orList(names)
+}
+
+func inEnum(enum []interface{}, v interface{}) bool {
+	for _, e := range enum {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+func enumList(enum []interface{}) string {
+	names := make([]string, len(enum))
+	for i, e := range enum {
+		switch e := e.(type) {
+		case nil:
+			names[i] = "null"
+		case string:
+			names[i] = strconv.Quote(e)
+		default:
+			names[i] = fmt.Sprint(e)
+		}
+	}
+	return orList(names)
+}
+
+// orList joins words as "a, b or c".
+func orList(words []string) string {
+	if len(words) < 2 {
+		return strings.Join(words, "")
+	}
+	return strings.Join(words[:len(words)-1], ", ") + " or " + words[len(words)-1]
+}
+
+// getOpenAPI handles GET /openapi.json.
+func getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
+
+// getDocs handles GET /docs.
+func getDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
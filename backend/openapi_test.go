@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestEveryDocumentedOperationIsRouted(t *testing.T) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPIDocument, &doc); err != nil {
+		t.Fatal(err)
+	}
+	routes := newTestServer().routes()
+	id := primitive.NewObjectID().Hex()
+
+	for template, item := range doc.Paths {
+		for method := range item {
+			if method == "parameters" {
+				continue
+			}
+			method = strings.ToUpper(method)
+			target := strings.ReplaceAll(template, "{id}", id)
+			w := do(routes.ServeHTTP, method, target, "", nil)
+
+			var body errorBody
+			json.Unmarshal(w.Body.Bytes(), &body)
+			switch {
+			case w.Code == http.StatusMethodNotAllowed:
+				t.Errorf("%s %s: method not allowed", method, template)
+			case w.Code == http.StatusNotFound && strings.HasPrefix(body.Error.Message, "no route"):
+				t.Errorf("%s %s: not routed", method, template)
+			}
+		}
+	}
+}
+
+func TestRequestBodyValidation(t *testing.T) {
+	routes := newTestServer().routes()
+
+	tests := []struct {
+		name, method, target, contentType, body string
+		status                                  int
+		fields                                  []string
+	}{
+		{
+			name:   "valid transaction",
+			method: http.MethodPost, target: "/transactions",
+			body:   `{"description": "Salary", "amount": 1200.5, "type": "income", "dateTime": "2025-01-01T09:00:00Z"}`,
+			status: http.StatusCreated,
+		},
+		{
+			name:   "wrong types and unknown fields",
+			method: http.MethodPost, target: "/transactions",
+			body: `{"description": "Lunch", "amount": "20", "type": "expense", "dateTime": "2025-01-01T09:00:00Z",
+				"categoryID": "x", "splits": [{"amount": true, "label": "a"}, {"amount": "10"}]}`,
+			status: http.StatusBadRequest,
+			fields: []string{"categoryID", "splits[0].amount", "splits[1].label"},
+		},
+		{
+			name:   "amount that is not a decimal",
+			method: http.MethodPost, target: "/transactions",
+			body:   `{"description": "Lunch", "amount": "1e7000000", "type": "expense", "dateTime": "2025-01-01T09:00:00Z"}`,
+			status: http.StatusBadRequest,
+			fields: []string{"amount"},
+		},
+		{
+			name:   "missing fields",
+			method: http.MethodPost, target: "/transactions",
+			body:   `{"description": "Lunch"}`,
+			status: http.StatusBadRequest,
+			fields: []string{"amount", "type", "dateTime"},
+		},
+		{
+			name:   "body of the wrong type",
+			method: http.MethodPost, target: "/tags/rename",
+			body:   `["a", "b"]`,
+			status: http.StatusBadRequest,
+			fields: []string{"body"},
+		},
+		{
+			name:   "enum",
+			method: http.MethodPost, target: "/accounts",
+			body:   `{"name": "Savings", "kind": "vault", "currency": "INR"}`,
+			status: http.StatusBadRequest,
+			fields: []string{"kind"},
+		},
+		{
+			name:   "merge patch may remove members",
+			method: http.MethodPatch, target: "/transactions/" + primitive.NewObjectID().Hex(),
+			body:   `{"categoryId": null, "metadata": {"note": null}}`,
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "CSV is left to the handler",
+			method: http.MethodPost, target: "/rates", contentType: "text/csv",
+			body:   "2025-01-01,USD,INR,83.10\n",
+			status: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := map[string]string{"Content-Type": "application/json"}
+			if tt.contentType != "" {
+				header["Content-Type"] = tt.contentType
+			}
+			w := do(routes.ServeHTTP, tt.method, tt.target, tt.body, header)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.fields == nil {
+				return
+			}
+			var body errorBody
+			decode(t, w, &body)
+			if body.Error.Code != CodeValidationFailed {
+				t.Errorf("code = %q, want %q", body.Error.Code, CodeValidationFailed)
+			}
+			var got []string
+			for _, f := range body.Error.Fields {
+				got = append(got, f.Field)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.fields, ",") {
+				t.Errorf("fields = %v, want %v", got, tt.fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// pageCursor marks the last transaction of a page. Transactions are listed
+// newest first by dateTime and then _id, so the next page starts with the
+// first transaction that sorts after this position. Because the position is
+// a value rather than an offset, inserts do not shift later pages.
+type pageCursor struct {
+	DateTime time.Time          `json:"t"`
+	ID       primitive.ObjectID `json:"id"`
+}
+
+func cursorFor(t Transaction) *pageCursor {
+	return &pageCursor{DateTime: t.DateTime, ID: t.ID}
+}
+
+// encode returns the opaque string form handed to clients.
+func (c pageCursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var c pageCursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.ID.IsZero() {
+		return nil, errors.New("invalid cursor")
+	}
+	return &c, nil
+}
+
+// listOptionsFromQuery reads the limit and cursor query parameters along with
+// the filters understood by parseTransactionFilter.
+func listOptionsFromQuery(q url.Values) (ListOptions, error) {
+	filter, err := parseTransactionFilter(q)
+	if err != nil {
+		return ListOptions{}, err
+	}
+	opts := ListOptions{Filter: filter, Limit: defaultPageSize}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return ListOptions{}, errors.New("limit must be an integer between 1 and " + strconv.Itoa(maxPageSize))
+		}
+		opts.Limit = limit
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		if err != nil {
+			return ListOptions{}, err
+		}
+		opts.After = c
+	}
+	return opts, nil
+}
+
+// nextPageLink returns the URL of the page following the one that ended with
+// last, keeping every other query parameter of r.
+func nextPageLink(r *http.Request, last Transaction) string {
+	q := r.URL.Query()
+	q.Set("cursor", cursorFor(last).encode())
+	u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+	return u.String()
+}
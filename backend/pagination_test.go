@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestPaginationVisitsEveryTransactionOnce(t *testing.T) {
+	s := newTestServer()
+	routes := s.routes()
+	for day := 1; day <= 5; day++ {
+		body := fmt.Sprintf(`{"description":"Day %d","amount":"10","type":"expense","dateTime":"2025-03-%02dT09:00:00Z"}`, day, day)
+		if w := do(routes.ServeHTTP, http.MethodPost, "/transactions", body, nil); w.Code != http.StatusCreated {
+			t.Fatalf("create: got %d %s", w.Code, w.Body)
+		}
+	}
+
+	var seen []string
+	pages := 0
+	for next := "/transactions?limit=2"; next != ""; pages++ {
+		w := do(routes.ServeHTTP, http.MethodGet, next, "", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: got %d %s", next, w.Code, w.Body)
+		}
+		var page transactionPage
+		decode(t, w, &page)
+		for _, tx := range page.Transactions {
+			seen = append(seen, tx.Description)
+		}
+		next = page.Next
+	}
+
+	want := []string{"Day 5", "Day 4", "Day 3", "Day 2", "Day 1"}
+	if fmt.Sprint(seen) != fmt.Sprint(want) {
+		t.Errorf("pages returned %v, want %v", seen, want)
+	}
+	if pages != 3 {
+		t.Errorf("got %d pages, want 3", pages)
+	}
+}
+
+func TestPaginationRejectsBadParameters(t *testing.T) {
+	routes := newTestServer().routes()
+	for _, target := range []string{"/transactions?limit=0", "/transactions?limit=abc", "/transactions?cursor=garbage"} {
+		if w := do(routes.ServeHTTP, http.MethodGet, target, "", nil); w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: got %d, want 400", target, w.Code)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// applyMergePatch applies an RFC 7396 JSON merge patch to the JSON encoding of
+// target and decodes the result into out.
+func applyMergePatch(target interface{}, patch map[string]interface{}, out interface{}) error {
+	raw, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+
+	var doc map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return err
+	}
+
+	merged, err := json.Marshal(mergePatch(doc, patch))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(merged, out)
+}
+
+// mergePatch merges patch into doc: null members are removed, objects are
+// merged recursively and every other value replaces the existing one.
+func mergePatch(doc, patch map[string]interface{}) map[string]interface{} {
+	if doc == nil {
+		doc = make(map[string]interface{})
+	}
+	for key, value := range patch {
+		switch value := value.(type) {
+		case nil:
+			delete(doc, key)
+		case map[string]interface{}:
+			existing, _ := doc[key].(map[string]interface{})
+			doc[key] = mergePatch(existing, value)
+		default:
+			doc[key] = value
+		}
+	}
+	return doc
+}
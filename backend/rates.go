@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const rateDateLayout = "2006-01-02"
+
+// ExchangeRate says that from the given date onwards one unit of From is worth
+// Rate units of To, until a rate with a later date takes over.
+type ExchangeRate struct {
+	From string `json:"from" bson:"from"`
+	To   string `json:"to" bson:"to"`
+	// Date is the first day, in UTC and formatted as YYYY-MM-DD, on which the
+	// rate applies. The format sorts chronologically as a string.
+	Date string `json:"date" bson:"date"`
+	Rate Money  `json:"rate" bson:"rate"`
+}
+
+// RateStore keeps the locally managed exchange-rate table.
+type RateStore interface {
+	// PutRates inserts rates, replacing any existing rate with the same
+	// From, To and Date.
+	PutRates(ctx context.Context, rates []ExchangeRate) error
+	// ListRates returns the rates between from and to ordered by From, To
+	// and Date. An empty from or to matches any currency.
+	ListRates(ctx context.Context, from, to string) ([]ExchangeRate, error)
+	// DeleteRate removes a single rate or returns ErrNotFound.
+	DeleteRate(ctx context.Context, from, to, date string) error
+}
+
+// sortRates orders rates by From, To and Date.
+func sortRates(rates []ExchangeRate) {
+	sort.Slice(rates, func(i, j int) bool {
+		a, b := rates[i], rates[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Date < b.Date
+	})
+}
+
+// validate normalises r and checks every field.
+func (r *ExchangeRate) validate() error {
+	var errs validationError
+	r.From = strings.ToUpper(strings.TrimSpace(r.From))
+	r.To = strings.ToUpper(strings.TrimSpace(r.To))
+	if err := validateCurrency(r.From); err != nil {
+		errs.add("from", "%v", err)
+	}
+	if err := validateCurrency(r.To); err != nil {
+		errs.add("to", "%v", err)
+	}
+	if r.From == r.To && r.From != "" {
+		errs.add("to", "must differ from from")
+	}
+	if _, err := time.Parse(rateDateLayout, r.Date); err != nil {
+		errs.add("date", "must be a YYYY-MM-DD date")
+	}
+	if r.Rate.Sign() <= 0 {
+		errs.add("rate", "must be greater than zero")
+	}
+	return errs.err()
+}
+
+// parseRatesCSV reads rows of date,from,to,rate. A header row naming those
+// columns is optional and may list them in any order.
+func parseRatesCSV(r io.Reader) ([]ExchangeRate, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	cr.FieldsPerRecord = 4
+
+	columns := map[string]int{"date": 0, "from": 1, "to": 2, "rate": 3}
+	var rates []ExchangeRate
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// A first row that does not start with a date is a header.
+		if line == 1 {
+			if _, err := time.Parse(rateDateLayout, strings.TrimSpace(record[0])); err != nil {
+				if columns, err = rateColumns(record); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		rate, err := ParseMoney(record[columns["rate"]])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		er := ExchangeRate{
+			Date: strings.TrimSpace(record[columns["date"]]),
+			From: record[columns["from"]],
+			To:   record[columns["to"]],
+			Rate: rate,
+		}
+		if err := er.validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		rates = append(rates, er)
+	}
+	return rates, nil
+}
+
+// rateColumns maps the column names in a CSV header to their positions.
+func rateColumns(header []string) (map[string]int, error) {
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"date", "from", "to", "rate"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("header must name the columns date, from, to and rate")
+		}
+	}
+	return columns, nil
+}
+
+// errNoRate is returned when a transaction cannot be converted because the
+// rate table has no applicable entry.
+type errNoRate struct {
+	From, To, Date string
+}
+
+func (e *errNoRate) Error() string {
+	return fmt.Sprintf("no exchange rate from %s to %s on or before %s", e.From, e.To, e.Date)
+}
+
+// converter turns amounts into the base currency using the rate in effect on
+// a given day. Rates are loaded from the store once per currency and cached
+// for the lifetime of the converter, which is a single request.
+type converter struct {
+	rates RateStore
+	base  string
+	cache map[string]currencyRates
+}
+
+// currencyRates holds the direct (currency to base) and inverse (base to
+// currency) rates of one currency, each ordered by date.
+type currencyRates struct {
+	direct, inverse []ExchangeRate
+}
+
+func newConverter(rates RateStore, base string) *converter {
+	return &converter{rates: rates, base: base, cache: make(map[string]currencyRates)}
+}
+
+// convert returns amount, denominated in currency, in the base currency at the
+// rate effective on day. An empty currency means the base currency. The
+// result is rounded to the base currency's minor unit.
+func (c *converter) convert(ctx context.Context, amount Money, currency string, day time.Time) (Money, error) {
+	scale := currencyScale(c.base)
+	if currency == "" || currency == c.base {
+		return amount.atLeastScale(scale), nil
+	}
+
+	cr, ok := c.cache[currency]
+	if !ok {
+		var err error
+		if cr.direct, err = c.rates.ListRates(ctx, currency, c.base); err != nil {
+			return Money{}, err
+		}
+		if cr.inverse, err = c.rates.ListRates(ctx, c.base, currency); err != nil {
+			return Money{}, err
+		}
+		c.cache[currency] = cr
+	}
+
+	date := day.UTC().Format(rateDateLayout)
+	if rate, ok := effectiveRate(cr.direct, date); ok {
+		return amount.MulRound(rate.Rate, scale)
+	}
+	if rate, ok := effectiveRate(cr.inverse, date); ok {
+		return amount.DivRound(rate.Rate, scale)
+	}
+	return Money{}, &errNoRate{From: currency, To: c.base, Date: date}
+}
+
+// effectiveRate returns the latest rate dated on or before date.
+func effectiveRate(rates []ExchangeRate, date string) (ExchangeRate, bool) {
+	i := sort.Search(len(rates), func(i int) bool { return rates[i].Date > date })
+	if i == 0 {
+		return ExchangeRate{}, false
+	}
+	return rates[i-1], true
+}
+
+// getRates handles GET /rates, optionally narrowed with from and to.
+func (s *server) getRates(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rates, err := s.store.ListRates(ctx, strings.ToUpper(q.Get("from")), strings.ToUpper(q.Get("to")))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Base  string         `json:"base"`
+		Rates []ExchangeRate `json:"rates"`
+	}{s.rules.DefaultCurrency, rates})
+}
+
+// putRates handles POST /rates. The body is either a JSON array of rates or,
+// with Content-Type text/csv, rows of date,from,to,rate.
+func (s *server) putRates(w http.ResponseWriter, r *http.Request) {
+	var rates []ExchangeRate
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "text/csv" {
+		var err error
+		if rates, err = parseRatesCSV(r.Body); err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("invalid CSV: %v", err))
+			return
+		}
+	} else {
+		if err := json.NewDecoder(r.Body).Decode(&rates); err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
+			return
+		}
+		for i := range rates {
+			if err := rates[i].validate(); err != nil {
+				writeBadRequest(w, err)
+				return
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := s.storeRates(ctx, s.requestOrigin(r), rates); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"stored": len(rates)})
+}
+
+// deleteRate handles DELETE /rates?from=&to=&date=.
+func (s *server) deleteRate(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, to, date := strings.ToUpper(q.Get("from")), strings.ToUpper(q.Get("to")), q.Get("date")
+	if from == "" || to == "" || date == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "from, to and date are required")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	previous, err := s.storedRates(ctx, []ExchangeRate{{From: from, To: to, Date: date}})
+	if err == nil {
+		err = s.store.DeleteRate(ctx, from, to, date)
+	}
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "exchange rate not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	id := rateAuditID(from, to, date)
+	s.audit(ctx, r, OpDelete, ResourceRate, id, previous[id], nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// storeRates stores rates and audits each of them as created, or as updated
+// with the rate it replaced.
+func (s *server) storeRates(ctx context.Context, o origin, rates []ExchangeRate) error {
+	previous, err := s.storedRates(ctx, rates)
+	if err != nil {
+		return err
+	}
+	if err := s.store.PutRates(ctx, rates); err != nil {
+		return err
+	}
+	for _, rate := range rates {
+		id := rateAuditID(rate.From, rate.To, rate.Date)
+		if before, ok := previous[id]; ok {
+			s.appendAudit(ctx, o, OpUpdate, ResourceRate, id, before, rate)
+		} else {
+			s.appendAudit(ctx, o, OpCreate, ResourceRate, id, nil, rate)
+		}
+		// A later rate for the same day replaces this one.
+		previous[id] = rate
+	}
+	return nil
+}
+
+// storedRates returns the stored rates on the days of rates, keyed by
+// rateAuditID.
+func (s *server) storedRates(ctx context.Context, rates []ExchangeRate) (map[string]ExchangeRate, error) {
+	stored := make(map[string]ExchangeRate)
+	listed := make(map[string]bool)
+	for _, rate := range rates {
+		pair := rate.From + "/" + rate.To
+		if listed[pair] {
+			continue
+		}
+		listed[pair] = true
+		all, err := s.store.ListRates(ctx, rate.From, rate.To)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range all {
+			stored[rateAuditID(r.From, r.To, r.Date)] = r
+		}
+	}
+	return stored, nil
+}
+
+// rateAuditID is the audit resource ID of an exchange rate.
+func rateAuditID(from, to, date string) string {
+	return from + "/" + to + "/" + date
+}
+
+// runImportRates implements the import-rates subcommand and returns the
+// process exit code.
+func runImportRates(cfg config, args []string) int {
+	fs := flag.NewFlagSet("import-rates", flag.ContinueOnError)
+	file := fs.String("file", "rates.csv", "CSV file with date,from,to,rate rows")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import-rates: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	rates, err := parseRatesCSV(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import-rates: %s: %v\n", *file, err)
+		return 1
+	}
+
+	ctx := context.Background()
+	store, err := openStore(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import-rates: failed to open %s store: %v\n", cfg.StoreBackend, err)
+		return 1
+	}
+	defer store.Close(ctx)
+
+	// Every stored rate is audited, in the same write where the store
+	// supports it.
+	s := &server{store: store}
+	err = s.atomically(ctx, func(ctx context.Context) error {
+		return s.storeRates(ctx, origin{Actor: rateImportActor}, rates)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import-rates: %v\n", err)
+		return 1
+	}
+	fmt.Printf("stored %d exchange rates\n", len(rates))
+	return 0
+}
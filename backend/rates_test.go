@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const testRatesCSV = `date,from,to,rate
+2025-03-01,USD,INR,86.5
+2025-03-04,USD,INR,87.1234
+2025-01-01,INR,EUR,0.011
+`
+
+func TestConvertedTotals(t *testing.T) {
+	routes := newTestServer().routes()
+	if w := do(routes.ServeHTTP, http.MethodPost, "/rates", testRatesCSV, map[string]string{"Content-Type": "text/csv"}); w.Code != http.StatusOK {
+		t.Fatalf("upload rates: got %d %s", w.Code, w.Body)
+	}
+	for _, body := range []string{
+		// 100 × 86.5
+		`{"description":"Invoice","amount":"100","currency":"USD","type":"income","dateTime":"2025-03-03T09:00:00Z"}`,
+		// 10 × 87.1234, at the rate that took over on the 4th
+		`{"description":"App","amount":"10","currency":"USD","type":"expense","dateTime":"2025-03-04T00:00:00Z"}`,
+		// 11 ÷ 0.011, through the inverse of the INR to EUR rate
+		`{"description":"Hotel","amount":"11","currency":"EUR","type":"expense","dateTime":"2025-03-05T09:00:00Z"}`,
+		`{"description":"Tea","amount":"500","type":"income","dateTime":"2025-03-05T09:00:00Z"}`,
+	} {
+		if w := do(routes.ServeHTTP, http.MethodPost, "/transactions", body, nil); w.Code != http.StatusCreated {
+			t.Fatalf("create: got %d %s", w.Code, w.Body)
+		}
+	}
+
+	w := do(routes.ServeHTTP, http.MethodGet, "/summary", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /summary: got %d %s", w.Code, w.Body)
+	}
+	var summary Summary
+	decode(t, w, &summary)
+	if summary.Income.String() != "9150.00" || summary.Expense.String() != "1871.23" || summary.Balance.String() != "7278.77" || summary.Count != 4 {
+		t.Errorf("summary = %s income, %s expense, %s balance, %d transactions; want 9150.00, 1871.23, 7278.77, 4",
+			summary.Income, summary.Expense, summary.Balance, summary.Count)
+	}
+
+	w = do(routes.ServeHTTP, http.MethodGet, "/reports/periods?granularity=day&from=2025-03-03&to=2025-03-05", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /reports/periods: got %d %s", w.Code, w.Body)
+	}
+	var report struct{ Periods []PeriodTotals }
+	decode(t, w, &report)
+	var nets []string
+	for _, p := range report.Periods {
+		nets = append(nets, p.Net.String())
+	}
+	if want := "8650.00,-871.23,-500.00"; strings.Join(nets, ",") != want {
+		t.Errorf("daily nets = %v, want %s", nets, want)
+	}
+}
+
+func TestMissingRate(t *testing.T) {
+	tests := []struct {
+		name, transaction, message string
+	}{
+		{
+			name:        "before the first rate",
+			transaction: `{"description":"Early","amount":"5","currency":"USD","type":"expense","dateTime":"2025-02-28T23:59:00Z"}`,
+			message:     "no exchange rate from USD to INR on or before 2025-02-28",
+		},
+		{
+			name:        "no rate for the currency",
+			transaction: `{"description":"Tube","amount":"5","currency":"GBP","type":"expense","dateTime":"2025-03-10T09:00:00Z"}`,
+			message:     "no exchange rate from GBP to INR on or before 2025-03-10",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes := newTestServer().routes()
+			if w := do(routes.ServeHTTP, http.MethodPost, "/rates", testRatesCSV, map[string]string{"Content-Type": "text/csv"}); w.Code != http.StatusOK {
+				t.Fatalf("upload rates: got %d %s", w.Code, w.Body)
+			}
+			if w := do(routes.ServeHTTP, http.MethodPost, "/transactions", tt.transaction, nil); w.Code != http.StatusCreated {
+				t.Fatalf("create: got %d %s", w.Code, w.Body)
+			}
+
+			for _, target := range []string{"/summary", "/reports/periods"} {
+				w := do(routes.ServeHTTP, http.MethodGet, target, "", nil)
+				var body errorBody
+				decode(t, w, &body)
+				if w.Code != http.StatusUnprocessableEntity || body.Error.Code != CodeMissingRate {
+					t.Errorf("GET %s: got %d %q, want 422 %q", target, w.Code, body.Error.Code, CodeMissingRate)
+				}
+				if body.Error.Message != tt.message {
+					t.Errorf("GET %s: message = %q, want %q", target, body.Error.Message, tt.message)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,575 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Recurring is a template for transactions that repeat on a schedule, such
+// as rent or a salary. The scheduler turns every due occurrence into a
+// regular transaction.
+type Recurring struct {
+	ID          primitive.ObjectID  `json:"_id,omitempty" bson:"_id,omitempty"`
+	Description string              `json:"description" bson:"description"`
+	Amount      Money               `json:"amount" bson:"amount"`
+	Currency    string              `json:"currency" bson:"currency"`
+	Type        string              `json:"type" bson:"type"`
+	CategoryID  *primitive.ObjectID `json:"categoryId,omitempty" bson:"categoryId,omitempty"`
+	Tags        []string            `json:"tags,omitempty" bson:"tags,omitempty"`
+	// Rule is an RFC 5545 recurrence rule; see rrule for what is supported.
+	Rule string `json:"rule" bson:"rule"`
+	// Start is the first possible occurrence and sets the time of day.
+	Start  time.Time `json:"start" bson:"start"`
+	Paused bool      `json:"paused" bson:"paused"`
+	// Skipped lists occurrence dates (YYYY-MM-DD) that are not created.
+	Skipped []string `json:"skipped,omitempty" bson:"skipped,omitempty"`
+	// Through is the latest occurrence the scheduler has handled.
+	Through *time.Time `json:"materializedThrough,omitempty" bson:"through,omitempty"`
+	// Next is the next occurrence that will be created. It is computed for
+	// responses and not stored.
+	Next *time.Time `json:"next,omitempty" bson:"-"`
+}
+
+// RecurringStore keeps the recurring transaction templates.
+type RecurringStore interface {
+	// CreateRecurring stores r and assigns it a new ID.
+	CreateRecurring(ctx context.Context, r *Recurring) error
+	// ListRecurring returns every template ordered by ID.
+	ListRecurring(ctx context.Context) ([]Recurring, error)
+	// GetRecurring returns the template with the given ID or ErrNotFound.
+	GetRecurring(ctx context.Context, id primitive.ObjectID) (Recurring, error)
+	// SetRecurringPaused pauses or resumes the template with the given ID,
+	// leaving the rest of it untouched, and returns it. It returns
+	// ErrNotFound if there is no such template.
+	SetRecurringPaused(ctx context.Context, id primitive.ObjectID, paused bool) (Recurring, error)
+	// SkipRecurring adds date (YYYY-MM-DD) to the skipped occurrences of the
+	// template with the given ID, leaving the rest of it untouched, and
+	// returns it. It returns ErrNotFound if there is no such template.
+	SkipRecurring(ctx context.Context, id primitive.ObjectID, date string) (Recurring, error)
+	// DeleteRecurring removes the template with the given ID or returns
+	// ErrNotFound. Transactions created from it are kept.
+	DeleteRecurring(ctx context.Context, id primitive.ObjectID) error
+	// SetRecurringThrough records the latest occurrence handled for the
+	// template with the given ID, leaving the rest of it untouched. It never
+	// moves the mark backwards, and a missing template is not an error.
+	SetRecurringThrough(ctx context.Context, id primitive.ObjectID, through time.Time) error
+}
+
+func sortRecurring(templates []Recurring) {
+	sort.Slice(templates, func(i, j int) bool {
+		return bytes.Compare(templates[i].ID[:], templates[j].ID[:]) < 0
+	})
+}
+
+// recurringInput is the request body of POST /recurring.
+type recurringInput struct {
+	Description string   `json:"description"`
+	Amount      Money    `json:"amount"`
+	Currency    string   `json:"currency,omitempty"`
+	Type        string   `json:"type"`
+	CategoryID  string   `json:"categoryId,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Rule        string   `json:"rule"`
+	Start       string   `json:"start"`
+}
+
+// toRecurring validates in like a transaction dated at its start, which may
+// lie in the future.
+func (in recurringInput) toRecurring(rules validationRules) (Recurring, error) {
+	rules.FutureDates = futureDatePolicy{}
+	t, err := transactionInput{
+		Description: in.Description,
+		Amount:      in.Amount,
+		Currency:    in.Currency,
+		Type:        in.Type,
+		DateTime:    in.Start,
+		CategoryID:  in.CategoryID,
+		Tags:        in.Tags,
+	}.toTransaction(rules)
+
+	var errs validationError
+	if verr, ok := err.(*validationError); ok {
+		for _, f := range verr.Fields {
+			if f.Field == "dateTime" {
+				f.Field = "start"
+			}
+			errs.Fields = append(errs.Fields, f)
+		}
+	} else if err != nil {
+		return Recurring{}, err
+	}
+
+	if _, err := parseRRule(in.Rule); err != nil {
+		errs.add("rule", "%v", err)
+	}
+	if err := errs.err(); err != nil {
+		return Recurring{}, err
+	}
+
+	return Recurring{
+		Description: t.Description,
+		Amount:      t.Amount,
+		Currency:    t.Currency,
+		Type:        t.Type,
+		CategoryID:  t.CategoryID,
+		Tags:        t.Tags,
+		Rule:        strings.TrimPrefix(strings.TrimSpace(in.Rule), "RRULE:"),
+		Start:       t.DateTime.UTC(),
+	}, nil
+}
+
+// transaction returns the transaction for the occurrence at. Its ID is
+// derived from the template and the occurrence, so creating it twice fails
+// with ErrDuplicateID instead of producing a copy.
+func (r Recurring) transaction(at time.Time) Transaction {
+	sum := sha256.Sum256(append(r.ID[:], at.UTC().Format(time.RFC3339)...))
+	var id primitive.ObjectID
+	binary.BigEndian.PutUint32(id[0:4], uint32(at.Unix()))
+	copy(id[4:], sum[:8])
+
+	templateID := r.ID
+	return Transaction{
+		ID:          id,
+		Description: r.Description,
+		Amount:      r.Amount,
+		Currency:    r.Currency,
+		Type:        r.Type,
+		DateTime:    at,
+		CategoryID:  r.CategoryID,
+		Tags:        r.Tags,
+		RecurringID: &templateID,
+	}
+}
+
+func (r Recurring) skipped(at time.Time) bool {
+	day := at.UTC().Format("2006-01-02")
+	for _, d := range r.Skipped {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// upcoming returns up to limit occurrences after the ones already handled
+// and no later than horizon. Skipped occurrences are left out unless
+// withSkipped is set.
+func (r Recurring) upcoming(limit int, horizon time.Time, withSkipped bool) []time.Time {
+	rule, err := parseRRule(r.Rule)
+	if err != nil {
+		return nil
+	}
+	from := r.Start
+	if r.Through != nil {
+		from = r.Through.Add(time.Nanosecond)
+	}
+
+	var result []time.Time
+	for _, at := range rule.between(r.Start, from, horizon) {
+		if !withSkipped && r.skipped(at) {
+			continue
+		}
+		result = append(result, at)
+		if len(result) == limit {
+			break
+		}
+	}
+	return result
+}
+
+// withNext fills in r.Next.
+func (r Recurring) withNext(now time.Time) Recurring {
+	if r.Paused {
+		return r
+	}
+	if next := r.upcoming(1, now.AddDate(10, 0, 0), false); len(next) > 0 {
+		r.Next = &next[0]
+	}
+	return r
+}
+
+// materializeRecurring creates the transactions for every occurrence of an
+// active template up to now, including any missed while the server was
+// down. It is safe to run repeatedly and concurrently with itself: an
+// occurrence that already exists is left alone. A template that fails is
+// logged and left for the next run, without holding up the others.
+func (s *server) materializeRecurring(ctx context.Context, now time.Time) (int, error) {
+	templates, err := s.store.ListRecurring(ctx)
+	if err != nil {
+		return 0, err
+	}
+	engine, err := loadRuleEngine(ctx, s.store)
+	if err != nil {
+		return 0, err
+	}
+
+	created := 0
+	for _, r := range templates {
+		if r.Paused {
+			continue
+		}
+		n, err := s.materializeTemplate(ctx, engine, r, now)
+		created += n
+		if err != nil {
+			log.Printf("recurring %s: %v", r.ID.Hex(), err)
+		}
+	}
+	return created, nil
+}
+
+// materializeTemplate creates the due occurrences of r and records how far
+// it got. On error, the occurrences created so far are kept and Through is
+// left alone, so the next run picks up where this one stopped.
+func (s *server) materializeTemplate(ctx context.Context, engine *ruleEngine, r Recurring, now time.Time) (int, error) {
+	rule, err := parseRRule(r.Rule)
+	if err != nil {
+		return 0, err
+	}
+	from := r.Start
+	if r.Through != nil {
+		from = r.Through.Add(time.Nanosecond)
+	}
+
+	created := 0
+	due := rule.between(r.Start, from, now)
+	for _, at := range due {
+		if r.skipped(at) {
+			continue
+		}
+		t := r.transaction(at)
+		engine.apply(&t)
+		err := s.atomically(ctx, func(ctx context.Context) error {
+			if err := s.store.Create(ctx, &t); err != nil {
+				return err
+			}
+			s.appendAudit(ctx, origin{Actor: schedulerActor}, OpCreate, ResourceTransaction, t.ID.Hex(), nil, t)
+			return nil
+		})
+		if errors.Is(err, ErrDuplicateID) {
+			continue
+		}
+		if err != nil {
+			return created, err
+		}
+		created++
+	}
+	if len(due) > 0 {
+		if err := s.store.SetRecurringThrough(ctx, r.ID, due[len(due)-1]); err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
+
+// runScheduler materialises due recurring transactions now and then every
+// interval until ctx is cancelled.
+func (s *server) runScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		runCtx, cancel := context.WithTimeout(ctx, interval)
+		created, err := s.materializeRecurring(runCtx, time.Now())
+		cancel()
+		if err != nil {
+			log.Printf("Scheduler: %v", err)
+		}
+		if created > 0 {
+			log.Printf("Scheduler: created %d recurring transaction(s)", created)
+		}
+		runCtx, cancel = context.WithTimeout(ctx, interval)
+		s.purgeTrash(runCtx, time.Now())
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recurringPath splits /recurring/{id}[/{action}] into its parts.
+func recurringPath(r *http.Request) (primitive.ObjectID, string, error) {
+	rest := strings.TrimPrefix(r.URL.Path, "/recurring/")
+	id, action, _ := strings.Cut(rest, "/")
+	if id == "" {
+		return primitive.NilObjectID, "", errors.New("missing ID")
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, "", errors.New("invalid ID format")
+	}
+	return objID, action, nil
+}
+
+func writeRecurring(w http.ResponseWriter, status int, r Recurring) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(r.withNext(time.Now()))
+}
+
+// getRecurringList handles GET /recurring.
+func (s *server) getRecurringList(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	templates, err := s.store.ListRecurring(ctx)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	now := time.Now()
+	for i := range templates {
+		templates[i] = templates[i].withNext(now)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(templates)
+}
+
+// createRecurring handles POST /recurring. Occurrences between the start and
+// now are created by the next scheduler run.
+func (s *server) createRecurring(w http.ResponseWriter, r *http.Request) {
+	var requestBody recurringInput
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	rec, err := requestBody.toRecurring(s.rules)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := s.checkReferences(ctx, Transaction{Type: rec.Type, CategoryID: rec.CategoryID}); err != nil {
+		writeCheckError(w, err)
+		return
+	}
+
+	if err := s.store.CreateRecurring(ctx, &rec); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	s.audit(ctx, r, OpCreate, ResourceRecurring, rec.ID.Hex(), nil, rec)
+
+	writeRecurring(w, http.StatusCreated, rec)
+}
+
+// handleRecurringItem serves /recurring/{id} and its actions.
+func (s *server) handleRecurringItem(w http.ResponseWriter, r *http.Request) {
+	id, action, err := recurringPath(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		s.getRecurring(w, r, id)
+	case action == "" && r.Method == http.MethodDelete:
+		s.deleteRecurring(w, r, id)
+	case action == "occurrences" && r.Method == http.MethodGet:
+		s.getOccurrences(w, r, id)
+	case (action == "pause" || action == "resume") && r.Method == http.MethodPost:
+		s.pauseRecurring(w, r, id, action == "pause")
+	case action == "skip" && r.Method == http.MethodPost:
+		s.skipOccurrence(w, r, id)
+	case action == "" || action == "occurrences" || action == "pause" || action == "resume" || action == "skip":
+		writeMethodNotAllowed(w)
+	default:
+		writeNotFound(w, r)
+	}
+}
+
+// loadRecurring fetches a template for a handler, writing the error response
+// if that fails.
+func (s *server) loadRecurring(ctx context.Context, w http.ResponseWriter, id primitive.ObjectID) (Recurring, bool) {
+	rec, err := s.store.GetRecurring(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "recurring transaction not found")
+		return Recurring{}, false
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return Recurring{}, false
+	}
+	return rec, true
+}
+
+// writeRecurringChange audits op on before and writes rec, the template the
+// store returned, as the response, or writes err if the change failed. The
+// handlers change only the fields they own, so that a Through advanced by
+// the scheduler in the meantime is kept.
+func (s *server) writeRecurringChange(ctx context.Context, w http.ResponseWriter, r *http.Request, op string, before, rec Recurring, err error) {
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "recurring transaction not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	s.audit(ctx, r, op, ResourceRecurring, rec.ID.Hex(), before, rec)
+	writeRecurring(w, http.StatusOK, rec)
+}
+
+// getRecurring handles GET /recurring/{id}.
+func (s *server) getRecurring(w http.ResponseWriter, r *http.Request, id primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if rec, ok := s.loadRecurring(ctx, w, id); ok {
+		writeRecurring(w, http.StatusOK, rec)
+	}
+}
+
+// deleteRecurring handles DELETE /recurring/{id}.
+func (s *server) deleteRecurring(w http.ResponseWriter, r *http.Request, id primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	current, err := s.store.GetRecurring(ctx, id)
+	if err == nil {
+		err = s.store.DeleteRecurring(ctx, id)
+	}
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "recurring transaction not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	s.audit(ctx, r, OpDelete, ResourceRecurring, id.Hex(), current, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// occurrence is an upcoming occurrence of a template.
+type occurrence struct {
+	DateTime time.Time `json:"dateTime"`
+	Skipped  bool      `json:"skipped"`
+}
+
+// getOccurrences handles GET /recurring/{id}/occurrences. It lists the next
+// ?limit= (default 10) occurrences that have not been created yet,
+// including skipped ones.
+func (s *server) getOccurrences(w http.ResponseWriter, r *http.Request, id primitive.ObjectID) {
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "limit must be an integer between 1 and "+strconv.Itoa(maxPageSize))
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rec, ok := s.loadRecurring(ctx, w, id)
+	if !ok {
+		return
+	}
+
+	occurrences := []occurrence{}
+	for _, at := range rec.upcoming(limit, time.Now().AddDate(10, 0, 0), true) {
+		occurrences = append(occurrences, occurrence{DateTime: at, Skipped: rec.skipped(at)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(occurrences)
+}
+
+// pauseRecurring handles POST /recurring/{id}/pause and /resume. Occurrences
+// that fall due while a template is paused are not created on resume.
+func (s *server) pauseRecurring(w http.ResponseWriter, r *http.Request, id primitive.ObjectID, pause bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rec, ok := s.loadRecurring(ctx, w, id)
+	if !ok {
+		return
+	}
+	if rec.Paused == pause {
+		writeRecurring(w, http.StatusOK, rec)
+		return
+	}
+
+	op := OpPause
+	if !pause {
+		// Occurrences due while paused are passed over. The template is
+		// still paused here, so the scheduler cannot create them meanwhile.
+		op = OpResume
+		if err := s.store.SetRecurringThrough(ctx, id, time.Now().UTC()); err != nil {
+			writeInternalError(w, err)
+			return
+		}
+	}
+	updated, err := s.store.SetRecurringPaused(ctx, id, pause)
+	s.writeRecurringChange(ctx, w, r, op, rec, updated, err)
+}
+
+// skipOccurrence handles POST /recurring/{id}/skip with a body such as
+// {"date": "2025-04-01"}. The date must be an occurrence that has not been
+// created yet.
+func (s *server) skipOccurrence(w http.ResponseWriter, r *http.Request, id primitive.ObjectID) {
+	var requestBody struct {
+		Date string `json:"date"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	day, err := time.Parse("2006-01-02", requestBody.Date)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "date must be in YYYY-MM-DD format")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rec, ok := s.loadRecurring(ctx, w, id)
+	if !ok {
+		return
+	}
+	rule, err := parseRRule(rec.Rule)
+	if err != nil {
+		writeInternalError(w, fmt.Errorf("stored rule is invalid: %v", err))
+		return
+	}
+
+	dayEnd := day.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	occurrences := rule.between(rec.Start, day, dayEnd)
+	if len(occurrences) == 0 {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "no occurrence falls on "+requestBody.Date)
+		return
+	}
+	if rec.Through != nil && !occurrences[0].After(*rec.Through) {
+		writeError(w, http.StatusConflict, CodeConflict, "occurrence on "+requestBody.Date+" has already been created; delete the transaction instead")
+		return
+	}
+
+	updated, err := s.store.SkipRecurring(ctx, id, day.Format("2006-01-02"))
+	s.writeRecurringChange(ctx, w, r, OpSkip, rec, updated, err)
+}
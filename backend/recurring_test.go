@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRecurringChangesKeepThrough(t *testing.T) {
+	ctx := context.Background()
+	stores := map[string]Store{
+		"memory": newMemoryStore(),
+		"file":   mustReopen(t, filepath.Join(t.TempDir(), "data.json")),
+	}
+	for name, store := range stores {
+		rec := Recurring{
+			Description: "Rent", Amount: mustMoney(t, "4500"), Currency: "INR", Type: TypeExpense,
+			Rule: "FREQ=MONTHLY", Start: time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC),
+		}
+		if err := store.CreateRecurring(ctx, &rec); err != nil {
+			t.Fatal(err)
+		}
+		// The scheduler handles an occurrence after the handler loaded the
+		// template.
+		through := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+		if err := store.SetRecurringThrough(ctx, rec.ID, through); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.SkipRecurring(ctx, rec.ID, "2025-06-01"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.SkipRecurring(ctx, rec.ID, "2025-05-01"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.SkipRecurring(ctx, rec.ID, "2025-06-01"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.SetRecurringPaused(ctx, rec.ID, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Through == nil || !got.Through.Equal(through) {
+			t.Errorf("%s: through = %v, want %v", name, got.Through, through)
+		}
+		if !got.Paused {
+			t.Errorf("%s: template not paused", name)
+		}
+		if want := []string{"2025-05-01", "2025-06-01"}; !reflect.DeepEqual(got.Skipped, want) {
+			t.Errorf("%s: skipped = %v, want %v", name, got.Skipped, want)
+		}
+		if _, err := store.SkipRecurring(ctx, primitive.NewObjectID(), "2025-06-01"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: skipping on a missing template = %v, want ErrNotFound", name, err)
+		}
+	}
+}
+
+// recurringTransactions returns the dates of the transactions created from
+// each template, by description, failing on a duplicate ID.
+func recurringTransactions(t *testing.T, store Store) map[string][]string {
+	t.Helper()
+	list, err := store.List(context.Background(), ListOptions{Filter: TransactionFilter{Scope: scopeAll}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[primitive.ObjectID]bool{}
+	dates := map[string][]string{}
+	for i := len(list) - 1; i >= 0; i-- {
+		tx := list[i]
+		if tx.RecurringID == nil {
+			continue
+		}
+		if seen[tx.ID] {
+			t.Errorf("occurrence %s was created twice", tx.ID.Hex())
+		}
+		seen[tx.ID] = true
+		dates[tx.Description] = append(dates[tx.Description], tx.DateTime.Format("2006-01-02"))
+	}
+	return dates
+}
+
+func TestMaterializeRecurringIsIdempotent(t *testing.T) {
+	s := newTestServer()
+	ctx := context.Background()
+	start := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+	rent := Recurring{Description: "Rent", Amount: mustMoney(t, "4500"), Currency: "INR", Type: TypeExpense,
+		Rule: "FREQ=MONTHLY", Start: start, Skipped: []string{"2025-02-01"}}
+	gym := Recurring{Description: "Gym", Amount: mustMoney(t, "900"), Currency: "INR", Type: TypeExpense,
+		Rule: "FREQ=MONTHLY", Start: start, Paused: true}
+	for _, r := range []*Recurring{&rent, &gym} {
+		if err := s.store.CreateRecurring(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Date(2025, 4, 15, 0, 0, 0, 0, time.UTC)
+	want := map[string][]string{"Rent": {"2025-01-01", "2025-03-01", "2025-04-01"}}
+
+	runs := []struct {
+		name string
+		// before prepares the run.
+		before  func(t *testing.T)
+		created int
+	}{
+		{"first run", func(t *testing.T) {}, 3},
+		{"second run", func(t *testing.T) {}, 0},
+		{"catch-up after a restart", func(t *testing.T) {
+			// A server that stopped before recording its progress goes
+			// over the same occurrences again.
+			if err := s.store.SetRecurringThrough(ctx, rent.ID, start.Add(-time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+		}, 0},
+	}
+	for _, run := range runs {
+		run.before(t)
+		created, err := s.materializeRecurring(ctx, now)
+		if err != nil {
+			t.Fatalf("%s: %v", run.name, err)
+		}
+		if created != run.created {
+			t.Errorf("%s: created %d, want %d", run.name, created, run.created)
+		}
+		if got := recurringTransactions(t, s.store); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: transactions = %v, want %v", run.name, got, want)
+		}
+		got, err := s.store.GetRecurring(ctx, rent.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Through == nil || !got.Through.Equal(time.Date(2025, 4, 1, 9, 0, 0, 0, time.UTC)) {
+			t.Errorf("%s: through = %v, want 2025-04-01 09:00", run.name, got.Through)
+		}
+	}
+}
+
+// failingCreates fails to create transactions with the given description.
+type failingCreates struct {
+	Store
+	description string
+}
+
+func (s failingCreates) Create(ctx context.Context, t *Transaction) error {
+	if t.Description == s.description {
+		return errors.New("disk full")
+	}
+	return s.Store.Create(ctx, t)
+}
+
+func TestMaterializeRecurringContinuesAfterFailure(t *testing.T) {
+	s := newTestServer()
+	ctx := context.Background()
+	start := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+	var broken Recurring
+	for _, d := range []string{"Broken", "Rent"} {
+		r := Recurring{Description: d, Amount: mustMoney(t, "100"), Currency: "INR", Type: TypeExpense, Rule: "FREQ=MONTHLY", Start: start}
+		if err := s.store.CreateRecurring(ctx, &r); err != nil {
+			t.Fatal(err)
+		}
+		if d == "Broken" {
+			broken = r
+		}
+	}
+	s.store = failingCreates{s.store, "Broken"}
+
+	created, err := s.materializeRecurring(ctx, time.Date(2025, 2, 15, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 2 {
+		t.Errorf("created %d, want the 2 occurrences of Rent", created)
+	}
+	if got := recurringTransactions(t, s.store); len(got["Rent"]) != 2 || len(got["Broken"]) != 0 {
+		t.Errorf("transactions = %v, want 2 for Rent and none for Broken", got)
+	}
+	if got, _ := s.store.GetRecurring(ctx, broken.ID); got.Through != nil {
+		t.Errorf("through of the failed template = %v, want it left unset", got.Through)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// maxReportPeriods bounds the number of buckets a single report may span so a
+// wide range at day granularity cannot produce an unbounded response.
+const maxReportPeriods = 1000
+
+// granularity is the width of a report bucket.
+type granularity string
+
+const (
+	granularityDay   granularity = "day"
+	granularityWeek  granularity = "week"
+	granularityMonth granularity = "month"
+	granularityYear  granularity = "year"
+)
+
+func parseGranularity(s string) (granularity, error) {
+	switch g := granularity(s); g {
+	case granularityDay, granularityWeek, granularityMonth, granularityYear:
+		return g, nil
+	case "":
+		return granularityMonth, nil
+	default:
+		return "", fmt.Errorf("granularity must be day, week, month or year")
+	}
+}
+
+// truncate returns the start of the bucket containing t. Buckets are computed
+// in UTC and weeks start on Monday.
+func (g granularity) truncate(t time.Time) time.Time {
+	t = t.UTC()
+	y, m, d := t.Date()
+	switch g {
+	case granularityDay:
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	case granularityWeek:
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, time.UTC)
+	case granularityYear:
+		return time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// next returns the start of the bucket following the one starting at start.
+func (g granularity) next(start time.Time) time.Time {
+	switch g {
+	case granularityDay:
+		return start.AddDate(0, 0, 1)
+	case granularityWeek:
+		return start.AddDate(0, 0, 7)
+	case granularityYear:
+		return start.AddDate(1, 0, 0)
+	default:
+		return start.AddDate(0, 1, 0)
+	}
+}
+
+// PeriodTotals holds the totals of a single report bucket.
+type PeriodTotals struct {
+	Start   time.Time `json:"start"`
+	Income  Money     `json:"income"`
+	Expense Money     `json:"expense"`
+	Net     Money     `json:"net"`
+	Count   int       `json:"count"`
+}
+
+// aggregatePeriods folds daily totals, ordered by day, into buckets of
+// granularity g. Amounts are converted to the base currency with conv.
+func aggregatePeriods(ctx context.Context, totals []DailyTotal, g granularity, conv *converter) ([]PeriodTotals, error) {
+	var periods []PeriodTotals
+	for _, dt := range totals {
+		if dt.Type == TypeTransfer {
+			continue
+		}
+		amount, err := conv.convert(ctx, dt.Total, dt.Currency, dt.Day)
+		if err != nil {
+			return nil, err
+		}
+
+		start := g.truncate(dt.Day)
+		if len(periods) == 0 || !periods[len(periods)-1].Start.Equal(start) {
+			periods = append(periods, PeriodTotals{Start: start})
+		}
+		p := &periods[len(periods)-1]
+		if err := addByType(dt.Type, amount, &p.Income, &p.Expense, &p.Net); err != nil {
+			return nil, fmt.Errorf("totals from %s: %w", start.Format("2006-01-02"), err)
+		}
+		p.Count += dt.Count
+	}
+	return periods, nil
+}
+
+// normalize pads every total to at least scale decimal places.
+func (p *PeriodTotals) normalize(scale int) {
+	p.Income = p.Income.atLeastScale(scale)
+	p.Expense = p.Expense.atLeastScale(scale)
+	p.Net = p.Net.atLeastScale(scale)
+}
+
+// fillPeriods returns one bucket for every period between from and to,
+// taking totals from periods and zero-filling the rest. A nil bound defaults
+// to the first or last bucket in periods.
+func fillPeriods(periods []PeriodTotals, g granularity, from, to *time.Time) ([]PeriodTotals, error) {
+	if len(periods) == 0 && (from == nil || to == nil) {
+		return []PeriodTotals{}, nil
+	}
+
+	var first, last time.Time
+	if from != nil {
+		first = g.truncate(*from)
+	} else {
+		first = periods[0].Start
+	}
+	if to != nil {
+		last = g.truncate(*to)
+	} else {
+		last = periods[len(periods)-1].Start
+	}
+
+	byStart := make(map[time.Time]PeriodTotals, len(periods))
+	for _, p := range periods {
+		byStart[p.Start] = p
+	}
+
+	filled := []PeriodTotals{}
+	for start := first; !start.After(last); start = g.next(start) {
+		if len(filled) == maxReportPeriods {
+			return nil, fmt.Errorf("report spans more than %d periods; narrow the range or use a coarser granularity", maxReportPeriods)
+		}
+		p, ok := byStart[start]
+		if !ok {
+			p = PeriodTotals{Start: start}
+		}
+		filled = append(filled, p)
+	}
+	return filled, nil
+}
+
+// getPeriodReport handles GET /reports/periods. It accepts the filters of
+// GET /transactions plus granularity (day, week, month or year; default
+// month). Totals are in the base currency.
+func (s *server) getPeriodReport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	g, err := parseGranularity(q.Get("granularity"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	filter, err := parseTransactionFilter(q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := s.expandCategoryFilter(ctx, &filter); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	totals, err := s.store.DailyTotals(ctx, filter)
+	if err != nil {
+		writeAggregateError(w, err)
+		return
+	}
+	periods, err := aggregatePeriods(ctx, totals, g, newConverter(s.store, s.rules.DefaultCurrency))
+	if err != nil {
+		writeAggregateError(w, err)
+		return
+	}
+
+	periods, err = fillPeriods(periods, g, filter.From, filter.To)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	scale := currencyScale(s.rules.DefaultCurrency)
+	for i := range periods {
+		periods[i].normalize(scale)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Granularity granularity    `json:"granularity"`
+		Currency    string         `json:"currency"`
+		Periods     []PeriodTotals `json:"periods"`
+	}{g, s.rules.DefaultCurrency, periods})
+}
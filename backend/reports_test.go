@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPeriodReport(t *testing.T) {
+	tests := []struct {
+		name string
+		// transactions are "type amount dateTime".
+		transactions []string
+		query        string
+		// want lists "start income expense net count" per period.
+		want []string
+	}{
+		{
+			name:         "empty months are zero-filled",
+			transactions: []string{"income 1000 2025-01-15T09:00:00Z", "expense 200 2025-03-10T09:00:00Z"},
+			query:        "granularity=month&from=2025-01-01&to=2025-04-30",
+			want: []string{
+				"2025-01-01 1000.00 0.00 1000.00 1",
+				"2025-02-01 0.00 0.00 0.00 0",
+				"2025-03-01 0.00 200.00 -200.00 1",
+				"2025-04-01 0.00 0.00 0.00 0",
+			},
+		},
+		{
+			name:         "without bounds the range is that of the data",
+			transactions: []string{"income 1000 2025-01-15T09:00:00Z", "expense 200 2025-03-10T09:00:00Z"},
+			query:        "",
+			want: []string{
+				"2025-01-01 1000.00 0.00 1000.00 1",
+				"2025-02-01 0.00 0.00 0.00 0",
+				"2025-03-01 0.00 200.00 -200.00 1",
+			},
+		},
+		{
+			name:  "empty range",
+			query: "granularity=day&from=2025-03-01&to=2025-03-02",
+			want:  []string{"2025-03-01 0.00 0.00 0.00 0", "2025-03-02 0.00 0.00 0.00 0"},
+		},
+		{
+			name: "period boundaries",
+			transactions: []string{
+				"expense 10 2025-03-31T23:59:59.999Z",
+				"expense 20 2025-04-01T00:00:00Z",
+				"income 5 2025-12-31T23:59:59Z",
+				"income 7 2026-01-01T00:00:00Z",
+			},
+			query: "granularity=year",
+			want:  []string{"2025-01-01 5.00 30.00 -25.00 3", "2026-01-01 7.00 0.00 7.00 1"},
+		},
+		{
+			name:         "day boundaries",
+			transactions: []string{"expense 10 2025-03-31T23:59:59.999Z", "expense 20 2025-04-01T00:00:00Z"},
+			query:        "granularity=day",
+			want:         []string{"2025-03-31 0.00 10.00 -10.00 1", "2025-04-01 0.00 20.00 -20.00 1"},
+		},
+		{
+			name: "weeks start on Monday",
+			transactions: []string{
+				"expense 1 2025-03-02T12:00:00Z", // Sunday
+				"expense 2 2025-03-03T00:00:00Z", // Monday
+				"expense 3 2025-03-09T23:59:59Z", // Sunday
+				"expense 4 2025-03-10T00:00:00Z", // Monday
+			},
+			query: "granularity=week",
+			want: []string{
+				"2025-02-24 0.00 1.00 -1.00 1",
+				"2025-03-03 0.00 5.00 -5.00 2",
+				"2025-03-10 0.00 4.00 -4.00 1",
+			},
+		},
+		{
+			name: "periods are computed in UTC",
+			transactions: []string{
+				// Monday morning in India is still Sunday in UTC.
+				"expense 1 2025-03-03T01:00:00+05:30",
+				// The last evening of March in Brazil is April in UTC.
+				"expense 2 2025-03-31T23:30:00-03:00",
+			},
+			query: "granularity=week&from=2025-02-24&to=2025-04-01",
+			want: []string{
+				"2025-02-24 0.00 1.00 -1.00 1",
+				"2025-03-03 0.00 0.00 0.00 0",
+				"2025-03-10 0.00 0.00 0.00 0",
+				"2025-03-17 0.00 0.00 0.00 0",
+				"2025-03-24 0.00 0.00 0.00 0",
+				"2025-03-31 0.00 2.00 -2.00 1",
+			},
+		},
+		{
+			name:         "a month in another time zone",
+			transactions: []string{"expense 2 2025-03-31T23:30:00-03:00"},
+			query:        "granularity=month&from=2025-03-01&to=2025-04-30",
+			want:         []string{"2025-03-01 0.00 0.00 0.00 0", "2025-04-01 0.00 2.00 -2.00 1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes := newTestServer().routes()
+			for _, tx := range tt.transactions {
+				var typ, amount, at string
+				fmt.Sscan(tx, &typ, &amount, &at)
+				body := fmt.Sprintf(`{"description":"Entry","amount":%q,"type":%q,"dateTime":%q}`, amount, typ, at)
+				if w := do(routes.ServeHTTP, http.MethodPost, "/transactions", body, nil); w.Code != http.StatusCreated {
+					t.Fatalf("create %s: got %d %s", tx, w.Code, w.Body)
+				}
+			}
+
+			w := do(routes.ServeHTTP, http.MethodGet, "/reports/periods?"+tt.query, "", nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET /reports/periods?%s: got %d %s", tt.query, w.Code, w.Body)
+			}
+			var report struct {
+				Currency string
+				Periods  []PeriodTotals
+			}
+			decode(t, w, &report)
+			var got []string
+			for _, p := range report.Periods {
+				got = append(got, fmt.Sprintf("%s %s %s %s %d", p.Start.Format("2006-01-02"), p.Income, p.Expense, p.Net, p.Count))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("periods:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			if report.Currency != "INR" {
+				t.Errorf("currency = %q, want INR", report.Currency)
+			}
+		})
+	}
+}
+
+func TestPeriodReportRejectsBadParameters(t *testing.T) {
+	routes := newTestServer().routes()
+	for _, query := range []string{
+		"granularity=quarter",
+		"granularity=day&from=2020-01-01&to=2025-12-31",
+		"from=2025-03-01&to=2025-01-01",
+	} {
+		if w := do(routes.ServeHTTP, http.MethodGet, "/reports/periods?"+query, "", nil); w.Code != http.StatusBadRequest {
+			t.Errorf("GET /reports/periods?%s: got %d %s, want 400", query, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrence frequencies, as named by RFC 5545.
+const (
+	freqDaily   = "DAILY"
+	freqWeekly  = "WEEKLY"
+	freqMonthly = "MONTHLY"
+	freqYearly  = "YEARLY"
+)
+
+// maxRulePeriods bounds how many periods rrule.between walks, so a rule that
+// rarely or never produces an occurrence cannot loop forever.
+const maxRulePeriods = 100_000
+
+var weekdayCodes = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+// byDay is a BYDAY entry such as "FR", "1MO" (first Monday) or "-1FR" (last
+// Friday). N is zero when no ordinal is given.
+type byDay struct {
+	N       int
+	Weekday time.Weekday
+}
+
+// rrule is the subset of an RFC 5545 recurrence rule that recurring
+// transactions support: FREQ (DAILY, WEEKLY, MONTHLY or YEARLY), INTERVAL,
+// BYDAY, BYMONTHDAY, BYSETPOS, COUNT and UNTIL. For example:
+//
+//	FREQ=MONTHLY;BYMONTHDAY=1                   the 1st of every month
+//	FREQ=WEEKLY;BYDAY=MO                        every Monday
+//	FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1  the last business day
+//
+// Occurrences are computed in UTC at the time of day of the start.
+type rrule struct {
+	Freq       string
+	Interval   int
+	ByDay      []byDay
+	ByMonthDay []int
+	BySetPos   []int
+	Count      int
+	Until      *time.Time
+}
+
+// parseRRule parses a rule such as "FREQ=MONTHLY;BYMONTHDAY=-1". A leading
+// "RRULE:" is accepted.
+func parseRRule(s string) (rrule, error) {
+	r := rrule{Interval: 1}
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return r, fmt.Errorf("rule is empty")
+	}
+
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.ToUpper(name)
+		if !ok || value == "" {
+			return r, fmt.Errorf("%q is not NAME=VALUE", part)
+		}
+		if seen[name] {
+			return r, fmt.Errorf("%s is given more than once", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			r.Freq = strings.ToUpper(value)
+			switch r.Freq {
+			case freqDaily, freqWeekly, freqMonthly, freqYearly:
+			default:
+				return r, fmt.Errorf("FREQ must be DAILY, WEEKLY, MONTHLY or YEARLY")
+			}
+		case "INTERVAL":
+			if r.Interval, err = strconv.Atoi(value); err != nil || r.Interval < 1 {
+				return r, fmt.Errorf("INTERVAL must be a positive integer")
+			}
+		case "COUNT":
+			if r.Count, err = strconv.Atoi(value); err != nil || r.Count < 1 {
+				return r, fmt.Errorf("COUNT must be a positive integer")
+			}
+		case "UNTIL":
+			until, err := parseUntil(value)
+			if err != nil {
+				return r, err
+			}
+			r.Until = &until
+		case "BYDAY":
+			for _, v := range strings.Split(strings.ToUpper(value), ",") {
+				d, err := parseByDay(v)
+				if err != nil {
+					return r, err
+				}
+				r.ByDay = append(r.ByDay, d)
+			}
+		case "BYMONTHDAY":
+			if r.ByMonthDay, err = parseIntList(value, 31); err != nil {
+				return r, fmt.Errorf("BYMONTHDAY %v", err)
+			}
+		case "BYSETPOS":
+			if r.BySetPos, err = parseIntList(value, 366); err != nil {
+				return r, fmt.Errorf("BYSETPOS %v", err)
+			}
+		default:
+			return r, fmt.Errorf("%s is not supported", name)
+		}
+	}
+
+	if r.Freq == "" {
+		return r, fmt.Errorf("FREQ is required")
+	}
+	if r.Count > 0 && r.Until != nil {
+		return r, fmt.Errorf("COUNT and UNTIL must not both be given")
+	}
+	if len(r.ByMonthDay) > 0 && r.Freq != freqMonthly {
+		return r, fmt.Errorf("BYMONTHDAY is only supported with FREQ=MONTHLY")
+	}
+	for _, d := range r.ByDay {
+		if d.N != 0 && r.Freq != freqMonthly {
+			return r, fmt.Errorf("BYDAY ordinals such as 1MO are only supported with FREQ=MONTHLY")
+		}
+	}
+	if len(r.ByDay) > 0 && r.Freq == freqYearly {
+		return r, fmt.Errorf("BYDAY is not supported with FREQ=YEARLY")
+	}
+	return r, nil
+}
+
+func parseUntil(v string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			if layout == "20060102" {
+				t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("UNTIL must look like 20251231 or 20251231T235959Z")
+}
+
+func parseByDay(v string) (byDay, error) {
+	if len(v) < 2 {
+		return byDay{}, fmt.Errorf("BYDAY %q is not a weekday", v)
+	}
+	wd, ok := weekdayCodes[v[len(v)-2:]]
+	if !ok {
+		return byDay{}, fmt.Errorf("BYDAY %q is not a weekday", v)
+	}
+	d := byDay{Weekday: wd}
+	if prefix := v[:len(v)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return byDay{}, fmt.Errorf("BYDAY %q has an invalid ordinal", v)
+		}
+		d.N = n
+	}
+	return d, nil
+}
+
+// parseIntList parses comma-separated non-zero integers in [-max, max].
+func parseIntList(v string, max int) ([]int, error) {
+	var list []int
+	for _, s := range strings.Split(v, ",") {
+		n, err := strconv.Atoi(s)
+		if err != nil || n == 0 || n < -max || n > max {
+			return nil, fmt.Errorf("values must be non-zero integers between -%d and %d", max, max)
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+// between returns the occurrences of r that start at start and fall in
+// [from, to], in order. Occurrences before from still count towards COUNT.
+func (r rrule) between(start, from, to time.Time) []time.Time {
+	start = start.UTC()
+	clock := start.Sub(granularityDay.truncate(start))
+
+	var result []time.Time
+	produced := 0
+	period := r.firstPeriod(start)
+	for i := 0; i < maxRulePeriods; i, period = i+1, r.nextPeriod(period) {
+		if period.After(to) {
+			break
+		}
+		for _, day := range r.candidates(period, start) {
+			t := day.Add(clock)
+			if t.Before(start) {
+				continue
+			}
+			if r.Until != nil && t.After(*r.Until) {
+				return result
+			}
+			produced++
+			if r.Count > 0 && produced > r.Count {
+				return result
+			}
+			if t.After(to) {
+				return result
+			}
+			if !t.Before(from) {
+				result = append(result, t)
+			}
+		}
+	}
+	return result
+}
+
+// firstPeriod returns the start of the period containing start.
+func (r rrule) firstPeriod(start time.Time) time.Time {
+	switch r.Freq {
+	case freqWeekly:
+		return granularityWeek.truncate(start)
+	case freqMonthly:
+		return granularityMonth.truncate(start)
+	case freqYearly:
+		return granularityYear.truncate(start)
+	default:
+		return granularityDay.truncate(start)
+	}
+}
+
+func (r rrule) nextPeriod(period time.Time) time.Time {
+	switch r.Freq {
+	case freqWeekly:
+		return period.AddDate(0, 0, 7*r.Interval)
+	case freqMonthly:
+		return period.AddDate(0, r.Interval, 0)
+	case freqYearly:
+		return period.AddDate(r.Interval, 0, 0)
+	default:
+		return period.AddDate(0, 0, r.Interval)
+	}
+}
+
+// candidates returns the days in the period starting at period that the rule
+// selects, in order, after applying BYSETPOS.
+func (r rrule) candidates(period, start time.Time) []time.Time {
+	var days []time.Time
+	switch r.Freq {
+	case freqDaily:
+		if len(r.ByDay) == 0 || r.matchesWeekday(period.Weekday()) {
+			days = append(days, period)
+		}
+	case freqWeekly:
+		if len(r.ByDay) == 0 {
+			offset := (int(start.Weekday()) + 6) % 7
+			days = append(days, period.AddDate(0, 0, offset))
+			break
+		}
+		for i := 0; i < 7; i++ {
+			if day := period.AddDate(0, 0, i); r.matchesWeekday(day.Weekday()) {
+				days = append(days, day)
+			}
+		}
+	case freqMonthly:
+		days = r.monthDays(period, start)
+	case freqYearly:
+		day := time.Date(period.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+		if day.Month() == start.Month() {
+			days = append(days, day)
+		}
+	}
+	return r.applySetPos(days)
+}
+
+func (r rrule) matchesWeekday(wd time.Weekday) bool {
+	for _, d := range r.ByDay {
+		if d.Weekday == wd {
+			return true
+		}
+	}
+	return false
+}
+
+// monthDays returns the days of the month starting at month selected by
+// BYMONTHDAY and BYDAY, or the day of month of start if neither is given.
+// Days that do not exist in the month, such as the 31st of April, are
+// skipped.
+func (r rrule) monthDays(month, start time.Time) []time.Time {
+	length := month.AddDate(0, 1, -1).Day()
+
+	selected := make(map[int]bool)
+	if len(r.ByMonthDay) > 0 {
+		for _, n := range r.ByMonthDay {
+			if n < 0 {
+				n = length + 1 + n
+			}
+			if n >= 1 && n <= length {
+				selected[n] = true
+			}
+		}
+	} else if len(r.ByDay) == 0 {
+		if start.Day() <= length {
+			selected[start.Day()] = true
+		}
+	}
+
+	if len(r.ByDay) > 0 {
+		byDay := make(map[int]bool)
+		for _, d := range r.ByDay {
+			for _, n := range nthWeekdays(month, length, d) {
+				byDay[n] = true
+			}
+		}
+		if len(r.ByMonthDay) > 0 {
+			for n := range selected {
+				if !byDay[n] {
+					delete(selected, n)
+				}
+			}
+		} else {
+			selected = byDay
+		}
+	}
+
+	nums := make([]int, 0, len(selected))
+	for n := range selected {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+
+	days := make([]time.Time, len(nums))
+	for i, n := range nums {
+		days[i] = month.AddDate(0, 0, n-1)
+	}
+	return days
+}
+
+// nthWeekdays returns the days of the month matching d: every such weekday
+// without an ordinal, otherwise only the N-th (or N-th from last).
+func nthWeekdays(month time.Time, length int, d byDay) []int {
+	var all []int
+	first := (int(d.Weekday) - int(month.Weekday()) + 7) % 7
+	for n := first + 1; n <= length; n += 7 {
+		all = append(all, n)
+	}
+	switch {
+	case d.N == 0:
+		return all
+	case d.N > 0 && d.N <= len(all):
+		return all[d.N-1 : d.N]
+	case d.N < 0 && -d.N <= len(all):
+		return all[len(all)+d.N : len(all)+d.N+1]
+	}
+	return nil
+}
+
+// applySetPos keeps only the BYSETPOS positions of days, if any are given.
+func (r rrule) applySetPos(days []time.Time) []time.Time {
+	if len(r.BySetPos) == 0 {
+		return days
+	}
+	var kept []time.Time
+	for i := range days {
+		for _, pos := range r.BySetPos {
+			if pos == i+1 || pos == i-len(days) {
+				kept = append(kept, days[i])
+				break
+			}
+		}
+	}
+	return kept
+}
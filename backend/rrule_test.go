@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRRuleBetween(t *testing.T) {
+	start := time.Date(2025, 1, 1, 9, 30, 0, 0, time.UTC)
+	from := start
+	to := time.Date(2025, 3, 31, 23, 59, 0, 0, time.UTC)
+	tests := []struct {
+		rule string
+		want []string
+	}{
+		{"FREQ=MONTHLY;BYMONTHDAY=1", []string{"2025-01-01", "2025-02-01", "2025-03-01"}},
+		{"FREQ=MONTHLY;BYMONTHDAY=-1", []string{"2025-01-31", "2025-02-28", "2025-03-31"}},
+		{"FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1", []string{"2025-01-31", "2025-02-28", "2025-03-31"}},
+		{"FREQ=MONTHLY;BYDAY=1MO", []string{"2025-01-06", "2025-02-03", "2025-03-03"}},
+		{"FREQ=WEEKLY;INTERVAL=4;BYDAY=WE", []string{"2025-01-01", "2025-01-29", "2025-02-26", "2025-03-26"}},
+		{"FREQ=DAILY;COUNT=3", []string{"2025-01-01", "2025-01-02", "2025-01-03"}},
+		{"FREQ=WEEKLY;UNTIL=20250115T000000Z", []string{"2025-01-01", "2025-01-08"}},
+		{"RRULE:FREQ=YEARLY", []string{"2025-01-01"}},
+	}
+	for _, tt := range tests {
+		r, err := parseRRule(tt.rule)
+		if err != nil {
+			t.Errorf("parseRRule(%q): %v", tt.rule, err)
+			continue
+		}
+		var got []string
+		for _, at := range r.between(start, from, to) {
+			if at.Hour() != 9 || at.Minute() != 30 {
+				t.Errorf("%s: occurrence %v is not at the time of the start", tt.rule, at)
+			}
+			got = append(got, at.Format("2006-01-02"))
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.rule, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.rule, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestRRuleBetweenCountsFromStart(t *testing.T) {
+	// COUNT limits the whole series, not the window asked for.
+	r, err := parseRRule("FREQ=DAILY;COUNT=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	got := r.between(start, start.AddDate(0, 0, 2), start.AddDate(0, 0, 10))
+	if len(got) != 1 || !got[0].Equal(start.AddDate(0, 0, 2)) {
+		t.Errorf("got %v, want only the third occurrence", got)
+	}
+}
+
+func TestParseRRuleRejects(t *testing.T) {
+	for _, rule := range []string{
+		"",
+		"FREQ=HOURLY",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=DAILY;COUNT=2;UNTIL=20250101T000000Z",
+		"INTERVAL=2",
+	} {
+		if _, err := parseRRule(rule); err == nil {
+			t.Errorf("parseRRule(%q) succeeded, want an error", rule)
+		}
+	}
+}
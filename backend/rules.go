@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	maxRuleNameLength = 100
+	maxMetadataKeys   = 20
+	maxMetadataKey    = 50
+	maxMetadataValue  = 200
+)
+
+// Rule categorises transactions automatically. Rules are tried in ascending
+// Priority order and the first one whose conditions all hold is applied.
+type Rule struct {
+	ID       primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Name     string             `json:"name" bson:"name"`
+	Priority int                `json:"priority" bson:"priority"`
+	Match    RuleMatch          `json:"match" bson:"match"`
+	// CategoryID and Metadata are stamped onto matching transactions.
+	CategoryID *primitive.ObjectID `json:"categoryId,omitempty" bson:"categoryId,omitempty"`
+	Metadata   map[string]string   `json:"metadata,omitempty" bson:"metadata,omitempty"`
+}
+
+// RuleMatch lists the conditions of a rule. Empty fields always hold.
+type RuleMatch struct {
+	// Contains is a case-insensitive substring of the description.
+	Contains string `json:"contains,omitempty" bson:"contains,omitempty"`
+	// Pattern is a regular expression matched against the description.
+	Pattern   string `json:"pattern,omitempty" bson:"pattern,omitempty"`
+	Type      string `json:"type,omitempty" bson:"type,omitempty"`
+	MinAmount *Money `json:"minAmount,omitempty" bson:"minAmount,omitempty"`
+	MaxAmount *Money `json:"maxAmount,omitempty" bson:"maxAmount,omitempty"`
+}
+
+// RuleStore keeps the categorisation rules.
+type RuleStore interface {
+	// CreateRule stores r and assigns it a new ID.
+	CreateRule(ctx context.Context, r *Rule) error
+	// ListRules returns every rule in the order they are applied.
+	ListRules(ctx context.Context) ([]Rule, error)
+	// GetRule returns the rule with the given ID or ErrNotFound.
+	GetRule(ctx context.Context, id primitive.ObjectID) (Rule, error)
+	// UpdateRule replaces the rule that has r.ID, or returns ErrNotFound.
+	UpdateRule(ctx context.Context, r Rule) error
+	// DeleteRule removes the rule with the given ID or returns ErrNotFound.
+	DeleteRule(ctx context.Context, id primitive.ObjectID) error
+}
+
+// sortRules orders rules by priority, then ID, which is the order in which
+// they are applied.
+func sortRules(rules []Rule) {
+	sort.Slice(rules, func(i, j int) bool {
+		a, b := rules[i], rules[j]
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		return bytes.Compare(a.ID[:], b.ID[:]) < 0
+	})
+}
+
+// validateMetadata checks metadata supplied on a transaction or rule.
+func validateMetadata(errs *validationError, metadata map[string]string) {
+	if len(metadata) > maxMetadataKeys {
+		errs.add("metadata", "must have at most %d entries", maxMetadataKeys)
+		return
+	}
+	for k, v := range metadata {
+		if n := utf8.RuneCountInString(k); n == 0 || n > maxMetadataKey || strings.ContainsAny(k, ".$") {
+			errs.add("metadata", "key %q must be 1-%d characters without '.' or '$'", k, maxMetadataKey)
+		}
+		if utf8.RuneCountInString(v) > maxMetadataValue {
+			errs.add("metadata", "value of %q must be at most %d characters", k, maxMetadataValue)
+		}
+	}
+}
+
+// validate checks r and fills in the type implied by its category.
+func (r *Rule) validate(ctx context.Context, categories CategoryStore) error {
+	var errs validationError
+
+	r.Name = strings.TrimSpace(r.Name)
+	switch n := utf8.RuneCountInString(r.Name); {
+	case n == 0:
+		errs.add("name", "is required")
+	case n > maxRuleNameLength:
+		errs.add("name", "must be at most %d characters", maxRuleNameLength)
+	}
+
+	m := r.Match
+	if m.Contains == "" && m.Pattern == "" && m.Type == "" && m.MinAmount == nil && m.MaxAmount == nil {
+		errs.add("match", "must have at least one condition")
+	}
+	if m.Pattern != "" {
+		if _, err := regexp.Compile(m.Pattern); err != nil {
+			errs.add("match.pattern", "is not a valid regular expression: %v", err)
+		}
+	}
+	if m.Type != "" && !validTransactionType(m.Type) {
+		errs.add("match.type", "must be %q or %q", TypeIncome, TypeExpense)
+	}
+	if m.MinAmount != nil && m.MaxAmount != nil && m.MinAmount.Cmp(*m.MaxAmount) > 0 {
+		errs.add("match.minAmount", "must not be greater than maxAmount")
+	}
+
+	if r.CategoryID == nil && len(r.Metadata) == 0 {
+		errs.add("categoryId", "or metadata is required")
+	}
+	validateMetadata(&errs, r.Metadata)
+
+	if r.CategoryID != nil {
+		c, err := categories.GetCategory(ctx, *r.CategoryID)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			errs.add("categoryId", "does not exist")
+		case err != nil:
+			return err
+		case m.Type == "":
+			// A category only fits transactions of its kind.
+			r.Match.Type = c.Kind
+		case m.Type != c.Kind:
+			errs.add("categoryId", "is a category for %s transactions", c.Kind)
+		}
+	}
+	return errs.err()
+}
+
+// compiledRule is a Rule with its pattern compiled.
+type compiledRule struct {
+	Rule
+	pattern *regexp.Regexp
+}
+
+// ruleEngine applies an ordered set of rules to transactions.
+type ruleEngine struct {
+	rules []compiledRule
+}
+
+// loadRuleEngine reads the rules from store.
+func loadRuleEngine(ctx context.Context, store RuleStore) (*ruleEngine, error) {
+	rules, err := store.ListRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &ruleEngine{rules: make([]compiledRule, 0, len(rules))}
+	for _, r := range rules {
+		c := compiledRule{Rule: r}
+		if r.Match.Pattern != "" {
+			if c.pattern, err = regexp.Compile(r.Match.Pattern); err != nil {
+				return nil, fmt.Errorf("rule %s: %v", r.ID.Hex(), err)
+			}
+		}
+		e.rules = append(e.rules, c)
+	}
+	return e, nil
+}
+
+func (r compiledRule) matches(t Transaction) bool {
+	m := r.Match
+	if m.Type != "" && t.Type != m.Type {
+		return false
+	}
+	if m.MinAmount != nil && t.Amount.Cmp(*m.MinAmount) < 0 {
+		return false
+	}
+	if m.MaxAmount != nil && t.Amount.Cmp(*m.MaxAmount) > 0 {
+		return false
+	}
+	if m.Contains != "" && !strings.Contains(strings.ToLower(t.Description), strings.ToLower(m.Contains)) {
+		return false
+	}
+	if r.pattern != nil && !r.pattern.MatchString(t.Description) {
+		return false
+	}
+	return true
+}
+
+// apply stamps the first matching rule onto t. A category chosen by hand is
+// kept; otherwise the rule's category is used and the rule is recorded in
+// t.RuleID, so that re-applying can replace it, or clear it if no rule
+// matches any more. Metadata from the rule overwrites keys of the same name.
+func (e *ruleEngine) apply(t *Transaction) {
+	if t.Type == TypeTransfer {
+		return
+	}
+	if t.RuleID != nil {
+		t.RuleID = nil
+		t.CategoryID = nil
+	}
+	for _, r := range e.rules {
+		if !r.matches(*t) {
+			continue
+		}
+		if t.CategoryID == nil && r.CategoryID != nil {
+			categoryID, ruleID := *r.CategoryID, r.ID
+			t.CategoryID, t.RuleID = &categoryID, &ruleID
+		}
+		if len(r.Metadata) > 0 {
+			metadata := make(map[string]string, len(t.Metadata)+len(r.Metadata))
+			for k, v := range t.Metadata {
+				metadata[k] = v
+			}
+			for k, v := range r.Metadata {
+				metadata[k] = v
+			}
+			t.Metadata = metadata
+		}
+		return
+	}
+}
+
+// ruleOutcome is the part of a transaction that rules change.
+type ruleOutcome struct {
+	RuleID     *primitive.ObjectID `json:"ruleId,omitempty"`
+	CategoryID *primitive.ObjectID `json:"categoryId,omitempty"`
+	Metadata   map[string]string   `json:"metadata,omitempty"`
+}
+
+func outcomeOf(t Transaction) ruleOutcome {
+	return ruleOutcome{RuleID: t.RuleID, CategoryID: t.CategoryID, Metadata: t.Metadata}
+}
+
+func (o ruleOutcome) equal(other ruleOutcome) bool {
+	if !equalParent(o.RuleID, other.RuleID) || !equalParent(o.CategoryID, other.CategoryID) {
+		return false
+	}
+	if len(o.Metadata) != len(other.Metadata) {
+		return false
+	}
+	for k, v := range o.Metadata {
+		if w, ok := other.Metadata[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ruleChange describes how applying the rules changes one transaction.
+type ruleChange struct {
+	TransactionID primitive.ObjectID `json:"transactionId"`
+	Description   string             `json:"description"`
+	Before        ruleOutcome        `json:"before"`
+	After         ruleOutcome        `json:"after"`
+}
+
+// ruleApplyResult is the response body of POST /rules/apply.
+type ruleApplyResult struct {
+	DryRun   bool         `json:"dryRun"`
+	Examined int          `json:"examined"`
+	Changed  int          `json:"changed"`
+	Changes  []ruleChange `json:"changes"`
+	// Error is set when a write failed partway. Changes then lists those
+	// saved before it; applying the rules again finishes the job.
+	Error *apiError `json:"error,omitempty"`
+}
+
+func writeRule(w http.ResponseWriter, status int, r Rule) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(r)
+}
+
+// getRules handles GET /rules. Rules are listed in the order they are
+// applied.
+func (s *server) getRules(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rules, err := s.store.ListRules(ctx)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// createRule handles POST /rules.
+func (s *server) createRule(w http.ResponseWriter, r *http.Request) {
+	var rule Rule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	rule.ID = primitive.NilObjectID
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := rule.validate(ctx, s.store); err != nil {
+		writeCheckError(w, err)
+		return
+	}
+
+	if err := s.store.CreateRule(ctx, &rule); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	s.audit(ctx, r, OpCreate, ResourceRule, rule.ID.Hex(), nil, rule)
+
+	writeRule(w, http.StatusCreated, rule)
+}
+
+// getRule handles GET /rules/{id}.
+func (s *server) getRule(w http.ResponseWriter, r *http.Request) {
+	id, err := objectIDFromPath(r, "/rules/")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rule, err := s.store.GetRule(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "rule not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	writeRule(w, http.StatusOK, rule)
+}
+
+// replaceRule handles PUT /rules/{id}. Transactions categorised earlier keep
+// their category until the rules are re-applied.
+func (s *server) replaceRule(w http.ResponseWriter, r *http.Request) {
+	id, err := objectIDFromPath(r, "/rules/")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	var rule Rule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	rule.ID = id
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := rule.validate(ctx, s.store); err != nil {
+		writeCheckError(w, err)
+		return
+	}
+
+	current, err := s.store.GetRule(ctx, id)
+	if err == nil {
+		err = s.store.UpdateRule(ctx, rule)
+	}
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "rule not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	s.audit(ctx, r, OpUpdate, ResourceRule, id.Hex(), current, rule)
+
+	writeRule(w, http.StatusOK, rule)
+}
+
+// deleteRule handles DELETE /rules/{id}.
+func (s *server) deleteRule(w http.ResponseWriter, r *http.Request) {
+	id, err := objectIDFromPath(r, "/rules/")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	current, err := s.store.GetRule(ctx, id)
+	if err == nil {
+		err = s.store.DeleteRule(ctx, id)
+	}
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "rule not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	s.audit(ctx, r, OpDelete, ResourceRule, id.Hex(), current, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyRules handles POST /rules/apply. It re-applies the rules to every
+// transaction matching the list filters and reports what changed. With
+// ?dryRun=true nothing is written.
+func (s *server) applyRules(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter, err := parseTransactionFilter(q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	dryRun := false
+	if v := q.Get("dryRun"); v != "" {
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "dryRun must be true or false")
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+
+	if err := s.expandCategoryFilter(ctx, &filter); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	engine, err := loadRuleEngine(ctx, s.store)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	transactions, err := s.store.List(ctx, ListOptions{Filter: filter})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	result := ruleApplyResult{DryRun: dryRun, Examined: len(transactions), Changes: []ruleChange{}}
+	for _, t := range transactions {
+		original := t
+		before := outcomeOf(t)
+		engine.apply(&t)
+		after := outcomeOf(t)
+		if before.equal(after) {
+			continue
+		}
+
+		if !dryRun {
+			err := s.store.Update(ctx, &t)
+			if errors.Is(err, ErrVersionMismatch) || errors.Is(err, ErrNotFound) {
+				// Changed or deleted since it was listed; leave it to the
+				// next run.
+				continue
+			}
+			if err != nil {
+				// Only logged: the unit of work still keeps the changes
+				// saved so far, as the result reports.
+				e := internalError(w, err)
+				result.Error = &e
+				break
+			}
+			s.audit(ctx, r, OpUpdate, ResourceTransaction, t.ID.Hex(), original, t)
+		}
+		result.Changes = append(result.Changes, ruleChange{
+			TransactionID: t.ID,
+			Description:   t.Description,
+			Before:        before,
+			After:         after,
+		})
+	}
+	result.Changed = len(result.Changes)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// flakyUpdates fails updates of the transactions with the given
+// descriptions.
+type flakyUpdates struct {
+	Store
+	fail map[string]error
+}
+
+func (s flakyUpdates) Update(ctx context.Context, t *Transaction) error {
+	if err := s.fail[t.Description]; err != nil {
+		return err
+	}
+	return s.Store.Update(ctx, t)
+}
+
+// flakyFileUpdates is flakyUpdates over a file store, keeping its unit of
+// work.
+type flakyFileUpdates struct {
+	*fileStore
+	fail map[string]error
+}
+
+func (s flakyFileUpdates) Update(ctx context.Context, t *Transaction) error {
+	if err := s.fail[t.Description]; err != nil {
+		return err
+	}
+	return s.fileStore.Update(ctx, t)
+}
+
+func TestApplyRulesReportsPartialResult(t *testing.T) {
+	s := newTestServer()
+	ctx := context.Background()
+	food := Category{Name: "Food", Kind: TypeExpense}
+	if err := s.store.CreateCategory(ctx, &food); err != nil {
+		t.Fatal(err)
+	}
+	rule := Rule{Name: "Meals", Match: RuleMatch{Type: TypeExpense}, CategoryID: &food.ID}
+	if err := s.store.CreateRule(ctx, &rule); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{"Lunch", "Gone", "Broken", "Dinner"} {
+		tx := Transaction{Description: d, Amount: mustMoney(t, "100"), Currency: "INR", Type: TypeExpense}
+		if err := s.store.Create(ctx, &tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.store = flakyUpdates{s.store, map[string]error{"Gone": ErrNotFound, "Broken": errors.New("disk full")}}
+
+	w := do(s.routes().ServeHTTP, http.MethodPost, "/rules/apply", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("apply: got %d %s", w.Code, w.Body)
+	}
+	var result ruleApplyResult
+	decode(t, w, &result)
+	if result.Error == nil || result.Error.Code != CodeInternal {
+		t.Fatalf("error = %+v, want an internal error", result.Error)
+	}
+	for _, c := range result.Changes {
+		if c.Description == "Gone" || c.Description == "Broken" {
+			t.Errorf("reported %s as changed", c.Description)
+			continue
+		}
+		saved, err := s.store.Get(ctx, c.TransactionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.CategoryID == nil || *saved.CategoryID != food.ID {
+			t.Errorf("reported %s as changed, but it was not saved", c.Description)
+		}
+	}
+	if result.Changed != len(result.Changes) {
+		t.Errorf("changed = %d, want %d", result.Changed, len(result.Changes))
+	}
+}
+
+func TestFileApplyRulesKeepsPartialResult(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	fs := mustReopen(t, path)
+	ctx := context.Background()
+	food := Category{Name: "Food", Kind: TypeExpense}
+	if err := fs.CreateCategory(ctx, &food); err != nil {
+		t.Fatal(err)
+	}
+	rule := Rule{Name: "Meals", Match: RuleMatch{Type: TypeExpense}, CategoryID: &food.ID}
+	if err := fs.CreateRule(ctx, &rule); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{"Lunch", "Broken", "Dinner", "Snack"} {
+		tx := Transaction{Description: d, Amount: mustMoney(t, "100"), Currency: "INR", Type: TypeExpense}
+		if err := fs.Create(ctx, &tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newTestServer()
+	s.store = flakyFileUpdates{fs, map[string]error{"Broken": errors.New("disk full")}}
+
+	w := do(s.routes().ServeHTTP, http.MethodPost, "/rules/apply", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("apply: got %d %s", w.Code, w.Body)
+	}
+	var result ruleApplyResult
+	decode(t, w, &result)
+	if result.Error == nil || len(result.Changes) == 0 {
+		t.Fatalf("result = %+v, want changes saved before an error", result)
+	}
+
+	reopened := mustReopen(t, path)
+	for _, c := range result.Changes {
+		saved, err := reopened.Get(ctx, c.TransactionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.CategoryID == nil || *saved.CategoryID != food.ID {
+			t.Errorf("reported %s as changed, but it was not saved", c.Description)
+		}
+	}
+	entries, err := reopened.ListAudit(ctx, AuditFilter{Resource: ResourceTransaction}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(result.Changes) {
+		t.Errorf("kept %d audit entries for %d changes", len(entries), len(result.Changes))
+	}
+}
+
+// createTestRule creates a rule through the API.
+func createTestRule(t *testing.T, routes http.Handler, body string) {
+	t.Helper()
+	if w := do(routes.ServeHTTP, http.MethodPost, "/rules", body, nil); w.Code != http.StatusCreated {
+		t.Fatalf("create rule %s: got %d %s", body, w.Code, w.Body)
+	}
+}
+
+func TestRulesCategoriseNewTransactions(t *testing.T) {
+	routes := newTestServer().routes()
+	names := map[primitive.ObjectID]string{}
+	category := func(name, kind string) string {
+		id := createTestCategory(t, routes, `{"name":"`+name+`","kind":"`+kind+`"}`)
+		names[id] = name
+		return id.Hex()
+	}
+	food, transport, big, salary := category("Food", "expense"), category("Transport", "expense"), category("Big", "expense"), category("Salary", "income")
+
+	createTestRule(t, routes, `{"name":"Big","priority":30,"match":{"minAmount":"10000","maxAmount":"50000"},"categoryId":"`+big+`"}`)
+	createTestRule(t, routes, `{"name":"Swiggy","priority":10,"match":{"contains":"swiggy"},"categoryId":"`+food+`"}`)
+	createTestRule(t, routes, `{"name":"Cabs","priority":20,"match":{"pattern":"^(Uber|Ola)\\b"},"categoryId":"`+transport+`"}`)
+	createTestRule(t, routes, `{"name":"Uber Eats","priority":5,"match":{"contains":"uber eats"},"categoryId":"`+food+`"}`)
+	createTestRule(t, routes, `{"name":"Pay","priority":40,"match":{"type":"income"},"categoryId":"`+salary+`"}`)
+
+	tests := []struct {
+		description, amount, typ string
+		// categoryID is set by hand when not empty.
+		categoryID string
+		want       string
+	}{
+		{"SWIGGY order", "300", "expense", "", "Food"},
+		{"Uber ride", "250", "expense", "", "Transport"},
+		{"Ubered", "250", "expense", "", ""},
+		{"Laptop", "10000", "expense", "", "Big"},
+		{"Sofa", "50000", "expense", "", "Big"},
+		{"TV", "50000.01", "expense", "", ""},
+		{"Bonus", "5000", "income", "", "Salary"},
+		// The first matching rule by priority wins.
+		{"Swiggy party", "20000", "expense", "", "Food"},
+		{"Uber Eats dinner", "400", "expense", "", "Food"},
+		// Income never gets an expense category.
+		{"Swiggy refund", "300", "income", "", "Salary"},
+		// A category chosen by hand is kept.
+		{"Swiggy", "300", "expense", transport, "Transport"},
+	}
+	for _, tt := range tests {
+		body := `{"description":"` + tt.description + `","amount":"` + tt.amount + `","type":"` + tt.typ + `","dateTime":"2025-03-10T09:00:00Z"`
+		if tt.categoryID != "" {
+			body += `,"categoryId":"` + tt.categoryID + `"`
+		}
+		w := do(routes.ServeHTTP, http.MethodPost, "/transactions", body+"}", nil)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create %s: got %d %s", tt.description, w.Code, w.Body)
+		}
+		var got Transaction
+		decode(t, w, &got)
+		name := ""
+		if got.CategoryID != nil {
+			name = names[*got.CategoryID]
+		}
+		if name != tt.want {
+			t.Errorf("%s: category = %q, want %q", tt.description, name, tt.want)
+		}
+		if byHand := tt.categoryID != ""; byHand != (got.RuleID == nil && got.CategoryID != nil) {
+			t.Errorf("%s: rule = %v, want it recorded only for a category a rule chose", tt.description, got.RuleID)
+		}
+	}
+}
+
+func TestApplyRules(t *testing.T) {
+	s := newTestServer()
+	routes := s.routes()
+	ctx := context.Background()
+	food := createTestCategory(t, routes, `{"name":"Food","kind":"expense"}`)
+	transport := createTestCategory(t, routes, `{"name":"Transport","kind":"expense"}`)
+
+	var ids []primitive.ObjectID
+	for _, body := range []string{
+		`{"description":"Swiggy","amount":"300","type":"expense","dateTime":"2025-03-10T09:00:00Z"}`,
+		`{"description":"Swiggy Genie","amount":"150","type":"expense","dateTime":"2025-03-11T09:00:00Z","categoryId":"` + transport.Hex() + `"}`,
+		`{"description":"Rent","amount":"4500","type":"expense","dateTime":"2025-03-01T09:00:00Z"}`,
+	} {
+		w := do(routes.ServeHTTP, http.MethodPost, "/transactions", body, nil)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create: got %d %s", w.Code, w.Body)
+		}
+		var tx Transaction
+		decode(t, w, &tx)
+		ids = append(ids, tx.ID)
+	}
+	createTestRule(t, routes, `{"name":"Swiggy","match":{"contains":"swiggy"},"categoryId":"`+food.Hex()+`"}`)
+	audited := func() int {
+		entries, err := s.store.ListAudit(ctx, AuditFilter{Resource: ResourceTransaction, Operation: OpUpdate}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries)
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		w := do(routes.ServeHTTP, http.MethodPost, "/rules/apply?dryRun="+strconv.FormatBool(dryRun), "", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("apply (dry run %t): got %d %s", dryRun, w.Code, w.Body)
+		}
+		var result ruleApplyResult
+		decode(t, w, &result)
+		// Only Swiggy changes: Swiggy Genie has a category chosen by hand.
+		if result.DryRun != dryRun || result.Examined != 3 || result.Changed != 1 || len(result.Changes) != 1 ||
+			result.Changes[0].TransactionID != ids[0] || result.Changes[0].After.CategoryID == nil || *result.Changes[0].After.CategoryID != food {
+			t.Errorf("apply (dry run %t) = %+v, want Swiggy moved to Food", dryRun, result)
+		}
+
+		saved, err := s.store.Get(ctx, ids[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored := saved.CategoryID != nil; stored == dryRun || (saved.Version == 1) != dryRun {
+			t.Errorf("dry run %t: stored category %v at version %d", dryRun, saved.CategoryID, saved.Version)
+		}
+		want := 0
+		if !dryRun {
+			want = 1
+		}
+		if n := audited(); n != want {
+			t.Errorf("dry run %t: %d update audit entries, want %d", dryRun, n, want)
+		}
+	}
+
+	genie, err := s.store.Get(ctx, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if genie.CategoryID == nil || *genie.CategoryID != transport || genie.Version != 1 {
+		t.Errorf("category chosen by hand = %v at version %d, want %s unchanged", genie.CategoryID, genie.Version, transport.Hex())
+	}
+}
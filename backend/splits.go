@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxSplits           = 50
+	maxSplitLabelLength = 50
+	maxSplitNoteLength  = 200
+)
+
+// Split is one line of a transaction that covers several things, such as
+// the groceries and the household items on one supermarket bill. The
+// amounts of all lines add up to the amount of the transaction.
+type Split struct {
+	Amount Money  `json:"amount" bson:"amount"`
+	Label  string `json:"label" bson:"label"`
+	Note   string `json:"note,omitempty" bson:"note,omitempty"`
+}
+
+// normalizeSplits validates the split lines of t, recording problems in errs,
+// and returns them trimmed and rescaled to the currency of t. A transaction
+// is either not split or split into at least two lines.
+func normalizeSplits(errs *validationError, splits []Split, t Transaction) []Split {
+	if len(splits) == 0 {
+		return nil
+	}
+	if t.Type == TypeTransfer {
+		errs.add("splits", "are not allowed on transfers")
+		return nil
+	}
+	if len(splits) < 2 || len(splits) > maxSplits {
+		errs.add("splits", "must have between 2 and %d lines", maxSplits)
+		return nil
+	}
+
+	scale := currencyScale(t.Currency)
+	seen := make(map[string]bool, len(splits))
+	out := make([]Split, len(splits))
+	sum := Money{}
+	ok := true
+	for i, s := range splits {
+		field := fmt.Sprintf("splits[%d]", i)
+		s.Label = strings.TrimSpace(s.Label)
+		s.Note = strings.TrimSpace(s.Note)
+
+		switch n := utf8.RuneCountInString(s.Label); {
+		case n == 0:
+			errs.add(field+".label", "is required")
+		case n > maxSplitLabelLength:
+			errs.add(field+".label", "must be at most %d characters", maxSplitLabelLength)
+		case seen[s.Label]:
+			errs.add(field+".label", "is used by another line")
+		}
+		seen[s.Label] = true
+		if utf8.RuneCountInString(s.Note) > maxSplitNoteLength {
+			errs.add(field+".note", "must be at most %d characters", maxSplitNoteLength)
+		}
+
+		amount, scaled := s.Amount.Rescale(scale)
+		switch {
+		case s.Amount.Sign() <= 0:
+			errs.add(field+".amount", "must be greater than zero")
+			ok = false
+		case s.Amount.Cmp(maxAmount) >= 0:
+			errs.add(field+".amount", "must be less than %s", maxAmount)
+			ok = false
+		case !scaled:
+			errs.add(field+".amount", "must have at most %d decimal places for %s", scale, t.Currency)
+			ok = false
+		default:
+			s.Amount = amount
+			if ok {
+				var err error
+				if sum, err = sum.checkedAdd(amount); err != nil {
+					errs.add("splits", "add up to more than the largest amount")
+					ok = false
+				}
+			}
+		}
+		out[i] = s
+	}
+
+	if ok && t.Amount.Sign() > 0 && sum.Cmp(t.Amount) != 0 {
+		errs.add("splits", "must add up to the amount %s, not %s", t.Amount.atLeastScale(scale), sum.atLeastScale(scale))
+	}
+	return out
+}
+
+// hasSplitLabel reports whether t has a split line with any of labels.
+func hasSplitLabel(t Transaction, labels []string) bool {
+	for _, s := range t.Splits {
+		if containsString(labels, s.Label) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// amountFor returns the part of the amount of t that f covers: the whole
+// amount, or with a label filter only the matching split lines.
+func (f TransactionFilter) amountFor(t Transaction) Money {
+	if len(f.Labels) == 0 {
+		return t.Amount
+	}
+	var sum Money
+	for _, s := range t.Splits {
+		if containsString(f.Labels, s.Label) {
+			var err error
+			if sum, err = sum.checkedAdd(s.Amount); err != nil {
+				// The lines of a stored transaction add up to its amount,
+				// so only corrupt data gets here; count the whole amount.
+				return t.Amount
+			}
+		}
+	}
+	return sum
+}
+
+// labelTotals groups transactions into daily totals per split label, for
+// stores that have no query engine to do it for them. A transaction without
+// splits counts under the empty label.
+func labelTotals(transactions []Transaction, f TransactionFilter) ([]DailyTotal, error) {
+	type key struct {
+		day                  time.Time
+		typ, currency, label string
+	}
+	index := make(map[key]int)
+	var totals []DailyTotal
+	add := func(t Transaction, label string, amount Money) error {
+		if len(f.Labels) > 0 && !containsString(f.Labels, label) {
+			return nil
+		}
+		k := key{granularityDay.truncate(t.DateTime), t.Type, t.Currency, label}
+		i, ok := index[k]
+		if !ok {
+			i = len(totals)
+			index[k] = i
+			totals = append(totals, DailyTotal{Day: k.day, Type: k.typ, Currency: k.currency, Label: label})
+		}
+		total, err := totals[i].Total.checkedAdd(amount)
+		if err != nil {
+			return fmt.Errorf("%s total of %q on %s: %w", t.Type, label, k.day.Format("2006-01-02"), err)
+		}
+		totals[i].Total = total
+		totals[i].Count++
+		return nil
+	}
+	for _, t := range transactions {
+		if len(t.Splits) == 0 {
+			if err := add(t, "", t.Amount); err != nil {
+				return nil, err
+			}
+		}
+		for _, s := range t.Splits {
+			if err := add(t, s.Label, s.Amount); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.SliceStable(totals, func(i, j int) bool { return totals[i].Day.Before(totals[j].Day) })
+	return totals, nil
+}
+
+// LabelTotals is the part of the matching transactions attributed to one
+// split label, in the base currency.
+type LabelTotals struct {
+	// Label is empty for transactions that are not split.
+	Label   string `json:"label"`
+	Income  Money  `json:"income"`
+	Expense Money  `json:"expense"`
+	Net     Money  `json:"net"`
+	// Count is the number of split lines, or of unsplit transactions.
+	Count int `json:"count"`
+}
+
+// getLabelReport handles GET /reports/labels. It accepts the filters of GET
+// /transactions and attributes every split line to its own label, so a
+// transaction split into groceries and household items counts towards both
+// with the respective amounts. Labels are ordered by expense, then income.
+func (s *server) getLabelReport(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseTransactionFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := s.expandCategoryFilter(ctx, &filter); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	totals, err := s.store.LabelTotals(ctx, filter)
+	if err != nil {
+		writeAggregateError(w, err)
+		return
+	}
+
+	base := s.rules.DefaultCurrency
+	conv := newConverter(s.store, base)
+	index := make(map[string]int)
+	labels := []LabelTotals{}
+	for _, dt := range totals {
+		if dt.Type == TypeTransfer {
+			continue
+		}
+		amount, err := conv.convert(ctx, dt.Total, dt.Currency, dt.Day)
+		if err != nil {
+			writeAggregateError(w, err)
+			return
+		}
+		i, ok := index[dt.Label]
+		if !ok {
+			i = len(labels)
+			index[dt.Label] = i
+			labels = append(labels, LabelTotals{Label: dt.Label})
+		}
+		l := &labels[i]
+		if err := addByType(dt.Type, amount, &l.Income, &l.Expense, &l.Net); err != nil {
+			writeAggregateError(w, fmt.Errorf("totals of %q: %w", dt.Label, err))
+			return
+		}
+		l.Count += dt.Count
+	}
+
+	scale := currencyScale(base)
+	for i := range labels {
+		l := &labels[i]
+		l.Income = l.Income.atLeastScale(scale)
+		l.Expense = l.Expense.atLeastScale(scale)
+		l.Net = l.Net.atLeastScale(scale)
+	}
+	sort.SliceStable(labels, func(i, j int) bool {
+		a, b := labels[i], labels[j]
+		if c := a.Expense.Cmp(b.Expense); c != 0 {
+			return c > 0
+		}
+		if c := a.Income.Cmp(b.Income); c != 0 {
+			return c > 0
+		}
+		return a.Label < b.Label
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Currency string        `json:"currency"`
+		Labels   []LabelTotals `json:"labels"`
+	}{base, labels})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// mustMoney parses s, failing the test on error.
+func mustMoney(t *testing.T, s string) Money {
+	t.Helper()
+	m, err := ParseMoney(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestNormalizeSplitsRejectsHugeLines(t *testing.T) {
+	tx := Transaction{Type: TypeExpense, Currency: "JPY", Amount: mustMoney(t, "1000")}
+	splits := []Split{
+		{Amount: mustMoney(t, "5000000000000000000"), Label: "a"},
+		{Amount: mustMoney(t, "5000000000000000000"), Label: "b"},
+	}
+
+	var errs validationError
+	normalizeSplits(&errs, splits, tx)
+	want := []fieldError{
+		{Field: "splits[0].amount", Message: "must be less than " + maxAmount.String()},
+		{Field: "splits[1].amount", Message: "must be less than " + maxAmount.String()},
+	}
+	if len(errs.Fields) != len(want) {
+		t.Fatalf("errors = %v, want %v", errs.Fields, want)
+	}
+	for i := range want {
+		if errs.Fields[i] != want[i] {
+			t.Errorf("error %d = %v, want %v", i, errs.Fields[i], want[i])
+		}
+	}
+}
+
+func TestAmountForSurvivesOverflow(t *testing.T) {
+	huge := mustMoney(t, "5000000000000000000")
+	tx := Transaction{Amount: mustMoney(t, "10"), Splits: []Split{{Amount: huge, Label: "a"}, {Amount: huge, Label: "b"}}}
+	f := TransactionFilter{Labels: []string{"a", "b"}}
+	if got := f.amountFor(tx); got.Cmp(tx.Amount) != 0 {
+		t.Errorf("amountFor = %s, want the whole amount %s", got, tx.Amount)
+	}
+}
+
+func TestSplitsValidation(t *testing.T) {
+	routes := newTestServer().routes()
+	const prefix = `{"description":"Groceries","amount":"100","type":"expense","dateTime":"2025-03-29T10:17:00Z","splits":`
+	tests := []struct {
+		splits string
+		field  string
+	}{
+		{`[{"amount":"60","label":"food"},{"amount":"30","label":"home"}]`, "splits"},
+		{`[{"amount":"100","label":"food"}]`, "splits"},
+		{`[{"amount":"60","label":"food"},{"amount":"40","label":"food"}]`, "splits[1].label"},
+		{`[{"amount":"60","label":"food"},{"amount":"40.001","label":"home"}]`, "splits[1].amount"},
+		{`[{"amount":"110","label":"food"},{"amount":"-10","label":"home"}]`, "splits[1].amount"},
+	}
+	for _, tt := range tests {
+		w := do(routes.ServeHTTP, http.MethodPost, "/transactions", prefix+tt.splits+"}", nil)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d %s, want 400", tt.splits, w.Code, w.Body)
+			continue
+		}
+		var body errorBody
+		decode(t, w, &body)
+		if len(body.Error.Fields) == 0 || body.Error.Fields[0].Field != tt.field {
+			t.Errorf("%s: fields = %v, want an error on %s", tt.splits, body.Error.Fields, tt.field)
+		}
+	}
+
+	w := do(routes.ServeHTTP, http.MethodPost, "/transactions", prefix+`[{"amount":"60.5","label":" food "},{"amount":"39.5","label":"home"}]}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("valid splits: got %d %s", w.Code, w.Body)
+	}
+	var created Transaction
+	decode(t, w, &created)
+	if created.Splits[0].Label != "food" || created.Splits[0].Amount.String() != "60.50" {
+		t.Errorf("stored split %+v, want it trimmed and rescaled to 60.50", created.Splits[0])
+	}
+}
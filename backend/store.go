@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrNotFound is returned by a store when no record has the requested key.
+var ErrNotFound = errors.New("not found")
+
+// ErrVersionMismatch is returned by the stores when the stored transaction
+// has changed since it was read.
+var ErrVersionMismatch = errors.New("transaction version has changed")
+
+// anyVersion, passed as the expected version of a transaction, skips the
+// version check.
+const anyVersion int64 = -1
+
+// ErrDuplicateID is returned by TransactionStore.Create when a transaction
+// with the same ID already exists.
+var ErrDuplicateID = errors.New("transaction ID already exists")
+
+// ListOptions narrows down the result of TransactionStore.List.
+type ListOptions struct {
+	Filter TransactionFilter
+	// Limit caps the number of transactions returned. Zero means no limit.
+	Limit int
+	// After, when set, skips every transaction up to and including the
+	// position it marks.
+	After *pageCursor
+}
+
+// TransactionStore is the persistence layer behind the HTTP handlers. Every
+// implementation must be safe for concurrent use.
+type TransactionStore interface {
+	// Create stores t at version 1 and assigns it a new ID if it does not
+	// have one yet. It returns ErrDuplicateID if t already carries an ID that
+	// is taken.
+	Create(ctx context.Context, t *Transaction) error
+	// List returns stored transactions newest first, ordered by dateTime and
+	// then _id, as described by opts.
+	List(ctx context.Context, opts ListOptions) ([]Transaction, error)
+	// Get returns the transaction with the given ID or ErrNotFound.
+	// Transactions in the trash are not found.
+	Get(ctx context.Context, id primitive.ObjectID) (Transaction, error)
+	// Update replaces the stored transaction that has t.ID with t and
+	// increments t.Version. It returns ErrNotFound, or ErrVersionMismatch if
+	// the stored version is no longer t.Version. Transactions in the trash
+	// cannot be updated.
+	Update(ctx context.Context, t *Transaction) error
+	// Trash moves the transaction with the given ID and version to the
+	// trash, marking it deleted at at. It returns ErrNotFound or
+	// ErrVersionMismatch.
+	Trash(ctx context.Context, id primitive.ObjectID, version int64, at time.Time) error
+	// Restore moves the transaction with the given ID and version out of the
+	// trash and returns it. It returns ErrNotFound if it is not in the trash,
+	// or ErrVersionMismatch. Restoring one leg of a transfer restores the
+	// other leg deleted with it.
+	//
+	// Every change, including Trash, Restore and the bulk changes of other
+	// stores such as RenameTag, increments the version of the transactions
+	// it touches.
+	Restore(ctx context.Context, id primitive.ObjectID, version int64) (Transaction, error)
+	// PurgeTrash permanently removes the transactions moved to the trash
+	// before the given time and returns how many there were.
+	PurgeTrash(ctx context.Context, before time.Time) (int, error)
+	// DailyTotals groups the transactions matching f by UTC day, type and
+	// currency, ordered by day.
+	DailyTotals(ctx context.Context, f TransactionFilter) ([]DailyTotal, error)
+	// LabelTotals is like DailyTotals but also groups by split label, with
+	// unsplit transactions under the empty label.
+	LabelTotals(ctx context.Context, f TransactionFilter) ([]DailyTotal, error)
+	// Close releases any resources held by the store.
+	Close(ctx context.Context) error
+}
+
+// Store combines every persistence interface; each backend implements all of
+// them.
+type Store interface {
+	TransactionStore
+	RateStore
+	CategoryStore
+	RuleStore
+	TagStore
+	BudgetStore
+	RecurringStore
+	AccountStore
+	TransferStore
+	AuditStore
+	IdempotencyStore
+}
+
+// openStore builds the Store selected by cfg.StoreBackend.
+func openStore(ctx context.Context, cfg config) (Store, error) {
+	switch cfg.StoreBackend {
+	case "mongo":
+		return newMongoStore(ctx, cfg.MongoURI)
+	case "file":
+		fs, err := newFileStore(cfg.DataFile)
+		if err != nil {
+			return nil, err
+		}
+		fs.auditLimit = cfg.AuditMaxEntries
+		return fs, nil
+	case "memory":
+		ms := newMemoryStore()
+		ms.auditLimit = cfg.AuditMaxEntries
+		return ms, nil
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", cfg.StoreBackend)
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fileStore keeps its data in memory and writes the full data set to a
+// single JSON file after every mutation. Writes go to a temporary file that is
+// fsynced and renamed over the old one, so a crash leaves either the previous
+// or the new version on disk, never a partial one.
+type fileStore struct {
+	*memoryStore
+
+	// mu serialises mutations so that the file is written in the same order
+	// as the changes were applied.
+	mu   sync.Mutex
+	path string
+}
+
+func newFileStore(path string) (*fileStore, error) {
+	s := &fileStore{memoryStore: newMemoryStore(), path: path}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileStore) Create(ctx context.Context, t *Transaction) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.Create(ctx, t)
+	})
+}
+
+func (s *fileStore) Update(ctx context.Context, t *Transaction) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.Update(ctx, t)
+	})
+}
+
+func (s *fileStore) Trash(ctx context.Context, id primitive.ObjectID, version int64, at time.Time) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.Trash(ctx, id, version, at)
+	})
+}
+
+func (s *fileStore) Restore(ctx context.Context, id primitive.ObjectID, version int64) (Transaction, error) {
+	var t Transaction
+	err := s.mutate(ctx, func() error {
+		var err error
+		t, err = s.memoryStore.Restore(ctx, id, version)
+		return err
+	})
+	return t, err
+}
+
+func (s *fileStore) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	purged := 0
+	err := s.mutate(ctx, func() error {
+		var err error
+		purged, err = s.memoryStore.PurgeTrash(ctx, before)
+		return err
+	})
+	return purged, err
+}
+
+// MigrateAmounts rewrites the data file. Amounts written as JSON numbers by
+// older versions are parsed exactly on load, so only the scale and missing
+// currencies need fixing.
+func (s *fileStore) MigrateAmounts(ctx context.Context, currency string) (int, error) {
+	changed := 0
+	err := s.mutate(ctx, func() error {
+		defer s.memoryStore.lock(ctx)()
+
+		for id, t := range s.transactions {
+			if t.Currency == "" {
+				t.Currency = currency
+			} else if t.Amount.Scale() == currencyScale(t.Currency) {
+				continue
+			}
+			amount, err := t.Amount.Round(currencyScale(t.Currency))
+			if err != nil {
+				return fmt.Errorf("transaction %s: amount %s in %s: %v", id.Hex(), t.Amount, t.Currency, err)
+			}
+			t.Amount = amount
+			t.Version++
+			s.transactions[id] = t
+			changed++
+		}
+		return nil
+	})
+	if err != nil {
+		// Nothing was written.
+		return 0, err
+	}
+	return changed, nil
+}
+
+func (s *fileStore) RenameTag(ctx context.Context, from, to string) (int, error) {
+	var changed int
+	err := s.mutate(ctx, func() error {
+		var err error
+		changed, err = s.memoryStore.RenameTag(ctx, from, to)
+		return err
+	})
+	return changed, err
+}
+
+func (s *fileStore) PutRates(ctx context.Context, rates []ExchangeRate) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.PutRates(ctx, rates)
+	})
+}
+
+func (s *fileStore) DeleteRate(ctx context.Context, from, to, date string) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.DeleteRate(ctx, from, to, date)
+	})
+}
+
+func (s *fileStore) CreateCategory(ctx context.Context, c *Category) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.CreateCategory(ctx, c)
+	})
+}
+
+func (s *fileStore) UpdateCategory(ctx context.Context, c Category) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.UpdateCategory(ctx, c)
+	})
+}
+
+func (s *fileStore) DeleteCategory(ctx context.Context, id primitive.ObjectID, reassignTo *primitive.ObjectID) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.DeleteCategory(ctx, id, reassignTo)
+	})
+}
+
+func (s *fileStore) CreateRule(ctx context.Context, r *Rule) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.CreateRule(ctx, r)
+	})
+}
+
+func (s *fileStore) UpdateRule(ctx context.Context, r Rule) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.UpdateRule(ctx, r)
+	})
+}
+
+func (s *fileStore) DeleteRule(ctx context.Context, id primitive.ObjectID) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.DeleteRule(ctx, id)
+	})
+}
+
+func (s *fileStore) CreateBudget(ctx context.Context, b *Budget) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.CreateBudget(ctx, b)
+	})
+}
+
+func (s *fileStore) UpdateBudget(ctx context.Context, b Budget) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.UpdateBudget(ctx, b)
+	})
+}
+
+func (s *fileStore) DeleteBudget(ctx context.Context, id primitive.ObjectID) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.DeleteBudget(ctx, id)
+	})
+}
+
+func (s *fileStore) CreateRecurring(ctx context.Context, r *Recurring) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.CreateRecurring(ctx, r)
+	})
+}
+
+func (s *fileStore) SetRecurringPaused(ctx context.Context, id primitive.ObjectID, paused bool) (Recurring, error) {
+	var r Recurring
+	err := s.mutate(ctx, func() error {
+		var err error
+		r, err = s.memoryStore.SetRecurringPaused(ctx, id, paused)
+		return err
+	})
+	return r, err
+}
+
+func (s *fileStore) SkipRecurring(ctx context.Context, id primitive.ObjectID, date string) (Recurring, error) {
+	var r Recurring
+	err := s.mutate(ctx, func() error {
+		var err error
+		r, err = s.memoryStore.SkipRecurring(ctx, id, date)
+		return err
+	})
+	return r, err
+}
+
+func (s *fileStore) DeleteRecurring(ctx context.Context, id primitive.ObjectID) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.DeleteRecurring(ctx, id)
+	})
+}
+
+func (s *fileStore) SetRecurringThrough(ctx context.Context, id primitive.ObjectID, through time.Time) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.SetRecurringThrough(ctx, id, through)
+	})
+}
+
+func (s *fileStore) CreateAccount(ctx context.Context, a *Account) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.CreateAccount(ctx, a)
+	})
+}
+
+func (s *fileStore) UpdateAccount(ctx context.Context, a Account) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.UpdateAccount(ctx, a)
+	})
+}
+
+func (s *fileStore) DeleteAccount(ctx context.Context, id primitive.ObjectID) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.DeleteAccount(ctx, id)
+	})
+}
+
+func (s *fileStore) AppendAudit(ctx context.Context, e *AuditEntry) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.AppendAudit(ctx, e)
+	})
+}
+
+func (s *fileStore) CreateTransfer(ctx context.Context, legs []Transaction) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.CreateTransfer(ctx, legs)
+	})
+}
+
+func (s *fileStore) DeleteTransfer(ctx context.Context, id primitive.ObjectID, version int64, at time.Time) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.DeleteTransfer(ctx, id, version, at)
+	})
+}
+
+func (s *fileStore) ReserveIdempotencyKey(ctx context.Context, rec IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	var existing IdempotencyRecord
+	var reserved bool
+	err := s.mutate(ctx, func() error {
+		var err error
+		existing, reserved, err = s.memoryStore.ReserveIdempotencyKey(ctx, rec)
+		return err
+	})
+	return existing, reserved, err
+}
+
+func (s *fileStore) CompleteIdempotencyKey(ctx context.Context, key string, status int, header map[string]string, response []byte, expiresAt time.Time) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.CompleteIdempotencyKey(ctx, key, status, header, response, expiresAt)
+	})
+}
+
+func (s *fileStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	return s.mutate(ctx, func() error {
+		return s.memoryStore.ReleaseIdempotencyKey(ctx, key)
+	})
+}
+
+func (s *fileStore) Close(ctx context.Context) error {
+	return nil
+}
+
+// fileUnitKey marks the context of a call made inside Atomically.
+type fileUnitKey struct{}
+
+// Atomically runs fn as a single mutation: the data file is written once,
+// after fn returns, with every change fn made and the audit entries that
+// record them, or not at all if fn fails. Readers wait until the unit is
+// written or rolled back, so they never see changes that are not kept.
+func (s *fileStore) Atomically(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(fileUnitKey{}) == s {
+		return fn(ctx)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.memoryStore.lock(ctx)()
+
+	ctx = context.WithValue(ctx, heldLockKey{}, s.memoryStore)
+	return s.commit(ctx, func() error {
+		return fn(context.WithValue(ctx, fileUnitKey{}, s))
+	})
+}
+
+// mutate applies fn to the in-memory data and persists the result. Inside
+// Atomically, which holds the lock and writes the file once at the end, fn
+// is simply run.
+func (s *fileStore) mutate(ctx context.Context, fn func() error) error {
+	if ctx.Value(fileUnitKey{}) == s {
+		return fn()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commit(ctx, fn)
+}
+
+// commit runs fn and writes the result to the file. If fn or the write
+// fails, or fn panics, the in-memory data is rolled back so it keeps
+// matching the file. The caller holds s.mu.
+func (s *fileStore) commit(ctx context.Context, fn func() error) error {
+	before := s.snapshot(ctx)
+	saved := false
+	defer func() {
+		if !saved {
+			s.restore(ctx, before)
+		}
+	}()
+	if err := fn(); err != nil {
+		return err
+	}
+	if err := s.save(s.snapshot(ctx)); err != nil {
+		return err
+	}
+	saved = true
+	return nil
+}
+
+// load reads the data file into memory. A missing file is treated as an
+// empty store.
+func (s *fileStore) load() error {
+	s.removeStaleTemps()
+
+	raw, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", s.path, err)
+	}
+
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return nil
+	}
+	if raw[0] == '[' {
+		return fmt.Errorf("%s looks like a legacy transactions export; import it instead of using it as a data file", s.path)
+	}
+
+	var data memoryData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", s.path, err)
+	}
+	s.restore(context.Background(), data)
+	return nil
+}
+
+// save atomically replaces the data file with data.
+func (s *fileStore) save(data memoryData) error {
+	raw, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(raw, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %v", tmp.Name(), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s: %v", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %v", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", s.path, err)
+	}
+	return syncDir(dir)
+}
+
+// removeStaleTemps deletes temporary files left behind by a save that was
+// interrupted before its rename.
+func (s *fileStore) removeStaleTemps() {
+	matches, _ := filepath.Glob(s.path + ".tmp-*")
+	for _, m := range matches {
+		os.Remove(m)
+	}
+}
+
+// syncDir fsyncs a directory so that a completed rename survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	// Some platforms do not support fsync on directories. The rename itself
+	// has already happened, so that is not worth failing the write over.
+	d.Sync()
+	return nil
+}
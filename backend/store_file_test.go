@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.json")
+	fs, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	c := Category{Name: "Housing"}
+	if err := fs.CreateCategory(ctx, &c); err != nil {
+		t.Fatal(err)
+	}
+	tx := Transaction{
+		Description: "Rent", Amount: mustMoney(t, "4500.00"), Currency: "INR", Type: TypeExpense,
+		CategoryID: &c.ID, Tags: []string{"home"},
+	}
+	if err := fs.Create(ctx, &tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.PutRates(ctx, []ExchangeRate{{From: "USD", To: "INR", Date: "2025-01-01", Rate: mustMoney(t, "83.10")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := mustReopen(t, path)
+	got, err := reopened.Get(ctx, tx.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Description != "Rent" || got.Amount.String() != "4500.00" || got.Version != 1 ||
+		got.CategoryID == nil || *got.CategoryID != c.ID || strings.Join(got.Tags, ",") != "home" {
+		t.Errorf("reloaded transaction = %+v, want %+v", got, tx)
+	}
+	if _, err := reopened.GetCategory(ctx, c.ID); err != nil {
+		t.Errorf("reloaded category: %v", err)
+	}
+	rates, err := reopened.ListRates(ctx, "USD", "INR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 1 || rates[0].Rate.String() != "83.10" {
+		t.Errorf("reloaded rates = %+v, want USD/INR at 83.10", rates)
+	}
+
+	// Only the data file is left once the temporary files are renamed.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "data.json" {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("directory holds %v, want only data.json", names)
+	}
+}
+
+func TestFileStoreRollsBack(t *testing.T) {
+	ctx := context.Background()
+	rent := func() Transaction {
+		return Transaction{ID: primitive.NewObjectID(), Description: "Rent", Amount: mustMoney(t, "4500"), Currency: "INR", Type: TypeExpense}
+	}
+	tests := []struct {
+		name string
+		// mutate changes the store and fails.
+		mutate func(t *testing.T, fs *fileStore, path string)
+	}{
+		{"mutation fails", func(t *testing.T, fs *fileStore, path string) {
+			err := fs.mutate(ctx, func() error {
+				tx := rent()
+				fs.transactions[tx.ID] = tx
+				return errors.New("later step failed")
+			})
+			if err == nil {
+				t.Fatal("mutate succeeded, want the error of the mutation")
+			}
+		}},
+		{"mutation panics", func(t *testing.T, fs *fileStore, path string) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("mutate did not pass on the panic")
+				}
+			}()
+			fs.mutate(ctx, func() error {
+				tx := rent()
+				fs.transactions[tx.ID] = tx
+				panic("handler bug")
+			})
+		}},
+		{"write fails", func(t *testing.T, fs *fileStore, path string) {
+			// A directory in place of the data file cannot be renamed over.
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Mkdir(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			tx := rent()
+			if err := fs.Create(ctx, &tx); err == nil {
+				t.Fatal("Create succeeded, want the error of the write")
+			}
+			os.Remove(path)
+			if err := fs.save(fs.snapshot(context.Background())); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "data.json")
+			fs, err := newFileStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			kept := rent()
+			if err := fs.Create(ctx, &kept); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.mutate(t, fs, path)
+
+			for _, store := range []*fileStore{fs, mustReopen(t, path)} {
+				list, err := store.List(ctx, ListOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(list) != 1 || list[0].ID != kept.ID {
+					t.Errorf("store holds %d transactions after a failed mutation, want only the one created before", len(list))
+				}
+			}
+		})
+	}
+}
+
+func TestFileStoreRemovesStaleTemps(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.json")
+	stale := path + ".tmp-123456"
+	if err := os.WriteFile(stale, []byte(`{"transactions": [`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(dir, "notes.tmp-1")
+	if err := os.WriteFile(other, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newFileStore(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale temporary file is still there: %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("unrelated file was removed: %v", err)
+	}
+}
+
+func TestFileStoreRejectsLegacyExport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	legacy := `[{"id":"1743243420000","description":"Rent","amount":4500,"type":"expense","dateTime":1743243420000}]`
+	if err := os.WriteFile(path, []byte("\n"+legacy+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := newFileStore(path)
+	if err == nil || !strings.Contains(err.Error(), "legacy") {
+		t.Fatalf("newFileStore = %v, want the file refused as a legacy export", err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(raw)) != legacy {
+		t.Error("the legacy export was changed")
+	}
+}
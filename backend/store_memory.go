@@ -0,0 +1,830 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryStore keeps everything in process memory. Data is lost on restart,
+// which makes it useful for local development and tests.
+type memoryStore struct {
+	mu           sync.RWMutex
+	transactions map[primitive.ObjectID]Transaction
+	rates        map[rateKey]ExchangeRate
+	categories   map[primitive.ObjectID]Category
+	rules        map[primitive.ObjectID]Rule
+	budgets      map[primitive.ObjectID]Budget
+	recurring    map[primitive.ObjectID]Recurring
+	accounts     map[primitive.ObjectID]Account
+	audit        []AuditEntry // oldest first
+	idempotency  map[string]IdempotencyRecord
+	// auditLimit, when positive, is how many of the newest audit entries
+	// are kept. By default the log is never trimmed.
+	auditLimit int
+}
+
+type rateKey struct {
+	from, to, date string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		transactions: make(map[primitive.ObjectID]Transaction),
+		rates:        make(map[rateKey]ExchangeRate),
+		categories:   make(map[primitive.ObjectID]Category),
+		rules:        make(map[primitive.ObjectID]Rule),
+		budgets:      make(map[primitive.ObjectID]Budget),
+		recurring:    make(map[primitive.ObjectID]Recurring),
+		accounts:     make(map[primitive.ObjectID]Account),
+		idempotency:  make(map[string]IdempotencyRecord),
+	}
+}
+
+// heldLockKey marks the context of calls made by the holder of the write
+// lock of the memoryStore it names, so that they do not take it again.
+type heldLockKey struct{}
+
+// lock takes the write lock unless ctx already holds it, and returns the
+// function that releases it.
+func (s *memoryStore) lock(ctx context.Context) (unlock func()) {
+	if ctx.Value(heldLockKey{}) == s {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+// rlock is lock for readers.
+func (s *memoryStore) rlock(ctx context.Context) (unlock func()) {
+	if ctx.Value(heldLockKey{}) == s {
+		return func() {}
+	}
+	s.mu.RLock()
+	return s.mu.RUnlock
+}
+
+func (s *memoryStore) Create(ctx context.Context, t *Transaction) error {
+	defer s.lock(ctx)()
+
+	if t.ID.IsZero() {
+		t.ID = primitive.NewObjectID()
+	} else if _, ok := s.transactions[t.ID]; ok {
+		return ErrDuplicateID
+	}
+	t.Version = 1
+	s.transactions[t.ID] = *t
+	return nil
+}
+
+func (s *memoryStore) List(ctx context.Context, opts ListOptions) ([]Transaction, error) {
+	defer s.rlock(ctx)()
+
+	transactions := make([]Transaction, 0, len(s.transactions))
+	for _, t := range s.transactions {
+		if opts.After != nil && !sortsAfter(t, opts.After) {
+			continue
+		}
+		if !opts.Filter.matches(t) {
+			continue
+		}
+		transactions = append(transactions, t)
+	}
+	sort.Slice(transactions, func(i, j int) bool {
+		return sortsAfter(transactions[j], cursorFor(transactions[i]))
+	})
+
+	if opts.Limit > 0 && len(transactions) > opts.Limit {
+		transactions = transactions[:opts.Limit]
+	}
+	return transactions, nil
+}
+
+// sortsAfter reports whether t comes after the position c in newest-first
+// list order.
+func sortsAfter(t Transaction, c *pageCursor) bool {
+	if !t.DateTime.Equal(c.DateTime) {
+		return t.DateTime.Before(c.DateTime)
+	}
+	return bytes.Compare(t.ID[:], c.ID[:]) < 0
+}
+
+func (s *memoryStore) Get(ctx context.Context, id primitive.ObjectID) (Transaction, error) {
+	defer s.rlock(ctx)()
+
+	t, ok := s.transactions[id]
+	if !ok || t.DeletedAt != nil {
+		return Transaction{}, ErrNotFound
+	}
+	return t, nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, t *Transaction) error {
+	defer s.lock(ctx)()
+
+	current, ok := s.transactions[t.ID]
+	if !ok || current.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if current.Version != t.Version {
+		return ErrVersionMismatch
+	}
+	t.Version++
+	s.transactions[t.ID] = *t
+	return nil
+}
+
+func (s *memoryStore) Trash(ctx context.Context, id primitive.ObjectID, version int64, at time.Time) error {
+	defer s.lock(ctx)()
+
+	t, ok := s.transactions[id]
+	if !ok || t.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if t.Version != version {
+		return ErrVersionMismatch
+	}
+	t.DeletedAt = &at
+	t.Version++
+	s.transactions[id] = t
+	return nil
+}
+
+func (s *memoryStore) Restore(ctx context.Context, id primitive.ObjectID, version int64) (Transaction, error) {
+	defer s.lock(ctx)()
+
+	t, ok := s.transactions[id]
+	if !ok || t.DeletedAt == nil {
+		return Transaction{}, ErrNotFound
+	}
+	if version != anyVersion && t.Version != version {
+		return Transaction{}, ErrVersionMismatch
+	}
+	for _, leg := range s.transactions {
+		if leg.ID != id && sameTransfer(leg, t) && leg.DeletedAt != nil && leg.DeletedAt.Equal(*t.DeletedAt) {
+			leg.DeletedAt = nil
+			leg.Version++
+			s.transactions[leg.ID] = leg
+		}
+	}
+	t.DeletedAt = nil
+	t.Version++
+	s.transactions[id] = t
+	return t, nil
+}
+
+func (s *memoryStore) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	defer s.lock(ctx)()
+
+	purged := 0
+	for id, t := range s.transactions {
+		if t.DeletedAt != nil && t.DeletedAt.Before(before) {
+			delete(s.transactions, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (s *memoryStore) DailyTotals(ctx context.Context, f TransactionFilter) ([]DailyTotal, error) {
+	transactions, err := s.List(ctx, ListOptions{Filter: f})
+	if err != nil {
+		return nil, err
+	}
+	return dailyTotals(transactions, f)
+}
+
+func (s *memoryStore) LabelTotals(ctx context.Context, f TransactionFilter) ([]DailyTotal, error) {
+	transactions, err := s.List(ctx, ListOptions{Filter: f})
+	if err != nil {
+		return nil, err
+	}
+	return labelTotals(transactions, f)
+}
+
+func (s *memoryStore) TagCounts(ctx context.Context, prefix string) ([]TagCount, error) {
+	transactions, err := s.List(ctx, ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return countTags(transactions, prefix), nil
+}
+
+func (s *memoryStore) RenameTag(ctx context.Context, from, to string) (int, error) {
+	defer s.lock(ctx)()
+
+	changed := 0
+	for id, t := range s.transactions {
+		tags, ok := renameTag(t.Tags, from, to)
+		if !ok {
+			continue
+		}
+		t.Tags = tags
+		t.Version++
+		s.transactions[id] = t
+		changed++
+	}
+	return changed, nil
+}
+
+func (s *memoryStore) PutRates(ctx context.Context, rates []ExchangeRate) error {
+	defer s.lock(ctx)()
+
+	for _, r := range rates {
+		s.rates[rateKey{r.From, r.To, r.Date}] = r
+	}
+	return nil
+}
+
+func (s *memoryStore) ListRates(ctx context.Context, from, to string) ([]ExchangeRate, error) {
+	defer s.rlock(ctx)()
+
+	rates := []ExchangeRate{}
+	for _, r := range s.rates {
+		if (from == "" || r.From == from) && (to == "" || r.To == to) {
+			rates = append(rates, r)
+		}
+	}
+	sortRates(rates)
+	return rates, nil
+}
+
+func (s *memoryStore) DeleteRate(ctx context.Context, from, to, date string) error {
+	defer s.lock(ctx)()
+
+	key := rateKey{from, to, date}
+	if _, ok := s.rates[key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.rates, key)
+	return nil
+}
+
+func (s *memoryStore) CreateCategory(ctx context.Context, c *Category) error {
+	defer s.lock(ctx)()
+
+	c.ID = primitive.NewObjectID()
+	s.categories[c.ID] = *c
+	return nil
+}
+
+func (s *memoryStore) ListCategories(ctx context.Context) ([]Category, error) {
+	defer s.rlock(ctx)()
+
+	categories := make([]Category, 0, len(s.categories))
+	for _, c := range s.categories {
+		categories = append(categories, c)
+	}
+	sortCategories(categories)
+	return categories, nil
+}
+
+func (s *memoryStore) GetCategory(ctx context.Context, id primitive.ObjectID) (Category, error) {
+	defer s.rlock(ctx)()
+
+	c, ok := s.categories[id]
+	if !ok {
+		return Category{}, ErrNotFound
+	}
+	return c, nil
+}
+
+func (s *memoryStore) UpdateCategory(ctx context.Context, c Category) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.categories[c.ID]; !ok {
+		return ErrNotFound
+	}
+	s.categories[c.ID] = c
+	return nil
+}
+
+func (s *memoryStore) DeleteCategory(ctx context.Context, id primitive.ObjectID, reassignTo *primitive.ObjectID) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.categories[id]; !ok {
+		return ErrNotFound
+	}
+	for _, c := range s.categories {
+		if c.ParentID != nil && *c.ParentID == id {
+			return ErrCategoryHasChildren
+		}
+	}
+	if reassignTo != nil {
+		if _, ok := s.categories[*reassignTo]; !ok {
+			return ErrNotFound
+		}
+	}
+
+	for _, t := range s.transactions {
+		if t.CategoryID == nil || *t.CategoryID != id {
+			continue
+		}
+		if reassignTo == nil {
+			return ErrCategoryInUse
+		}
+		target := *reassignTo
+		t.CategoryID = &target
+		t.Version++
+		s.transactions[t.ID] = t
+	}
+	for _, r := range s.rules {
+		if r.CategoryID == nil || *r.CategoryID != id {
+			continue
+		}
+		if reassignTo == nil {
+			return ErrCategoryInUse
+		}
+		target := *reassignTo
+		r.CategoryID = &target
+		s.rules[r.ID] = r
+	}
+	for _, b := range s.budgets {
+		if b.Group.CategoryID == nil || *b.Group.CategoryID != id {
+			continue
+		}
+		if reassignTo == nil {
+			return ErrCategoryInUse
+		}
+		target := *reassignTo
+		b.Group.CategoryID = &target
+		s.budgets[b.ID] = b
+	}
+	for _, r := range s.recurring {
+		if r.CategoryID == nil || *r.CategoryID != id {
+			continue
+		}
+		if reassignTo == nil {
+			return ErrCategoryInUse
+		}
+		target := *reassignTo
+		r.CategoryID = &target
+		s.recurring[r.ID] = r
+	}
+	delete(s.categories, id)
+	return nil
+}
+
+func (s *memoryStore) CreateRule(ctx context.Context, r *Rule) error {
+	defer s.lock(ctx)()
+
+	r.ID = primitive.NewObjectID()
+	s.rules[r.ID] = *r
+	return nil
+}
+
+func (s *memoryStore) ListRules(ctx context.Context) ([]Rule, error) {
+	defer s.rlock(ctx)()
+
+	rules := make([]Rule, 0, len(s.rules))
+	for _, r := range s.rules {
+		rules = append(rules, r)
+	}
+	sortRules(rules)
+	return rules, nil
+}
+
+func (s *memoryStore) GetRule(ctx context.Context, id primitive.ObjectID) (Rule, error) {
+	defer s.rlock(ctx)()
+
+	r, ok := s.rules[id]
+	if !ok {
+		return Rule{}, ErrNotFound
+	}
+	return r, nil
+}
+
+func (s *memoryStore) UpdateRule(ctx context.Context, r Rule) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.rules[r.ID]; !ok {
+		return ErrNotFound
+	}
+	s.rules[r.ID] = r
+	return nil
+}
+
+func (s *memoryStore) DeleteRule(ctx context.Context, id primitive.ObjectID) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.rules[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.rules, id)
+	return nil
+}
+
+func (s *memoryStore) CreateBudget(ctx context.Context, b *Budget) error {
+	defer s.lock(ctx)()
+
+	b.ID = primitive.NewObjectID()
+	s.budgets[b.ID] = *b
+	return nil
+}
+
+func (s *memoryStore) ListBudgets(ctx context.Context) ([]Budget, error) {
+	defer s.rlock(ctx)()
+
+	budgets := make([]Budget, 0, len(s.budgets))
+	for _, b := range s.budgets {
+		budgets = append(budgets, b)
+	}
+	sortBudgets(budgets)
+	return budgets, nil
+}
+
+func (s *memoryStore) GetBudget(ctx context.Context, id primitive.ObjectID) (Budget, error) {
+	defer s.rlock(ctx)()
+
+	b, ok := s.budgets[id]
+	if !ok {
+		return Budget{}, ErrNotFound
+	}
+	return b, nil
+}
+
+func (s *memoryStore) UpdateBudget(ctx context.Context, b Budget) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.budgets[b.ID]; !ok {
+		return ErrNotFound
+	}
+	s.budgets[b.ID] = b
+	return nil
+}
+
+func (s *memoryStore) DeleteBudget(ctx context.Context, id primitive.ObjectID) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.budgets[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.budgets, id)
+	return nil
+}
+
+func (s *memoryStore) CreateRecurring(ctx context.Context, r *Recurring) error {
+	defer s.lock(ctx)()
+
+	r.ID = primitive.NewObjectID()
+	s.recurring[r.ID] = *r
+	return nil
+}
+
+func (s *memoryStore) ListRecurring(ctx context.Context) ([]Recurring, error) {
+	defer s.rlock(ctx)()
+
+	templates := make([]Recurring, 0, len(s.recurring))
+	for _, r := range s.recurring {
+		templates = append(templates, r)
+	}
+	sortRecurring(templates)
+	return templates, nil
+}
+
+func (s *memoryStore) GetRecurring(ctx context.Context, id primitive.ObjectID) (Recurring, error) {
+	defer s.rlock(ctx)()
+
+	r, ok := s.recurring[id]
+	if !ok {
+		return Recurring{}, ErrNotFound
+	}
+	return r, nil
+}
+
+func (s *memoryStore) SetRecurringPaused(ctx context.Context, id primitive.ObjectID, paused bool) (Recurring, error) {
+	defer s.lock(ctx)()
+
+	r, ok := s.recurring[id]
+	if !ok {
+		return Recurring{}, ErrNotFound
+	}
+	r.Paused = paused
+	s.recurring[id] = r
+	return r, nil
+}
+
+func (s *memoryStore) SkipRecurring(ctx context.Context, id primitive.ObjectID, date string) (Recurring, error) {
+	defer s.lock(ctx)()
+
+	r, ok := s.recurring[id]
+	if !ok {
+		return Recurring{}, ErrNotFound
+	}
+	i := sort.SearchStrings(r.Skipped, date)
+	if i == len(r.Skipped) || r.Skipped[i] != date {
+		skipped := make([]string, 0, len(r.Skipped)+1)
+		skipped = append(append(append(skipped, r.Skipped[:i]...), date), r.Skipped[i:]...)
+		r.Skipped = skipped
+		s.recurring[id] = r
+	}
+	return r, nil
+}
+
+func (s *memoryStore) DeleteRecurring(ctx context.Context, id primitive.ObjectID) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.recurring[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.recurring, id)
+	return nil
+}
+
+func (s *memoryStore) SetRecurringThrough(ctx context.Context, id primitive.ObjectID, through time.Time) error {
+	defer s.lock(ctx)()
+
+	r, ok := s.recurring[id]
+	if !ok || (r.Through != nil && !r.Through.Before(through)) {
+		return nil
+	}
+	r.Through = &through
+	s.recurring[id] = r
+	return nil
+}
+
+func (s *memoryStore) CreateAccount(ctx context.Context, a *Account) error {
+	defer s.lock(ctx)()
+
+	a.ID = primitive.NewObjectID()
+	s.accounts[a.ID] = *a
+	return nil
+}
+
+func (s *memoryStore) ListAccounts(ctx context.Context) ([]Account, error) {
+	defer s.rlock(ctx)()
+
+	accounts := make([]Account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		accounts = append(accounts, a)
+	}
+	sortAccounts(accounts)
+	return accounts, nil
+}
+
+func (s *memoryStore) GetAccount(ctx context.Context, id primitive.ObjectID) (Account, error) {
+	defer s.rlock(ctx)()
+
+	a, ok := s.accounts[id]
+	if !ok {
+		return Account{}, ErrNotFound
+	}
+	return a, nil
+}
+
+func (s *memoryStore) UpdateAccount(ctx context.Context, a Account) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.accounts[a.ID]; !ok {
+		return ErrNotFound
+	}
+	s.accounts[a.ID] = a
+	return nil
+}
+
+func (s *memoryStore) DeleteAccount(ctx context.Context, id primitive.ObjectID) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.accounts[id]; !ok {
+		return ErrNotFound
+	}
+	for _, t := range s.transactions {
+		if t.AccountID != nil && *t.AccountID == id {
+			return ErrAccountInUse
+		}
+	}
+	delete(s.accounts, id)
+	return nil
+}
+
+func (s *memoryStore) AccountTotals(ctx context.Context) ([]AccountTotal, error) {
+	transactions, err := s.List(ctx, ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return accountTotals(transactions)
+}
+
+func (s *memoryStore) CreateTransfer(ctx context.Context, legs []Transaction) error {
+	defer s.lock(ctx)()
+
+	for i := range legs {
+		legs[i].ID = primitive.NewObjectID()
+		legs[i].Version = 1
+		s.transactions[legs[i].ID] = legs[i]
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteTransfer(ctx context.Context, id primitive.ObjectID, version int64, at time.Time) error {
+	defer s.lock(ctx)()
+
+	var legs []Transaction
+	for _, t := range s.transactions {
+		if t.TransferID != nil && *t.TransferID == id && t.DeletedAt == nil {
+			legs = append(legs, t)
+		}
+	}
+	if len(legs) == 0 {
+		return ErrNotFound
+	}
+	if transferVersion(legs) != version {
+		return ErrVersionMismatch
+	}
+	for _, t := range legs {
+		t.DeletedAt = &at
+		t.Version++
+		s.transactions[t.ID] = t
+	}
+	return nil
+}
+
+func (s *memoryStore) AppendAudit(ctx context.Context, e *AuditEntry) error {
+	defer s.lock(ctx)()
+
+	e.ID = primitive.NewObjectID()
+	s.audit = append(s.audit, *e)
+	if n := len(s.audit); s.auditLimit > 0 && n > s.auditLimit {
+		s.audit = s.audit[n-s.auditLimit:]
+	}
+	return nil
+}
+
+func (s *memoryStore) ListAudit(ctx context.Context, f AuditFilter, limit int) ([]AuditEntry, error) {
+	defer s.rlock(ctx)()
+
+	entries := []AuditEntry{}
+	for i := len(s.audit) - 1; i >= 0 && (limit == 0 || len(entries) < limit); i-- {
+		if f.matches(s.audit[i]) {
+			entries = append(entries, s.audit[i])
+		}
+	}
+	return entries, nil
+}
+
+func (s *memoryStore) ReserveIdempotencyKey(ctx context.Context, rec IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	defer s.lock(ctx)()
+
+	now := time.Now()
+	for k, r := range s.idempotency {
+		if !r.ExpiresAt.After(now) {
+			delete(s.idempotency, k)
+		}
+	}
+	if existing, ok := s.idempotency[rec.Key]; ok {
+		return existing, false, nil
+	}
+	s.idempotency[rec.Key] = rec
+	return rec, true, nil
+}
+
+func (s *memoryStore) CompleteIdempotencyKey(ctx context.Context, key string, status int, header map[string]string, response []byte, expiresAt time.Time) error {
+	defer s.lock(ctx)()
+
+	rec, ok := s.idempotency[key]
+	if !ok {
+		return ErrNotFound
+	}
+	rec.Status = status
+	rec.Header = header
+	rec.Response = append([]byte(nil), response...)
+	rec.ExpiresAt = expiresAt
+	s.idempotency[key] = rec
+	return nil
+}
+
+func (s *memoryStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	defer s.lock(ctx)()
+
+	delete(s.idempotency, key)
+	return nil
+}
+
+func (s *memoryStore) Close(ctx context.Context) error {
+	return nil
+}
+
+// memoryData is a point-in-time copy of everything held by a memoryStore. It
+// is also the on-disk document written by fileStore.
+type memoryData struct {
+	Transactions []Transaction  `json:"transactions"`
+	Rates        []ExchangeRate `json:"rates,omitempty"`
+	Categories   []Category     `json:"categories,omitempty"`
+	Rules        []Rule         `json:"rules,omitempty"`
+	Budgets      []Budget       `json:"budgets,omitempty"`
+	Recurring    []Recurring    `json:"recurring,omitempty"`
+	Accounts     []Account      `json:"accounts,omitempty"`
+	Audit        []AuditEntry   `json:"audit,omitempty"`
+	// Idempotency holds the unexpired Idempotency-Key records.
+	Idempotency []IdempotencyRecord `json:"idempotency,omitempty"`
+}
+
+// snapshot returns a copy of the store contents.
+func (s *memoryStore) snapshot(ctx context.Context) memoryData {
+	defer s.rlock(ctx)()
+
+	transactions := make([]Transaction, 0, len(s.transactions))
+	for _, t := range s.transactions {
+		transactions = append(transactions, t)
+	}
+	sort.Slice(transactions, func(i, j int) bool {
+		return bytes.Compare(transactions[i].ID[:], transactions[j].ID[:]) < 0
+	})
+
+	rates := make([]ExchangeRate, 0, len(s.rates))
+	for _, r := range s.rates {
+		rates = append(rates, r)
+	}
+	sortRates(rates)
+
+	categories := make([]Category, 0, len(s.categories))
+	for _, c := range s.categories {
+		categories = append(categories, c)
+	}
+	sortCategories(categories)
+
+	rules := make([]Rule, 0, len(s.rules))
+	for _, r := range s.rules {
+		rules = append(rules, r)
+	}
+	sortRules(rules)
+
+	budgets := make([]Budget, 0, len(s.budgets))
+	for _, b := range s.budgets {
+		budgets = append(budgets, b)
+	}
+	sortBudgets(budgets)
+
+	recurring := make([]Recurring, 0, len(s.recurring))
+	for _, r := range s.recurring {
+		recurring = append(recurring, r)
+	}
+	sortRecurring(recurring)
+
+	accounts := make([]Account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		accounts = append(accounts, a)
+	}
+	sortAccounts(accounts)
+
+	idempotency := make([]IdempotencyRecord, 0, len(s.idempotency))
+	for _, rec := range s.idempotency {
+		idempotency = append(idempotency, rec)
+	}
+	sort.Slice(idempotency, func(i, j int) bool { return idempotency[i].Key < idempotency[j].Key })
+
+	return memoryData{
+		Transactions: transactions,
+		Rates:        rates,
+		Categories:   categories,
+		Rules:        rules,
+		Budgets:      budgets,
+		Recurring:    recurring,
+		Accounts:     accounts,
+		Audit:        append([]AuditEntry(nil), s.audit...),
+		Idempotency:  idempotency,
+	}
+}
+
+// restore replaces the store contents with data.
+func (s *memoryStore) restore(ctx context.Context, data memoryData) {
+	defer s.lock(ctx)()
+
+	s.transactions = make(map[primitive.ObjectID]Transaction, len(data.Transactions))
+	for _, t := range data.Transactions {
+		s.transactions[t.ID] = t
+	}
+	s.rates = make(map[rateKey]ExchangeRate, len(data.Rates))
+	for _, r := range data.Rates {
+		s.rates[rateKey{r.From, r.To, r.Date}] = r
+	}
+	s.categories = make(map[primitive.ObjectID]Category, len(data.Categories))
+	for _, c := range data.Categories {
+		s.categories[c.ID] = c
+	}
+	s.rules = make(map[primitive.ObjectID]Rule, len(data.Rules))
+	for _, r := range data.Rules {
+		s.rules[r.ID] = r
+	}
+	s.budgets = make(map[primitive.ObjectID]Budget, len(data.Budgets))
+	for _, b := range data.Budgets {
+		s.budgets[b.ID] = b
+	}
+	s.recurring = make(map[primitive.ObjectID]Recurring, len(data.Recurring))
+	for _, r := range data.Recurring {
+		s.recurring[r.ID] = r
+	}
+	s.audit = data.Audit
+	s.idempotency = make(map[string]IdempotencyRecord, len(data.Idempotency))
+	for _, rec := range data.Idempotency {
+		s.idempotency[rec.Key] = rec
+	}
+	s.accounts = make(map[primitive.ObjectID]Account, len(data.Accounts))
+	for _, a := range data.Accounts {
+		s.accounts[a.ID] = a
+	}
+}
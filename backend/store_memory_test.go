@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMemoryStoreCRUD(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+
+	tx := Transaction{
+		Description: "Rent", Amount: mustMoney(t, "4500"), Currency: "INR", Type: TypeExpense,
+		DateTime: time.Date(2025, 3, 29, 10, 17, 0, 0, time.UTC),
+	}
+	if err := s.Create(ctx, &tx); err != nil {
+		t.Fatal(err)
+	}
+	if tx.ID.IsZero() || tx.Version != 1 {
+		t.Fatalf("created %+v, want a new ID at version 1", tx)
+	}
+	dup := tx
+	if err := s.Create(ctx, &dup); !errors.Is(err, ErrDuplicateID) {
+		t.Errorf("creating a taken ID = %v, want ErrDuplicateID", err)
+	}
+
+	got, err := s.Get(ctx, tx.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Description != "Rent" || got.Amount.Cmp(tx.Amount) != 0 {
+		t.Errorf("Get = %+v, want %+v", got, tx)
+	}
+	if _, err := s.Get(ctx, primitive.NewObjectID()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing ID = %v, want ErrNotFound", err)
+	}
+
+	got.Description = "Flat rent"
+	if err := s.Update(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 2 {
+		t.Errorf("version after update = %d, want 2", got.Version)
+	}
+	stale := tx
+	if err := s.Update(ctx, &stale); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("update at version 1 = %v, want ErrVersionMismatch", err)
+	}
+	missing := Transaction{ID: primitive.NewObjectID(), Version: 1}
+	if err := s.Update(ctx, &missing); !errors.Is(err, ErrNotFound) {
+		t.Errorf("update of a missing ID = %v, want ErrNotFound", err)
+	}
+
+	if err := s.Trash(ctx, tx.ID, 1, time.Now()); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("trash at a stale version = %v, want ErrVersionMismatch", err)
+	}
+	if err := s.Trash(ctx, tx.ID, 2, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, tx.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a trashed transaction = %v, want ErrNotFound", err)
+	}
+	if n, err := s.PurgeTrash(ctx, time.Now().Add(time.Minute)); err != nil || n != 1 {
+		t.Errorf("PurgeTrash = %d, %v, want 1", n, err)
+	}
+	if list, _ := s.List(ctx, ListOptions{Filter: TransactionFilter{Scope: scopeAll}}); len(list) != 0 {
+		t.Errorf("%d transactions left after purging, want 0", len(list))
+	}
+}
+
+func TestMemoryStoreListOrder(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+	day := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	for _, d := range []struct {
+		desc string
+		at   time.Time
+	}{{"Old", day}, {"New", day.AddDate(0, 0, 2)}, {"Middle", day.AddDate(0, 0, 1)}} {
+		tx := Transaction{Description: d.desc, Amount: mustMoney(t, "1"), Currency: "INR", Type: TypeExpense, DateTime: d.at}
+		if err := s.Create(ctx, &tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := s.List(ctx, ListOptions{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Description != "New" || list[1].Description != "Middle" {
+		t.Errorf("List = %v, want New and Middle", descriptions(list))
+	}
+}
+
+func descriptions(list []Transaction) []string {
+	var out []string
+	for _, t := range list {
+		out = append(out, t.Description)
+	}
+	return out
+}
@@ -0,0 +1,1257 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoStore persists data in the neofinance database.
+type mongoStore struct {
+	transactions *mongo.Collection
+	rates        *mongo.Collection
+	categories   *mongo.Collection
+	rules        *mongo.Collection
+	budgets      *mongo.Collection
+	recurring    *mongo.Collection
+	accounts     *mongo.Collection
+	audit        *mongo.Collection
+	idempotency  *mongo.Collection
+	// transactional is set when the deployment, a replica set or a sharded
+	// cluster, supports multi-document transactions.
+	transactional bool
+}
+
+func newMongoStore(ctx context.Context, uri string) (*mongoStore, error) {
+	if uri == "" {
+		return nil, fmt.Errorf("MONGODB_URI environment variable not set")
+	}
+
+	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
+	clientOptions := options.Client().
+		ApplyURI(uri).
+		SetServerAPIOptions(serverAPI).
+		SetTLSConfig(&tls.Config{InsecureSkipVerify: true})
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %v", err)
+	}
+
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ping MongoDB: %v", err)
+	}
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query MongoDB topology: %v", err)
+	}
+
+	db := client.Database("neofinance")
+	s := &mongoStore{
+		transactions:  db.Collection("transactions"),
+		rates:         db.Collection("exchange_rates"),
+		categories:    db.Collection("categories"),
+		rules:         db.Collection("rules"),
+		budgets:       db.Collection("budgets"),
+		recurring:     db.Collection("recurring"),
+		accounts:      db.Collection("accounts"),
+		audit:         db.Collection("audit"),
+		idempotency:   db.Collection("idempotency_keys"),
+		transactional: hello.SetName != "" || hello.Msg == "isdbgrid",
+	}
+	if !s.transactional {
+		log.Printf("MongoDB is a standalone server without transactions; a change to several documents can be left half done if it fails")
+	}
+
+	_, err = s.transactions.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "dateTime", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "categoryId", Value: 1}}},
+		{Keys: bson.D{{Key: "tags", Value: 1}}},
+		{Keys: bson.D{{Key: "accountId", Value: 1}}},
+		{Keys: bson.D{{Key: "transferId", Value: 1}}},
+		{Keys: bson.D{{Key: "deletedAt", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create indexes: %v", err)
+	}
+	_, err = s.audit.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "resource", Value: 1}, {Key: "resourceId", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "_id", Value: -1}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create indexes: %v", err)
+	}
+	// Records expire at their own expiresAt, so changing IDEMPOTENCY_TTL
+	// needs no new index.
+	_, err = s.idempotency.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create indexes: %v", err)
+	}
+	_, err = s.rates.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "from", Value: 1}, {Key: "to", Value: 1}, {Key: "date", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create indexes: %v", err)
+	}
+
+	return s, nil
+}
+
+func (s *mongoStore) Create(ctx context.Context, t *Transaction) error {
+	t.Version = 1
+	result, err := s.transactions.InsertOne(ctx, t)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicateID
+	}
+	if err != nil {
+		return err
+	}
+	t.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (s *mongoStore) List(ctx context.Context, opts ListOptions) ([]Transaction, error) {
+	filter := mongoFilter(opts.Filter)
+	if opts.After != nil {
+		filter["$or"] = bson.A{
+			bson.M{"dateTime": bson.M{"$lt": opts.After.DateTime}},
+			bson.M{"dateTime": opts.After.DateTime, "_id": bson.M{"$lt": opts.After.ID}},
+		}
+	}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "dateTime", Value: -1}, {Key: "_id", Value: -1}})
+	if opts.Limit > 0 {
+		findOptions.SetLimit(int64(opts.Limit))
+	}
+
+	cursor, err := s.transactions.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	transactions := []Transaction{}
+	if err = cursor.All(ctx, &transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// live matches the transaction with the given ID unless it is in the trash.
+func live(id primitive.ObjectID) bson.M {
+	return bson.M{"_id": id, "deletedAt": bson.M{"$exists": false}}
+}
+
+func (s *mongoStore) Get(ctx context.Context, id primitive.ObjectID) (Transaction, error) {
+	var t Transaction
+	err := s.transactions.FindOne(ctx, live(id)).Decode(&t)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Transaction{}, ErrNotFound
+	}
+	return t, err
+}
+
+// atVersion matches the live transaction with the given ID and version.
+func atVersion(id primitive.ObjectID, version int64) bson.M {
+	return withVersion(live(id), version)
+}
+
+// withVersion adds a version condition to filter. Transactions stored before
+// versions existed have none and count as version 0.
+func withVersion(filter bson.M, version int64) bson.M {
+	switch version {
+	case anyVersion:
+	case 0:
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	default:
+		filter["version"] = version
+	}
+	return filter
+}
+
+// versionMiss tells apart the reasons a versioned write matched nothing.
+func (s *mongoStore) versionMiss(ctx context.Context, id primitive.ObjectID) error {
+	n, err := s.transactions.CountDocuments(ctx, live(id), options.Count().SetLimit(1))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return ErrVersionMismatch
+}
+
+func (s *mongoStore) Update(ctx context.Context, t *Transaction) error {
+	next := *t
+	next.Version++
+	result, err := s.transactions.ReplaceOne(ctx, atVersion(t.ID, t.Version), next)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return s.versionMiss(ctx, t.ID)
+	}
+	t.Version = next.Version
+	return nil
+}
+
+func (s *mongoStore) Trash(ctx context.Context, id primitive.ObjectID, version int64, at time.Time) error {
+	result, err := s.transactions.UpdateOne(ctx, atVersion(id, version), bson.M{
+		"$set": bson.M{"deletedAt": at},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return s.versionMiss(ctx, id)
+	}
+	return nil
+}
+
+func (s *mongoStore) Restore(ctx context.Context, id primitive.ObjectID, version int64) (Transaction, error) {
+	trashed := bson.M{"_id": id, "deletedAt": bson.M{"$exists": true}}
+	restore := bson.M{"$unset": bson.M{"deletedAt": ""}, "$inc": bson.M{"version": 1}}
+
+	var t Transaction
+	err := s.transactions.FindOne(ctx, trashed).Decode(&t)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Transaction{}, ErrNotFound
+	}
+	if err != nil {
+		return Transaction{}, err
+	}
+	if version != anyVersion && t.Version != version {
+		return Transaction{}, ErrVersionMismatch
+	}
+	if t.TransferID == nil {
+		err = s.transactions.FindOneAndUpdate(ctx, withVersion(trashed, t.Version), restore,
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&t)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return Transaction{}, ErrVersionMismatch
+		}
+		return t, err
+	}
+
+	// Both legs of a transfer were deleted at the same time and come back
+	// together. Their versions may differ, as a leg can change on its own.
+	err = s.inTransaction(ctx, func(sc mongo.SessionContext) error {
+		cursor, err := s.transactions.Find(sc, bson.M{"transferId": *t.TransferID, "deletedAt": *t.DeletedAt},
+			options.Find().SetProjection(bson.M{"version": 1}))
+		if err != nil {
+			return err
+		}
+		var legs []Transaction
+		if err := cursor.All(sc, &legs); err != nil {
+			return err
+		}
+
+		// The leg asked for must be unchanged since it was read above, and
+		// each leg must still be at the version just read.
+		read := bson.A{}
+		found := false
+		for _, leg := range legs {
+			if leg.ID == id {
+				if leg.Version != t.Version {
+					return ErrVersionMismatch
+				}
+				found = true
+			}
+			read = append(read, withVersion(bson.M{"_id": leg.ID}, leg.Version))
+		}
+		if !found {
+			return ErrVersionMismatch
+		}
+		result, err := s.transactions.UpdateMany(sc, bson.M{"$or": read, "deletedAt": *t.DeletedAt}, restore)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount != int64(len(legs)) {
+			// Returning an error aborts the transaction, so no leg is
+			// restored.
+			return ErrVersionMismatch
+		}
+		return s.transactions.FindOne(sc, bson.M{"_id": id}).Decode(&t)
+	})
+	return t, err
+}
+
+func (s *mongoStore) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	result, err := s.transactions.DeleteMany(ctx, bson.M{"deletedAt": bson.M{"$lt": before}})
+	if err != nil {
+		return 0, err
+	}
+	return int(result.DeletedCount), nil
+}
+
+func (s *mongoStore) DailyTotals(ctx context.Context, f TransactionFilter) ([]DailyTotal, error) {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: mongoFilter(f)}}}
+	if len(f.Labels) > 0 {
+		// Count only the split lines with a matching label.
+		pipeline = append(pipeline, bson.D{{Key: "$set", Value: bson.M{
+			"amount": bson.M{"$sum": bson.M{"$map": bson.M{
+				"input": bson.M{"$filter": bson.M{
+					"input": "$splits",
+					"cond":  bson.M{"$in": bson.A{"$$this.label", f.Labels}},
+				}},
+				"in": "$$this.amount",
+			}}},
+		}}})
+	}
+	pipeline = append(pipeline, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"day": bson.M{"$dateTrunc": bson.M{
+					"date":     "$dateTime",
+					"unit":     "day",
+					"timezone": "UTC",
+				}},
+				"type":     "$type",
+				"currency": "$currency",
+			},
+			"total": bson.M{"$sum": "$amount"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":      0,
+			"day":      "$_id.day",
+			"type":     "$_id.type",
+			"currency": "$_id.currency",
+			"total":    1,
+			"count":    1,
+		}}},
+		{{Key: "$sort", Value: bson.M{"day": 1}}},
+	}...)
+
+	cursor, err := s.transactions.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	totals := []DailyTotal{}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, err
+	}
+	for i := range totals {
+		totals[i].Day = totals[i].Day.UTC()
+	}
+	return totals, nil
+}
+
+func (s *mongoStore) LabelTotals(ctx context.Context, f TransactionFilter) ([]DailyTotal, error) {
+	// Unsplit transactions become a single line with an empty label.
+	lines := bson.M{"$cond": bson.A{
+		bson.M{"$gt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$splits", bson.A{}}}}, 0}},
+		"$splits",
+		bson.A{bson.M{"amount": "$amount", "label": ""}},
+	}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: mongoFilter(f)}},
+		{{Key: "$project", Value: bson.M{"dateTime": 1, "type": 1, "currency": 1, "lines": lines}}},
+		{{Key: "$unwind", Value: "$lines"}},
+	}
+	if len(f.Labels) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"lines.label": bson.M{"$in": f.Labels}}}})
+	}
+	pipeline = append(pipeline, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"day": bson.M{"$dateTrunc": bson.M{
+					"date":     "$dateTime",
+					"unit":     "day",
+					"timezone": "UTC",
+				}},
+				"type":     "$type",
+				"currency": "$currency",
+				"label":    "$lines.label",
+			},
+			"total": bson.M{"$sum": "$lines.amount"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":      0,
+			"day":      "$_id.day",
+			"type":     "$_id.type",
+			"currency": "$_id.currency",
+			"label":    "$_id.label",
+			"total":    1,
+			"count":    1,
+		}}},
+		{{Key: "$sort", Value: bson.M{"day": 1}}},
+	}...)
+
+	cursor, err := s.transactions.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	totals := []DailyTotal{}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, err
+	}
+	for i := range totals {
+		totals[i].Day = totals[i].Day.UTC()
+	}
+	return totals, nil
+}
+
+func (s *mongoStore) TagCounts(ctx context.Context, prefix string) ([]TagCount, error) {
+	match := bson.M{"tags": bson.M{"$exists": true}, "deletedAt": bson.M{"$exists": false}}
+	if prefix != "" {
+		match["tags"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(prefix)}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+
+	cursor, err := s.transactions.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	counts := []TagCount{}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// RenameTag first drops from where a transaction already carries to, then
+// renames it everywhere else.
+func (s *mongoStore) RenameTag(ctx context.Context, from, to string) (int, error) {
+	var n int
+	err := s.inTransaction(ctx, func(sc mongo.SessionContext) error {
+		merged, err := s.transactions.UpdateMany(sc,
+			bson.M{"tags": bson.M{"$all": bson.A{from, to}}},
+			bson.M{"$pull": bson.M{"tags": from}, "$inc": bson.M{"version": 1}})
+		if err != nil {
+			return err
+		}
+		renamed, err := s.transactions.UpdateMany(sc,
+			bson.M{"tags": from},
+			bson.M{"$set": bson.M{"tags.$": to}, "$inc": bson.M{"version": 1}})
+		if err != nil {
+			return err
+		}
+		n = int(merged.ModifiedCount + renamed.ModifiedCount)
+		return nil
+	})
+	return n, err
+}
+
+func (s *mongoStore) PutRates(ctx context.Context, rates []ExchangeRate) error {
+	if len(rates) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, len(rates))
+	for i, r := range rates {
+		models[i] = mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"from": r.From, "to": r.To, "date": r.Date}).
+			SetReplacement(r).
+			SetUpsert(true)
+	}
+	_, err := s.rates.BulkWrite(ctx, models)
+	return err
+}
+
+func (s *mongoStore) ListRates(ctx context.Context, from, to string) ([]ExchangeRate, error) {
+	filter := bson.M{}
+	if from != "" {
+		filter["from"] = from
+	}
+	if to != "" {
+		filter["to"] = to
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "from", Value: 1}, {Key: "to", Value: 1}, {Key: "date", Value: 1}}).
+		SetProjection(bson.M{"_id": 0})
+	cursor, err := s.rates.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	rates := []ExchangeRate{}
+	if err := cursor.All(ctx, &rates); err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+func (s *mongoStore) DeleteRate(ctx context.Context, from, to, date string) error {
+	result, err := s.rates.DeleteOne(ctx, bson.M{"from": from, "to": to, "date": date})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) CreateCategory(ctx context.Context, c *Category) error {
+	c.ID = primitive.NewObjectID()
+	_, err := s.categories.InsertOne(ctx, c)
+	return err
+}
+
+func (s *mongoStore) ListCategories(ctx context.Context) ([]Category, error) {
+	cursor, err := s.categories.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	categories := []Category{}
+	if err := cursor.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+	sortCategories(categories)
+	return categories, nil
+}
+
+func (s *mongoStore) GetCategory(ctx context.Context, id primitive.ObjectID) (Category, error) {
+	var c Category
+	err := s.categories.FindOne(ctx, bson.M{"_id": id}).Decode(&c)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Category{}, ErrNotFound
+	}
+	return c, err
+}
+
+func (s *mongoStore) UpdateCategory(ctx context.Context, c Category) error {
+	result, err := s.categories.ReplaceOne(ctx, bson.M{"_id": c.ID}, c)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteCategory checks, moves the references and deletes in one
+// transaction, so a failure halfway leaves every reference in place and a
+// reference added meanwhile is not left dangling.
+func (s *mongoStore) DeleteCategory(ctx context.Context, id primitive.ObjectID, reassignTo *primitive.ObjectID) error {
+	return s.inTransaction(ctx, func(sc mongo.SessionContext) error {
+		if _, err := s.GetCategory(sc, id); err != nil {
+			return err
+		}
+		children, err := s.categories.CountDocuments(sc, bson.M{"parentId": id}, options.Count().SetLimit(1))
+		if err != nil {
+			return err
+		}
+		if children > 0 {
+			return ErrCategoryHasChildren
+		}
+
+		if reassignTo == nil {
+			for _, ref := range s.categoryRefs() {
+				used, err := ref.coll.CountDocuments(sc, bson.M{ref.field: id}, options.Count().SetLimit(1))
+				if err != nil {
+					return err
+				}
+				if used > 0 {
+					return ErrCategoryInUse
+				}
+			}
+		} else {
+			if _, err := s.GetCategory(sc, *reassignTo); err != nil {
+				return err
+			}
+			for _, ref := range s.categoryRefs() {
+				update := bson.M{"$set": bson.M{ref.field: *reassignTo}}
+				if ref.versioned {
+					update["$inc"] = bson.M{"version": 1}
+				}
+				_, err := ref.coll.UpdateMany(sc, bson.M{ref.field: id}, update)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		result, err := s.categories.DeleteOne(sc, bson.M{"_id": id})
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// categoryRef is a field that refers to a category. Versioned documents
+// get a new version when the field changes.
+type categoryRef struct {
+	coll      *mongo.Collection
+	field     string
+	versioned bool
+}
+
+func (s *mongoStore) categoryRefs() []categoryRef {
+	return []categoryRef{
+		{s.transactions, "categoryId", true},
+		{s.rules, "categoryId", false},
+		{s.budgets, "group.categoryId", false},
+		{s.recurring, "categoryId", false},
+	}
+}
+
+func (s *mongoStore) CreateRule(ctx context.Context, r *Rule) error {
+	r.ID = primitive.NewObjectID()
+	_, err := s.rules.InsertOne(ctx, r)
+	return err
+}
+
+func (s *mongoStore) ListRules(ctx context.Context) ([]Rule, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "priority", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.rules.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	rules := []Rule{}
+	if err := cursor.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (s *mongoStore) GetRule(ctx context.Context, id primitive.ObjectID) (Rule, error) {
+	var r Rule
+	err := s.rules.FindOne(ctx, bson.M{"_id": id}).Decode(&r)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Rule{}, ErrNotFound
+	}
+	return r, err
+}
+
+func (s *mongoStore) UpdateRule(ctx context.Context, r Rule) error {
+	result, err := s.rules.ReplaceOne(ctx, bson.M{"_id": r.ID}, r)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) DeleteRule(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.rules.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) CreateBudget(ctx context.Context, b *Budget) error {
+	b.ID = primitive.NewObjectID()
+	_, err := s.budgets.InsertOne(ctx, b)
+	return err
+}
+
+func (s *mongoStore) ListBudgets(ctx context.Context) ([]Budget, error) {
+	cursor, err := s.budgets.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	budgets := []Budget{}
+	if err := cursor.All(ctx, &budgets); err != nil {
+		return nil, err
+	}
+	sortBudgets(budgets)
+	return budgets, nil
+}
+
+func (s *mongoStore) GetBudget(ctx context.Context, id primitive.ObjectID) (Budget, error) {
+	var b Budget
+	err := s.budgets.FindOne(ctx, bson.M{"_id": id}).Decode(&b)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Budget{}, ErrNotFound
+	}
+	return b, err
+}
+
+func (s *mongoStore) UpdateBudget(ctx context.Context, b Budget) error {
+	result, err := s.budgets.ReplaceOne(ctx, bson.M{"_id": b.ID}, b)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) DeleteBudget(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.budgets.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) CreateRecurring(ctx context.Context, r *Recurring) error {
+	r.ID = primitive.NewObjectID()
+	_, err := s.recurring.InsertOne(ctx, r)
+	return err
+}
+
+func (s *mongoStore) ListRecurring(ctx context.Context) ([]Recurring, error) {
+	cursor, err := s.recurring.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	templates := []Recurring{}
+	if err := cursor.All(ctx, &templates); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+func (s *mongoStore) GetRecurring(ctx context.Context, id primitive.ObjectID) (Recurring, error) {
+	var r Recurring
+	err := s.recurring.FindOne(ctx, bson.M{"_id": id}).Decode(&r)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Recurring{}, ErrNotFound
+	}
+	return r, err
+}
+
+func (s *mongoStore) SetRecurringPaused(ctx context.Context, id primitive.ObjectID, paused bool) (Recurring, error) {
+	var r Recurring
+	err := s.recurring.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"paused": paused}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&r)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Recurring{}, ErrNotFound
+	}
+	return r, err
+}
+
+// SkipRecurring keeps the skipped dates sorted. A date that is already
+// skipped matches nothing, and the template is then returned as it is.
+func (s *mongoStore) SkipRecurring(ctx context.Context, id primitive.ObjectID, date string) (Recurring, error) {
+	var r Recurring
+	err := s.recurring.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "skipped": bson.M{"$ne": date}},
+		bson.M{"$push": bson.M{"skipped": bson.M{"$each": bson.A{date}, "$sort": 1}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&r)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return s.GetRecurring(ctx, id)
+	}
+	return r, err
+}
+
+func (s *mongoStore) DeleteRecurring(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.recurring.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) SetRecurringThrough(ctx context.Context, id primitive.ObjectID, through time.Time) error {
+	filter := bson.M{
+		"_id": id,
+		"$or": bson.A{
+			bson.M{"through": bson.M{"$exists": false}},
+			bson.M{"through": bson.M{"$lt": through}},
+		},
+	}
+	_, err := s.recurring.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"through": through}})
+	return err
+}
+
+func (s *mongoStore) CreateAccount(ctx context.Context, a *Account) error {
+	a.ID = primitive.NewObjectID()
+	_, err := s.accounts.InsertOne(ctx, a)
+	return err
+}
+
+func (s *mongoStore) ListAccounts(ctx context.Context) ([]Account, error) {
+	cursor, err := s.accounts.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	accounts := []Account{}
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, err
+	}
+	sortAccounts(accounts)
+	return accounts, nil
+}
+
+func (s *mongoStore) GetAccount(ctx context.Context, id primitive.ObjectID) (Account, error) {
+	var a Account
+	err := s.accounts.FindOne(ctx, bson.M{"_id": id}).Decode(&a)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Account{}, ErrNotFound
+	}
+	return a, err
+}
+
+func (s *mongoStore) UpdateAccount(ctx context.Context, a Account) error {
+	result, err := s.accounts.ReplaceOne(ctx, bson.M{"_id": a.ID}, a)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) DeleteAccount(ctx context.Context, id primitive.ObjectID) error {
+	return s.inTransaction(ctx, func(sc mongo.SessionContext) error {
+		n, err := s.transactions.CountDocuments(sc, bson.M{"accountId": id}, options.Count().SetLimit(1))
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			if _, err := s.GetAccount(sc, id); err != nil {
+				return err
+			}
+			return ErrAccountInUse
+		}
+
+		result, err := s.accounts.DeleteOne(sc, bson.M{"_id": id})
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (s *mongoStore) AccountTotals(ctx context.Context) ([]AccountTotal, error) {
+	negative := bson.M{"$or": bson.A{
+		bson.M{"$eq": bson.A{"$type", TypeExpense}},
+		bson.M{"$eq": bson.A{"$direction", DirectionOut}},
+	}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"accountId": bson.M{"$exists": true}, "deletedAt": bson.M{"$exists": false}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": "$accountId",
+			"total": bson.M{"$sum": bson.M{"$cond": bson.A{
+				negative,
+				bson.M{"$multiply": bson.A{"$amount", -1}},
+				"$amount",
+			}}},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+
+	cursor, err := s.transactions.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	totals := []AccountTotal{}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
+
+// CreateTransfer inserts the legs in a multi-document transaction, which
+// needs a replica set (every Atlas cluster is one).
+func (s *mongoStore) CreateTransfer(ctx context.Context, legs []Transaction) error {
+	docs := make([]interface{}, len(legs))
+	for i := range legs {
+		legs[i].ID = primitive.NewObjectID()
+		legs[i].Version = 1
+		docs[i] = legs[i]
+	}
+	return s.inTransaction(ctx, func(sc mongo.SessionContext) error {
+		_, err := s.transactions.InsertMany(sc, docs)
+		return err
+	})
+}
+
+func (s *mongoStore) DeleteTransfer(ctx context.Context, id primitive.ObjectID, version int64, at time.Time) error {
+	return s.inTransaction(ctx, func(sc mongo.SessionContext) error {
+		cursor, err := s.transactions.Find(sc, bson.M{"transferId": id, "deletedAt": bson.M{"$exists": false}},
+			options.Find().SetProjection(bson.M{"version": 1}))
+		if err != nil {
+			return err
+		}
+		var legs []Transaction
+		if err := cursor.All(sc, &legs); err != nil {
+			return err
+		}
+		if len(legs) == 0 {
+			return ErrNotFound
+		}
+		if transferVersion(legs) != version {
+			return ErrVersionMismatch
+		}
+
+		// Each leg must still be at the version just read.
+		read := bson.A{}
+		for _, leg := range legs {
+			read = append(read, withVersion(bson.M{"_id": leg.ID}, leg.Version))
+		}
+		result, err := s.transactions.UpdateMany(sc, bson.M{"$or": read, "deletedAt": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"deletedAt": at}, "$inc": bson.M{"version": 1}})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount != int64(len(legs)) {
+			// Returning an error aborts the transaction, so no leg is
+			// deleted.
+			return ErrVersionMismatch
+		}
+		return nil
+	})
+}
+
+func (s *mongoStore) AppendAudit(ctx context.Context, e *AuditEntry) error {
+	e.ID = primitive.NewObjectID()
+	_, err := s.audit.InsertOne(ctx, e)
+	return err
+}
+
+func (s *mongoStore) ListAudit(ctx context.Context, f AuditFilter, limit int) ([]AuditEntry, error) {
+	filter := bson.M{}
+	for field, v := range map[string]string{
+		"actor":      f.Actor,
+		"operation":  f.Operation,
+		"resource":   f.Resource,
+		"resourceId": f.ResourceID,
+	} {
+		if v != "" {
+			filter[field] = v
+		}
+	}
+	at := bson.M{}
+	if f.From != nil {
+		at["$gte"] = *f.From
+	}
+	if f.To != nil {
+		at["$lte"] = *f.To
+	}
+	if len(at) > 0 {
+		filter["time"] = at
+	}
+	if f.Before != nil {
+		filter["_id"] = bson.M{"$lt": *f.Before}
+	}
+
+	findOptions := options.Find().SetSort(bson.M{"_id": -1})
+	if limit > 0 {
+		findOptions.SetLimit(int64(limit))
+	}
+	cursor, err := s.audit.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].Time = entries[i].Time.UTC()
+	}
+	return entries, nil
+}
+
+// inTransaction runs fn in a multi-document transaction, committing only if
+// it returns nil. Inside Atomically, fn joins the transaction already open.
+// A standalone server has no transactions, so there, like Atomically, it runs
+// fn in a plain session and its writes are kept one by one.
+func (s *mongoStore) inTransaction(ctx context.Context, fn func(mongo.SessionContext) error) error {
+	if session := mongo.SessionFromContext(ctx); session != nil {
+		return fn(mongo.NewSessionContext(ctx, session))
+	}
+	session, err := s.transactions.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	if !s.transactional {
+		return mongo.WithSession(ctx, session, fn)
+	}
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// maxUnitAttempts is how often Atomically runs a unit of work that keeps
+// clashing with concurrent writes, and how often it retries a commit whose
+// outcome is unknown.
+const maxUnitAttempts = 3
+
+// Atomically runs fn in one multi-document transaction, so that a request's
+// changes and the audit entries recording them are committed together. A
+// transaction that fails with a transient error, such as a write conflict,
+// is run again up to maxUnitAttempts times before ErrWriteConflict is
+// returned. Without a replica set there are no transactions, and fn is
+// simply run.
+func (s *mongoStore) Atomically(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !s.transactional || mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+	session, err := s.transactions.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	// Ending the session aborts the transaction if it was not committed.
+	defer session.EndSession(context.WithoutCancel(ctx))
+
+	for attempt := 1; ; attempt++ {
+		err := s.runTransaction(ctx, session, fn)
+		if !hasErrorLabel(err, "TransientTransactionError") {
+			return err
+		}
+		session.AbortTransaction(context.WithoutCancel(ctx))
+		if attempt == maxUnitAttempts {
+			return fmt.Errorf("%w: %v", ErrWriteConflict, err)
+		}
+	}
+}
+
+// runTransaction runs fn in a new transaction of session and commits it.
+func (s *mongoStore) runTransaction(ctx context.Context, session mongo.Session, fn func(ctx context.Context) error) error {
+	if err := session.StartTransaction(); err != nil {
+		return err
+	}
+	if err := fn(mongo.NewSessionContext(ctx, session)); err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		err := session.CommitTransaction(context.WithoutCancel(ctx))
+		if attempt == maxUnitAttempts || !hasErrorLabel(err, "UnknownTransactionCommitResult") {
+			return err
+		}
+	}
+}
+
+// hasErrorLabel reports whether err carries the MongoDB error label.
+func hasErrorLabel(err error, label string) bool {
+	var labeled mongo.LabeledError
+	return errors.As(err, &labeled) && labeled.HasErrorLabel(label)
+}
+
+// MigrateAmounts converts amounts stored as doubles or integers into
+// Decimal128 and fills in missing currencies.
+func (s *mongoStore) MigrateAmounts(ctx context.Context, currency string) (int, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"amount": bson.M{"$type": bson.A{"double", "int", "long"}}},
+		bson.M{"currency": bson.M{"$exists": false}},
+	}}
+	cursor, err := s.transactions.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	changed := 0
+	for cursor.Next(ctx) {
+		var t Transaction
+		if err := cursor.Decode(&t); err != nil {
+			return changed, err
+		}
+		if t.Currency == "" {
+			t.Currency = currency
+		}
+		amount, err := t.Amount.Round(currencyScale(t.Currency))
+		if err != nil {
+			return changed, fmt.Errorf("transaction %s: amount %s in %s: %v", t.ID.Hex(), t.Amount, t.Currency, err)
+		}
+		_, err = s.transactions.UpdateOne(ctx,
+			bson.M{"_id": t.ID},
+			bson.M{
+				"$set": bson.M{
+					"amount":   amount,
+					"currency": t.Currency,
+				},
+				"$inc": bson.M{"version": 1},
+			})
+		if err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	return changed, cursor.Err()
+}
+
+// ReserveIdempotencyKey relies on the unique _id to let only one request
+// reserve a key. MongoDB removes expired records about once a minute, so one
+// that has expired but still exists is taken over.
+func (s *mongoStore) ReserveIdempotencyKey(ctx context.Context, rec IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	_, err := s.idempotency.InsertOne(ctx, rec)
+	if err == nil {
+		return rec, true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return IdempotencyRecord{}, false, err
+	}
+
+	result, err := s.idempotency.ReplaceOne(ctx,
+		bson.M{"_id": rec.Key, "expiresAt": bson.M{"$lte": time.Now()}}, rec)
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	if result.MatchedCount > 0 {
+		return rec, true, nil
+	}
+	var existing IdempotencyRecord
+	err = s.idempotency.FindOne(ctx, bson.M{"_id": rec.Key}).Decode(&existing)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Removed in the meantime; try again.
+		return s.ReserveIdempotencyKey(ctx, rec)
+	}
+	return existing, false, err
+}
+
+func (s *mongoStore) CompleteIdempotencyKey(ctx context.Context, key string, status int, header map[string]string, response []byte, expiresAt time.Time) error {
+	result, err := s.idempotency.UpdateOne(ctx, bson.M{"_id": key}, bson.M{"$set": bson.M{
+		"status":    status,
+		"header":    header,
+		"response":  response,
+		"expiresAt": expiresAt,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	_, err := s.idempotency.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
+
+func (s *mongoStore) Close(ctx context.Context) error {
+	return s.transactions.Database().Client().Disconnect(ctx)
+}
+
+// mongoFilter translates f into a query document on the transactions
+// collection.
+func mongoFilter(f TransactionFilter) bson.M {
+	filter := bson.M{}
+	switch f.Scope {
+	case scopeLive:
+		filter["deletedAt"] = bson.M{"$exists": false}
+	case scopeTrash:
+		filter["deletedAt"] = bson.M{"$exists": true}
+	}
+	if f.Type != "" {
+		filter["type"] = f.Type
+	}
+
+	dateTime := bson.M{}
+	if f.From != nil {
+		dateTime["$gte"] = *f.From
+	}
+	if f.To != nil {
+		dateTime["$lte"] = *f.To
+	}
+	if len(dateTime) > 0 {
+		filter["dateTime"] = dateTime
+	}
+
+	amount := bson.M{}
+	if f.MinAmount != nil {
+		amount["$gte"] = *f.MinAmount
+	}
+	if f.MaxAmount != nil {
+		amount["$lte"] = *f.MaxAmount
+	}
+	if len(amount) > 0 {
+		filter["amount"] = amount
+	}
+
+	if f.Query != "" {
+		filter["description"] = primitive.Regex{Pattern: regexp.QuoteMeta(f.Query), Options: "i"}
+	}
+	if len(f.CategoryIDs) > 0 {
+		filter["categoryId"] = bson.M{"$in": f.CategoryIDs}
+	}
+
+	tags := bson.M{}
+	if len(f.TagsAll) > 0 {
+		tags["$all"] = f.TagsAll
+	}
+	if len(f.TagsAny) > 0 {
+		tags["$in"] = f.TagsAny
+	}
+	if len(tags) > 0 {
+		filter["tags"] = tags
+	}
+	if len(f.AccountIDs) > 0 {
+		filter["accountId"] = bson.M{"$in": f.AccountIDs}
+	}
+	if f.TransferID != nil {
+		filter["transferId"] = *f.TransferID
+	}
+	if len(f.Labels) > 0 {
+		filter["splits.label"] = bson.M{"$in": f.Labels}
+	}
+	return filter
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// DailyTotal sums the transactions of one type and currency on one UTC day.
+// Stores aggregate into daily totals; summaries and reports then convert each
+// of them at that day's exchange rate. When filtered by split label only the
+// matching split lines count.
+type DailyTotal struct {
+	Day      time.Time `bson:"day"`
+	Type     string    `bson:"type"`
+	Currency string    `bson:"currency"`
+	Label    string    `bson:"label,omitempty"`
+	Total    Money     `bson:"total"`
+	Count    int       `bson:"count"`
+}
+
+// dailyTotals groups the transactions matched by f into daily totals ordered
+// by day, for stores that have no query engine to do it for them.
+func dailyTotals(transactions []Transaction, f TransactionFilter) ([]DailyTotal, error) {
+	type key struct {
+		day           time.Time
+		typ, currency string
+	}
+	index := make(map[key]int)
+	var totals []DailyTotal
+	for _, t := range transactions {
+		k := key{granularityDay.truncate(t.DateTime), t.Type, t.Currency}
+		i, ok := index[k]
+		if !ok {
+			i = len(totals)
+			index[k] = i
+			totals = append(totals, DailyTotal{Day: k.day, Type: k.typ, Currency: k.currency})
+		}
+		total, err := totals[i].Total.checkedAdd(f.amountFor(t))
+		if err != nil {
+			return nil, fmt.Errorf("%s total of %s: %w", t.Type, k.day.Format("2006-01-02"), err)
+		}
+		totals[i].Total = total
+		totals[i].Count++
+	}
+	sort.SliceStable(totals, func(i, j int) bool { return totals[i].Day.Before(totals[j].Day) })
+	return totals, nil
+}
+
+// Summary totals the transactions that match a filter in the base currency.
+type Summary struct {
+	Currency string `json:"currency"`
+	Income   Money  `json:"income"`
+	Expense  Money  `json:"expense"`
+	Balance  Money  `json:"balance"`
+	Count    int    `json:"count"`
+}
+
+// add accounts for a daily total already converted to the base currency.
+func (s *Summary) add(typ string, amount Money, count int) error {
+	s.Count += count
+	return addByType(typ, amount, &s.Income, &s.Expense, &s.Balance)
+}
+
+// addByType adds amount to income or expense, as typ says, and to or from
+// net. Converted totals are not bounded by validation, so the sums are
+// checked; on overflow nothing is changed and errMoneyRange is returned.
+func addByType(typ string, amount Money, income, expense, net *Money) error {
+	var total, newNet Money
+	var err error
+	switch typ {
+	case TypeIncome:
+		if total, err = income.checkedAdd(amount); err == nil {
+			newNet, err = net.checkedAdd(amount)
+		}
+		if err == nil {
+			*income, *net = total, newNet
+		}
+	case TypeExpense:
+		if total, err = expense.checkedAdd(amount); err == nil {
+			newNet, err = net.checkedSub(amount)
+		}
+		if err == nil {
+			*expense, *net = total, newNet
+		}
+	}
+	return err
+}
+
+// writeAggregateError reports a failure to build a summary or report. A
+// missing exchange rate is the client's to fix, so it is not a server error.
+func writeAggregateError(w http.ResponseWriter, err error) {
+	var noRate *errNoRate
+	if errors.As(err, &noRate) {
+		writeError(w, http.StatusUnprocessableEntity, CodeMissingRate, noRate.Error())
+		return
+	}
+	writeInternalError(w, err)
+}
+
+// getSummary handles GET /summary. It accepts the same filters as
+// GET /transactions.
+func (s *server) getSummary(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseTransactionFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := s.expandCategoryFilter(ctx, &filter); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	totals, err := s.store.DailyTotals(ctx, filter)
+	if err != nil {
+		writeAggregateError(w, err)
+		return
+	}
+
+	base := s.rules.DefaultCurrency
+	conv := newConverter(s.store, base)
+	summary := Summary{Currency: base}
+	for _, dt := range totals {
+		if dt.Type == TypeTransfer {
+			continue
+		}
+		amount, err := conv.convert(ctx, dt.Total, dt.Currency, dt.Day)
+		if err != nil {
+			writeAggregateError(w, err)
+			return
+		}
+		if err := summary.add(dt.Type, amount, dt.Count); err != nil {
+			writeAggregateError(w, err)
+			return
+		}
+	}
+
+	scale := currencyScale(base)
+	summary.Income = summary.Income.atLeastScale(scale)
+	summary.Expense = summary.Expense.atLeastScale(scale)
+	summary.Balance = summary.Balance.atLeastScale(scale)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
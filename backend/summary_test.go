@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSummary(t *testing.T) {
+	s := newTestServer()
+	routes := s.routes()
+	for _, body := range []string{
+		`{"description":"Salary","amount":"45000","type":"income","dateTime":"2025-03-01T09:00:00Z"}`,
+		`{"description":"Refund","amount":"199.99","type":"income","dateTime":"2025-03-15T09:00:00Z"}`,
+		`{"description":"Rent","amount":"4500","type":"expense","dateTime":"2025-03-02T09:00:00Z"}`,
+		`{"description":"Swiggy","amount":"1300.50","type":"expense","dateTime":"2025-04-02T09:00:00Z"}`,
+	} {
+		if w := do(routes.ServeHTTP, http.MethodPost, "/transactions", body, nil); w.Code != http.StatusCreated {
+			t.Fatalf("create: got %d %s", w.Code, w.Body)
+		}
+	}
+	// Transfers move money between accounts and are neither income nor
+	// expense.
+	transfer := primitive.NewObjectID()
+	leg := Transaction{Description: "To wallet", Amount: mustMoney(t, "300.00"), Currency: "INR", Type: TypeTransfer,
+		DateTime: time.Date(2025, 3, 5, 9, 0, 0, 0, time.UTC), TransferID: &transfer, Direction: DirectionOut}
+	if err := s.store.Create(context.Background(), &leg); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query                    string
+		income, expense, balance string
+		count                    int
+	}{
+		{"", "45199.99", "5800.50", "39399.49", 4},
+		{"from=2025-03-01&to=2025-03-31", "45199.99", "4500.00", "40699.99", 3},
+		{"type=expense", "0.00", "5800.50", "-5800.50", 2},
+		{"from=2025-04-01", "0.00", "1300.50", "-1300.50", 1},
+		{"from=2025-05-01&to=2025-05-31", "0.00", "0.00", "0.00", 0},
+	}
+	for _, tt := range tests {
+		w := do(routes.ServeHTTP, http.MethodGet, "/summary?"+tt.query, "", nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("GET /summary?%s: got %d %s", tt.query, w.Code, w.Body)
+			continue
+		}
+		var got Summary
+		decode(t, w, &got)
+		if got.Currency != "INR" || got.Income.String() != tt.income || got.Expense.String() != tt.expense ||
+			got.Balance.String() != tt.balance || got.Count != tt.count {
+			t.Errorf("GET /summary?%s = %s income, %s expense, %s balance, %d transactions in %s; want %s, %s, %s, %d in INR",
+				tt.query, got.Income, got.Expense, got.Balance, got.Count, got.Currency, tt.income, tt.expense, tt.balance, tt.count)
+		}
+	}
+}
+
+func TestSummaryOfEmptyStore(t *testing.T) {
+	w := do(newTestServer().routes().ServeHTTP, http.MethodGet, "/summary", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /summary: got %d %s", w.Code, w.Body)
+	}
+	if got, want := w.Body.String(), `{"currency":"INR","income":"0.00","expense":"0.00","balance":"0.00","count":0}`+"\n"; got != want {
+		t.Errorf("GET /summary = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxTags           = 20
+	maxTagLength      = 50
+	defaultTagResults = 20
+)
+
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N}_-]*$`)
+
+// TagCount is the number of transactions carrying a tag.
+type TagCount struct {
+	Tag   string `json:"tag" bson:"_id"`
+	Count int    `json:"count" bson:"count"`
+}
+
+// TagStore is implemented by stores that can summarise and rewrite tags
+// across all transactions.
+type TagStore interface {
+	// TagCounts returns the tags that start with prefix, most used first.
+	TagCounts(ctx context.Context, prefix string) ([]TagCount, error)
+	// RenameTag replaces the tag from with to on every transaction, merging
+	// the two where a transaction carries both. It returns the number of
+	// transactions changed.
+	RenameTag(ctx context.Context, from, to string) (int, error)
+}
+
+// normalizeTag lower-cases and trims a tag so "Trip-Goa " and "trip-goa"
+// are the same.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// validTag reports whether a normalised tag is acceptable.
+func validTag(tag string) bool {
+	return utf8.RuneCountInString(tag) <= maxTagLength && tagPattern.MatchString(tag)
+}
+
+// normalizeTags normalises and de-duplicates tags, keeping their order, and
+// records problems in errs.
+func normalizeTags(errs *validationError, tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool, len(tags))
+	var result []string
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if !validTag(tag) {
+			errs.add("tags", "%q must be 1-%d letters, digits, '-' or '_', starting with a letter or digit", tag, maxTagLength)
+			continue
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+	if len(result) > maxTags {
+		errs.add("tags", "must have at most %d entries", maxTags)
+	}
+	return result
+}
+
+// parseTagList reads a query parameter holding comma-separated tags. The
+// parameter may also be repeated.
+func parseTagList(q map[string][]string, key string) ([]string, error) {
+	var tags []string
+	for _, v := range q[key] {
+		for _, tag := range strings.Split(v, ",") {
+			tag = normalizeTag(tag)
+			if tag == "" {
+				continue
+			}
+			if !validTag(tag) {
+				return nil, fmt.Errorf("%s contains an invalid tag %q", key, tag)
+			}
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// hasTag reports whether tags contains tag.
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// renameTag returns tags with from replaced by to, and whether anything
+// changed.
+func renameTag(tags []string, from, to string) ([]string, bool) {
+	if !hasTag(tags, from) {
+		return tags, false
+	}
+	result := make([]string, 0, len(tags))
+	for _, t := range tags {
+		if t == from {
+			t = to
+		}
+		if !hasTag(result, t) {
+			result = append(result, t)
+		}
+	}
+	return result, true
+}
+
+// countTags tallies tags starting with prefix, for stores that have no query
+// engine to do it for them.
+func countTags(transactions []Transaction, prefix string) []TagCount {
+	counts := make(map[string]int)
+	for _, t := range transactions {
+		for _, tag := range t.Tags {
+			if strings.HasPrefix(tag, prefix) {
+				counts[tag]++
+			}
+		}
+	}
+
+	result := make([]TagCount, 0, len(counts))
+	for tag, n := range counts {
+		result = append(result, TagCount{Tag: tag, Count: n})
+	}
+	sortTagCounts(result)
+	return result
+}
+
+// sortTagCounts orders tags by usage, most used first, then alphabetically.
+func sortTagCounts(counts []TagCount) {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Tag < counts[j].Tag
+	})
+}
+
+// getTags handles GET /tags. It suggests tags starting with ?prefix=, most
+// used first, for autocomplete.
+func (s *server) getTags(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix := normalizeTag(q.Get("prefix"))
+	limit := defaultTagResults
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "limit must be an integer between 1 and "+strconv.Itoa(maxPageSize))
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	counts, err := s.store.TagCounts(ctx, prefix)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if len(counts) > limit {
+		counts = counts[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(counts)
+}
+
+// renameTags handles POST /tags/rename. Renaming onto a tag that already
+// exists merges the two.
+func (s *server) renameTags(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	var errs validationError
+	from, to := normalizeTag(requestBody.From), normalizeTag(requestBody.To)
+	if !validTag(from) {
+		errs.add("from", "must be a valid tag")
+	}
+	if !validTag(to) {
+		errs.add("to", "must be a valid tag")
+	} else if from == to {
+		errs.add("to", "must be different from \"from\"")
+	}
+	if err := errs.err(); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+
+	before, err := s.store.List(ctx, ListOptions{Filter: TransactionFilter{TagsAny: []string{from}, Scope: scopeAll}})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	updated, err := s.store.RenameTag(ctx, from, to)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	s.audit(ctx, r, OpRename, ResourceTag, from,
+		map[string]string{"tag": from},
+		map[string]interface{}{"tag": to, "updated": updated})
+	s.auditUpdates(ctx, s.requestOrigin(r), before, TransactionFilter{TagsAny: []string{to}, Scope: scopeAll})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"updated": updated})
+}
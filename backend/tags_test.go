@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRenameTag(t *testing.T) {
+	tests := []struct {
+		tags, from, to, want string
+		changed              bool
+	}{
+		{"goa,trip", "trip", "travel", "goa,travel", true},
+		{"trip,food,travel", "trip", "travel", "travel,food", true},
+		{"travel,trip", "trip", "travel", "travel", true},
+		{"food", "trip", "travel", "food", false},
+		{"", "trip", "travel", "", false},
+	}
+	for _, tt := range tests {
+		var tags []string
+		if tt.tags != "" {
+			tags = strings.Split(tt.tags, ",")
+		}
+		got, changed := renameTag(tags, tt.from, tt.to)
+		if strings.Join(got, ",") != tt.want || changed != tt.changed {
+			t.Errorf("renameTag(%v, %s, %s) = %v, %t; want %s, %t", tags, tt.from, tt.to, got, changed, tt.want, tt.changed)
+		}
+	}
+}
+
+func TestRenameTagsAcrossTransactions(t *testing.T) {
+	s := newTestServer()
+	routes := s.routes()
+	ctx := context.Background()
+
+	created := map[string]Transaction{}
+	for _, tt := range []struct{ description, tags string }{
+		{"Flight", `["goa","trip"]`},
+		// Carries the merge target already.
+		{"Hotel", `["trip","food","travel"]`},
+		{"Dinner", `["food"]`},
+	} {
+		body := fmt.Sprintf(`{"description":%q,"amount":"100","type":"expense","dateTime":"2025-03-10T09:00:00Z","tags":%s}`, tt.description, tt.tags)
+		w := do(routes.ServeHTTP, http.MethodPost, "/transactions", body, nil)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create %s: got %d %s", tt.description, w.Code, w.Body)
+		}
+		var tx Transaction
+		decode(t, w, &tx)
+		created[tt.description] = tx
+	}
+
+	w := do(routes.ServeHTTP, http.MethodPost, "/tags/rename", `{"from":" Trip","to":"TRAVEL"}`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /tags/rename: got %d %s", w.Code, w.Body)
+	}
+	var result struct{ Updated int }
+	decode(t, w, &result)
+	if result.Updated != 2 {
+		t.Errorf("updated = %d, want 2", result.Updated)
+	}
+
+	for description, want := range map[string]struct {
+		tags    string
+		version int64
+	}{
+		"Flight": {"goa,travel", 1},
+		"Hotel":  {"travel,food", 1},
+		"Dinner": {"food", 0},
+	} {
+		before := created[description]
+		got, err := s.store.Get(ctx, before.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got.Tags, ",") != want.tags || got.Version != before.Version+want.version {
+			t.Errorf("%s: tags %v at version %d, want %s at version %d", description, got.Tags, got.Version, want.tags, before.Version+want.version)
+		}
+	}
+
+	w = do(routes.ServeHTTP, http.MethodGet, "/tags", "", nil)
+	var counts []TagCount
+	decode(t, w, &counts)
+	var got []string
+	for _, c := range counts {
+		got = append(got, fmt.Sprintf("%s:%d", c.Tag, c.Count))
+	}
+	if want := "food:2,travel:2,goa:1"; strings.Join(got, ",") != want {
+		t.Errorf("GET /tags = %v, want %s", got, want)
+	}
+}
+
+func TestRenameTagsRejectsBadTags(t *testing.T) {
+	routes := newTestServer().routes()
+	for _, body := range []string{
+		`{"from":"trip","to":"Trip "}`,
+		`{"from":"trip","to":"road trip"}`,
+		`{"from":"","to":"travel"}`,
+	} {
+		if w := do(routes.ServeHTTP, http.MethodPost, "/tags/rename", body, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("POST /tags/rename %s: got %d %s, want 400", body, w.Code, w.Body)
+		}
+	}
+}
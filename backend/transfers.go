@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TypeTransfer marks the two legs of a transfer between accounts. Transfers
+// move money without being income or expense, so summaries, reports and
+// budgets leave them out.
+const TypeTransfer = "transfer"
+
+// Transfer leg directions.
+const (
+	DirectionOut = "out" // money leaves the account
+	DirectionIn  = "in"  // money arrives in the account
+)
+
+// TransferStore writes transfers, whose legs must never exist without each
+// other.
+type TransferStore interface {
+	// CreateTransfer stores all legs, assigning new IDs, or none of them.
+	CreateTransfer(ctx context.Context, legs []Transaction) error
+	// DeleteTransfer moves every leg of the transfer with the given ID to the
+	// trash, marking them all deleted at at. The version of the transfer,
+	// that of its most recently changed leg, must still be version. It
+	// returns ErrNotFound or ErrVersionMismatch. The legs are then restored
+	// and purged together.
+	DeleteTransfer(ctx context.Context, id primitive.ObjectID, version int64, at time.Time) error
+}
+
+// Transfer is the response body of the transfer endpoints. Its legs are
+// created, deleted and restored together; the ETag of the transfer is its
+// transferVersion.
+type Transfer struct {
+	ID   primitive.ObjectID `json:"_id"`
+	Legs []Transaction      `json:"legs"`
+}
+
+// transferVersion returns the version of a transfer with the given legs,
+// which is the highest of their versions. Changes that reach a single leg,
+// such as renaming one of its tags, bump only that leg, and still change
+// the version of the transfer.
+func transferVersion(legs []Transaction) int64 {
+	var version int64
+	for _, leg := range legs {
+		if leg.Version > version {
+			version = leg.Version
+		}
+	}
+	return version
+}
+
+// transferInput is the request body of POST /transfers. ToAmount is required
+// when the accounts use different currencies and defaults to Amount
+// otherwise.
+type transferInput struct {
+	FromAccountID string `json:"fromAccountId"`
+	ToAccountID   string `json:"toAccountId"`
+	Amount        Money  `json:"amount"`
+	ToAmount      *Money `json:"toAmount,omitempty"`
+	Description   string `json:"description,omitempty"`
+	DateTime      string `json:"dateTime"`
+}
+
+// loadTransferAccount resolves one side of a transfer, recording problems in
+// errs.
+func (s *server) loadTransferAccount(ctx context.Context, errs *validationError, field, hex string) (Account, error) {
+	if hex == "" {
+		errs.add(field, "is required")
+		return Account{}, nil
+	}
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		errs.add(field, "must be a valid ID")
+		return Account{}, nil
+	}
+	a, err := s.store.GetAccount(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		errs.add(field, "does not exist")
+		return Account{}, nil
+	}
+	return a, err
+}
+
+// transferLegs validates in and returns its outgoing and incoming legs.
+func (s *server) transferLegs(ctx context.Context, in transferInput) ([]Transaction, error) {
+	var errs validationError
+	from, err := s.loadTransferAccount(ctx, &errs, "fromAccountId", in.FromAccountID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.loadTransferAccount(ctx, &errs, "toAccountId", in.ToAccountID)
+	if err != nil {
+		return nil, err
+	}
+	if !from.ID.IsZero() && from.ID == to.ID {
+		errs.add("toAccountId", "must differ from fromAccountId")
+	}
+
+	var at time.Time
+	if in.DateTime == "" {
+		errs.add("dateTime", "is required")
+	} else if at, err = time.Parse(time.RFC3339, in.DateTime); err != nil {
+		errs.add("dateTime", "must be an RFC3339 timestamp")
+	}
+
+	description := strings.TrimSpace(in.Description)
+	if description == "" && !from.ID.IsZero() && !to.ID.IsZero() {
+		description = fmt.Sprintf("Transfer from %s to %s", from.Name, to.Name)
+		if n := []rune(description); len(n) > maxDescriptionLength {
+			description = string(n[:maxDescriptionLength])
+		}
+	}
+
+	toAmount := in.Amount
+	if in.ToAmount != nil {
+		toAmount = *in.ToAmount
+	} else if !from.ID.IsZero() && !to.ID.IsZero() && from.Currency != to.Currency {
+		errs.add("toAmount", "is required when the accounts use different currencies")
+	}
+
+	transferID := primitive.NewObjectID()
+	legs := []Transaction{
+		{Description: description, Amount: in.Amount, Currency: from.Currency, AccountID: &from.ID, Direction: DirectionOut},
+		{Description: description, Amount: toAmount, Currency: to.Currency, AccountID: &to.ID, Direction: DirectionIn},
+	}
+	for i := range legs {
+		legs[i].Type = TypeTransfer
+		legs[i].DateTime = at
+		legs[i].TransferID = &transferID
+	}
+
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	s.rules.check(&errs, legs[0])
+	// The incoming leg shares everything but the amount with the outgoing
+	// one, so only its amount can add new problems.
+	var inErrs validationError
+	s.rules.check(&inErrs, legs[1])
+	for _, f := range inErrs.Fields {
+		if f.Field == "amount" && in.ToAmount != nil {
+			errs.add("toAmount", "%s", f.Message)
+		}
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	for i := range legs {
+		legs[i].Amount, _ = legs[i].Amount.Rescale(currencyScale(legs[i].Currency))
+	}
+	return legs, nil
+}
+
+// createTransfer handles POST /transfers. Both legs are written atomically,
+// so account balances never see one without the other.
+func (s *server) createTransfer(w http.ResponseWriter, r *http.Request) {
+	var requestBody transferInput
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	legs, err := s.transferLegs(ctx, requestBody)
+	if err != nil {
+		writeCheckError(w, err)
+		return
+	}
+
+	if err := s.store.CreateTransfer(ctx, legs); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	for _, leg := range legs {
+		s.audit(ctx, r, OpCreate, ResourceTransaction, leg.ID.Hex(), nil, leg)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(transferVersion(legs)))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(Transfer{ID: *legs[0].TransferID, Legs: legs})
+}
+
+// getTransfer handles GET /transfers/{id}.
+func (s *server) getTransfer(w http.ResponseWriter, r *http.Request) {
+	id, err := objectIDFromPath(r, "/transfers/")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	legs, err := s.store.List(ctx, ListOptions{Filter: TransactionFilter{TransferID: &id}})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if len(legs) == 0 {
+		writeError(w, http.StatusNotFound, CodeNotFound, "transfer not found")
+		return
+	}
+	// Outgoing leg first.
+	if legs[0].Direction != DirectionOut {
+		legs[0], legs[len(legs)-1] = legs[len(legs)-1], legs[0]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(transferVersion(legs)))
+	json.NewEncoder(w).Encode(Transfer{ID: id, Legs: legs})
+}
+
+// deleteTransfer handles DELETE /transfers/{id}, moving both legs to the
+// trash.
+func (s *server) deleteTransfer(w http.ResponseWriter, r *http.Request) {
+	id, err := objectIDFromPath(r, "/transfers/")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	legs, err := s.store.List(ctx, ListOptions{Filter: TransactionFilter{TransferID: &id}})
+	if err == nil && len(legs) == 0 {
+		err = ErrNotFound
+	}
+	var version int64
+	if err == nil {
+		version = transferVersion(legs)
+		if !s.checkIfMatch(w, r, Transaction{Version: version}) {
+			return
+		}
+		err = s.store.DeleteTransfer(ctx, id, version, time.Now().UTC())
+	}
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "transfer not found")
+		return
+	}
+	if errors.Is(err, ErrVersionMismatch) {
+		writeVersionMismatch(w)
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	for _, leg := range legs {
+		s.audit(ctx, r, OpDelete, ResourceTransaction, leg.ID.Hex(), leg, nil)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sameTransfer reports whether a and b are legs of the same transfer.
+func sameTransfer(a, b Transaction) bool {
+	return a.TransferID != nil && b.TransferID != nil && *a.TransferID == *b.TransferID
+}
+
+// writeTransferLegConflict refuses to change a single leg of a transfer.
+func writeTransferLegConflict(w http.ResponseWriter, t Transaction) {
+	writeError(w, http.StatusConflict, CodeTransferLeg, fmt.Sprintf("transaction is part of transfer %s; use /transfers/%s instead", t.TransferID.Hex(), t.TransferID.Hex()))
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestTransfer(t *testing.T) {
+	s := newTestServer()
+	routes := s.routes()
+
+	var ids [2]string
+	for i, name := range []string{"Checking", "Wallet"} {
+		w := do(routes.ServeHTTP, http.MethodPost, "/accounts", `{"name":"`+name+`","kind":"bank","currency":"INR","openingBalance":"1000"}`, nil)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create account: got %d %s", w.Code, w.Body)
+		}
+		var a Account
+		decode(t, w, &a)
+		ids[i] = a.ID.Hex()
+	}
+	w := do(routes.ServeHTTP, http.MethodPost, "/transfers",
+		`{"fromAccountId":"`+ids[0]+`","toAccountId":"`+ids[1]+`","amount":"300","dateTime":"2025-03-01T09:00:00Z"}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create transfer: got %d %s", w.Code, w.Body)
+	}
+	var transfer Transfer
+	decode(t, w, &transfer)
+	if len(transfer.Legs) != 2 || transfer.Legs[0].Direction != DirectionOut {
+		t.Fatalf("legs = %+v, want the outgoing leg and then the incoming one", transfer.Legs)
+	}
+	wantBalances(t, routes, map[string]string{ids[0]: "700.00", ids[1]: "1300.00"})
+
+	// A leg can only be changed through its transfer.
+	leg := "/transactions/" + transfer.Legs[0].ID.Hex()
+	for _, tt := range []struct{ method, body string }{
+		{http.MethodPatch, `{"description":"Cash"}`},
+		{http.MethodPut, rentBody},
+	} {
+		w := do(routes.ServeHTTP, tt.method, leg, tt.body, nil)
+		var body errorBody
+		decode(t, w, &body)
+		if w.Code != http.StatusConflict || body.Error.Code != CodeTransferLeg {
+			t.Errorf("%s of a leg: got %d %q, want 409 %q", tt.method, w.Code, body.Error.Code, CodeTransferLeg)
+		}
+	}
+
+	// Deleting the transfer trashes both legs, and restoring one brings back
+	// the other.
+	path := "/transfers/" + transfer.ID.Hex()
+	if w := do(routes.ServeHTTP, http.MethodDelete, path, "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("delete transfer: got %d %s", w.Code, w.Body)
+	}
+	if n := countListed(t, routes, "/trash"); n != 2 {
+		t.Errorf("listed %d legs in the trash, want 2", n)
+	}
+	wantBalances(t, routes, map[string]string{ids[0]: "1000.00", ids[1]: "1000.00"})
+	if w := do(routes.ServeHTTP, http.MethodPost, leg+"/restore", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("restore leg: got %d %s", w.Code, w.Body)
+	}
+	if w := do(routes.ServeHTTP, http.MethodGet, path, "", nil); w.Code != http.StatusOK {
+		t.Errorf("transfer after restore: got %d, want 200", w.Code)
+	}
+	wantBalances(t, routes, map[string]string{ids[0]: "700.00", ids[1]: "1300.00"})
+}
+
+// createTransferWithChangedLeg creates a transfer and changes its incoming
+// leg alone, leaving the legs at versions 1 and 2.
+func createTransferWithChangedLeg(t *testing.T, s *server) Transfer {
+	t.Helper()
+	routes := s.routes()
+	var ids [2]string
+	for i, name := range []string{"Checking", "Wallet"} {
+		w := do(routes.ServeHTTP, http.MethodPost, "/accounts", `{"name":"`+name+`","kind":"bank","currency":"INR"}`, nil)
+		var a Account
+		decode(t, w, &a)
+		ids[i] = a.ID.Hex()
+	}
+	w := do(routes.ServeHTTP, http.MethodPost, "/transfers",
+		`{"fromAccountId":"`+ids[0]+`","toAccountId":"`+ids[1]+`","amount":"300","dateTime":"2025-03-01T09:00:00Z"}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create transfer: got %d %s", w.Code, w.Body)
+	}
+	var transfer Transfer
+	decode(t, w, &transfer)
+
+	// A change that reaches the incoming leg alone, as migrate-amounts
+	// makes when it rounds a leg's amount, bumps only its version.
+	leg := &transfer.Legs[1]
+	leg.Description = "Cash"
+	if err := s.store.Update(context.Background(), leg); err != nil {
+		t.Fatal(err)
+	}
+	return transfer
+}
+
+func TestDeleteTransferAfterOneLegChanged(t *testing.T) {
+	s := newTestServer()
+	routes := s.routes()
+	transfer := createTransferWithChangedLeg(t, s)
+
+	path := "/transfers/" + transfer.ID.Hex()
+	w := do(routes.ServeHTTP, http.MethodGet, path, "", nil)
+	if got := w.Header().Get("ETag"); got != `"2"` {
+		t.Fatalf("ETag after changing one leg = %s, want \"2\"", got)
+	}
+	if w := do(routes.ServeHTTP, http.MethodDelete, path, "", map[string]string{"If-Match": `"1"`}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("delete with the ETag from before the change: got %d %s, want 412", w.Code, w.Body)
+	}
+	if w := do(routes.ServeHTTP, http.MethodDelete, path, "", map[string]string{"If-Match": `"2"`}); w.Code != http.StatusNoContent {
+		t.Fatalf("delete with the current ETag: got %d %s, want 204", w.Code, w.Body)
+	}
+	if n := countListed(t, routes, "/trash"); n != 2 {
+		t.Errorf("listed %d legs in the trash, want 2", n)
+	}
+}
+
+func TestRestoreTransferWithLegsAtDifferentVersions(t *testing.T) {
+	s := newTestServer()
+	routes := s.routes()
+	transfer := createTransferWithChangedLeg(t, s)
+	if w := do(routes.ServeHTTP, http.MethodDelete, "/transfers/"+transfer.ID.Hex(), "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("delete transfer: got %d %s", w.Code, w.Body)
+	}
+
+	// The legs are now at versions 2 and 3; If-Match names the version of
+	// the leg being restored.
+	leg := "/transactions/" + transfer.Legs[0].ID.Hex() + "/restore"
+	if w := do(routes.ServeHTTP, http.MethodPost, leg, "", map[string]string{"If-Match": `"3"`}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("restore with the other leg's version: got %d %s, want 412", w.Code, w.Body)
+	}
+	if w := do(routes.ServeHTTP, http.MethodPost, leg, "", map[string]string{"If-Match": `"2"`}); w.Code != http.StatusOK {
+		t.Fatalf("restore leg: got %d %s", w.Code, w.Body)
+	}
+	if n := countListed(t, routes, "/trash"); n != 0 {
+		t.Errorf("listed %d legs in the trash after the restore, want 0", n)
+	}
+	for i, want := range []int64{3, 4} {
+		got, err := s.store.Get(context.Background(), transfer.Legs[i].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.DeletedAt != nil || got.Version != want {
+			t.Errorf("leg %d: deleted at %v, version %d; want restored at version %d", i, got.DeletedAt, got.Version, want)
+		}
+	}
+}
+
+func TestTransferValidation(t *testing.T) {
+	routes := newTestServer().routes()
+	w := do(routes.ServeHTTP, http.MethodPost, "/accounts", `{"name":"Checking","kind":"bank","currency":"INR"}`, nil)
+	var a Account
+	decode(t, w, &a)
+
+	w = do(routes.ServeHTTP, http.MethodPost, "/transfers",
+		`{"fromAccountId":"`+a.ID.Hex()+`","toAccountId":"`+a.ID.Hex()+`","amount":"300","dateTime":"2025-03-01T09:00:00Z"}`, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("transfer to the same account: got %d %s, want 400", w.Code, w.Body)
+	}
+}
+
+// wantBalances checks the balance of every account in want, keyed by ID.
+func wantBalances(t *testing.T, routes http.Handler, want map[string]string) {
+	t.Helper()
+	w := do(routes.ServeHTTP, http.MethodGet, "/accounts", "", nil)
+	var accounts []Account
+	decode(t, w, &accounts)
+	for _, a := range accounts {
+		if a.Balance == nil || a.Balance.String() != want[a.ID.Hex()] {
+			t.Errorf("balance of %s = %v, want %s", a.Name, a.Balance, want[a.ID.Hex()])
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// trashScope selects between live transactions and those in the trash.
+type trashScope int
+
+const (
+	scopeLive  trashScope = iota // transactions not in the trash (default)
+	scopeTrash                   // only transactions in the trash
+	scopeAll                     // both
+)
+
+// includes reports whether a transaction deleted at deletedAt (nil when it
+// is live) is in the scope.
+func (sc trashScope) includes(deletedAt *time.Time) bool {
+	switch sc {
+	case scopeTrash:
+		return deletedAt != nil
+	case scopeAll:
+		return true
+	default:
+		return deletedAt == nil
+	}
+}
+
+// getTrash handles GET /trash. It lists deleted transactions like GET
+// /transactions, with the same filters and pagination.
+func (s *server) getTrash(w http.ResponseWriter, r *http.Request) {
+	s.listTransactions(w, r, scopeTrash)
+}
+
+// restoreTransaction handles POST /transactions/{id}/restore, moving a
+// transaction out of the trash. Restoring a leg of a transfer restores the
+// whole transfer.
+func (s *server) restoreTransaction(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/transactions/"), "/restore")
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "invalid ID format")
+		return
+	}
+
+	version, ok := s.expectedVersion(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	t, err := s.store.Restore(ctx, objID, version)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "transaction not found in trash")
+		return
+	}
+	if errors.Is(err, ErrVersionMismatch) {
+		writeVersionMismatch(w)
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	s.audit(ctx, r, OpRestore, ResourceTransaction, objID.Hex(), nil, t)
+	if t.TransferID != nil {
+		legs, err := s.store.List(ctx, ListOptions{Filter: TransactionFilter{TransferID: t.TransferID}})
+		if err != nil {
+			log.Printf("Audit: listing legs of transfer %s: %v", t.TransferID.Hex(), err)
+		}
+		for _, leg := range legs {
+			if leg.ID != t.ID {
+				s.audit(ctx, r, OpRestore, ResourceTransaction, leg.ID.Hex(), nil, leg)
+			}
+		}
+	}
+
+	writeTransaction(w, http.StatusOK, t)
+}
+
+// purgeTrash permanently removes transactions that have been in the trash
+// for longer than the configured retention. A zero retention keeps them
+// forever.
+func (s *server) purgeTrash(ctx context.Context, now time.Time) {
+	if s.trashRetention <= 0 {
+		return
+	}
+	var purged int
+	err := s.atomically(ctx, func(ctx context.Context) error {
+		var err error
+		if purged, err = s.store.PurgeTrash(ctx, now.Add(-s.trashRetention)); err != nil || purged == 0 {
+			return err
+		}
+		s.appendAudit(ctx, origin{Actor: schedulerActor}, OpPurge, ResourceTrash, "", nil, map[string]int{"purged": purged})
+		return nil
+	})
+	if err != nil {
+		log.Printf("Scheduler: purging trash: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Scheduler: purged %d transaction(s) from the trash", purged)
+	}
+}
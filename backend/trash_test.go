@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTrashAndRestore(t *testing.T) {
+	s := newTestServer()
+	routes := s.routes()
+	path := createRent(t, routes)
+
+	if w := do(routes.ServeHTTP, http.MethodDelete, path, "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: got %d %s", w.Code, w.Body)
+	}
+	if w := do(routes.ServeHTTP, http.MethodGet, path, "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("GET after delete: got %d, want 404", w.Code)
+	}
+	if n := countListed(t, routes, "/transactions"); n != 0 {
+		t.Errorf("listed %d live transactions after delete, want 0", n)
+	}
+	if n := countListed(t, routes, "/trash"); n != 1 {
+		t.Errorf("listed %d transactions in the trash, want 1", n)
+	}
+
+	if w := do(routes.ServeHTTP, http.MethodPost, path+"/restore", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("restore: got %d %s", w.Code, w.Body)
+	}
+	if w := do(routes.ServeHTTP, http.MethodGet, path, "", nil); w.Code != http.StatusOK {
+		t.Errorf("GET after restore: got %d, want 200", w.Code)
+	}
+	if n := countListed(t, routes, "/trash"); n != 0 {
+		t.Errorf("listed %d transactions in the trash after restore, want 0", n)
+	}
+	if w := do(routes.ServeHTTP, http.MethodPost, path+"/restore", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("restoring a live transaction: got %d, want 404", w.Code)
+	}
+}
+
+// countListed returns how many transactions the first page of target holds.
+func countListed(t *testing.T, routes http.Handler, target string) int {
+	t.Helper()
+	w := do(routes.ServeHTTP, http.MethodGet, target, "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: got %d %s", target, w.Code, w.Body)
+	}
+	var page transactionPage
+	decode(t, w, &page)
+	return len(page.Transactions)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Transaction types. Amounts are always positive; the type decides whether a
+// transaction adds to (income) or subtracts from (expense) the balance.
+const (
+	TypeIncome  = "income"
+	TypeExpense = "expense"
+)
+
+const maxDescriptionLength = 200
+
+// maxAmount keeps single amounts far enough below the range of Money that
+// totals over any realistic ledger cannot overflow.
+var maxAmount = Money{units: 1_000_000_000_000}
+
+func validTransactionType(t string) bool {
+	return t == TypeIncome || t == TypeExpense
+}
+
+// signedAmount returns the amount with the sign implied by the type, or for
+// a transfer leg by its direction.
+func (t Transaction) signedAmount() Money {
+	if t.Type == TypeExpense || (t.Type == TypeTransfer && t.Direction == DirectionOut) {
+		return t.Amount.Neg()
+	}
+	return t.Amount
+}
+
+// fieldError describes a problem with a single request field.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validationError collects every field problem found in a request so they can
+// be reported together.
+type validationError struct {
+	Fields []fieldError
+}
+
+func (e *validationError) add(field, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, fieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// err returns e if any problem was recorded and nil otherwise.
+func (e *validationError) err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *validationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// writeBadRequest responds with 400. Validation errors list every field
+// problem in the error's fields.
+func writeBadRequest(w http.ResponseWriter, err error) {
+	verr, ok := err.(*validationError)
+	if !ok {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	writeAPIError(w, http.StatusBadRequest, apiError{
+		Code:    CodeValidationFailed,
+		Message: "validation failed: " + verr.Error(),
+		Fields:  verr.Fields,
+	})
+}
+
+// futureDatePolicy decides how far in the future a transaction may be dated.
+type futureDatePolicy struct {
+	// MaxAhead is the allowed distance from now, or nil for no limit.
+	MaxAhead *time.Duration
+}
+
+// parseFutureDatePolicy understands "allow" (no limit), "reject" (no future
+// dates) and a Go duration such as "72h" (at most that far ahead).
+func parseFutureDatePolicy(s string) (futureDatePolicy, error) {
+	switch s {
+	case "", "allow":
+		return futureDatePolicy{}, nil
+	case "reject":
+		var zero time.Duration
+		return futureDatePolicy{MaxAhead: &zero}, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return futureDatePolicy{}, fmt.Errorf("future date policy must be allow, reject or a non-negative duration, got %q", s)
+	}
+	return futureDatePolicy{MaxAhead: &d}, nil
+}
+
+// validationRules are the semantic checks applied to every transaction
+// before it is stored.
+type validationRules struct {
+	// DefaultCurrency is the base currency. It is used for transactions
+	// that do not name one, and summaries are reported in it.
+	DefaultCurrency string
+	FutureDates     futureDatePolicy
+	// Now returns the current time; tests and tools may override it.
+	Now func() time.Time
+}
+
+// check records every rule t breaks in errs.
+func (v validationRules) check(errs *validationError, t Transaction) {
+	switch n := utf8.RuneCountInString(t.Description); {
+	case n == 0:
+		errs.add("description", "is required")
+	case n > maxDescriptionLength:
+		errs.add("description", "must be at most %d characters", maxDescriptionLength)
+	}
+
+	currencyOK := true
+	if err := validateCurrency(t.Currency); err != nil {
+		errs.add("currency", "must be a three-letter ISO 4217 code")
+		currencyOK = false
+	}
+
+	scale := currencyScale(t.Currency)
+	switch {
+	case t.Amount.Sign() <= 0:
+		errs.add("amount", "must be greater than zero (the type says whether it is income or expense)")
+	case t.Amount.Cmp(maxAmount) >= 0:
+		errs.add("amount", "must be less than %s", maxAmount)
+	case currencyOK && t.Amount.Scale() > scale:
+		if _, ok := t.Amount.Rescale(scale); !ok {
+			errs.add("amount", "must have at most %d decimal places for %s", scale, t.Currency)
+		}
+	}
+
+	switch {
+	case t.Type == TypeTransfer && t.TransferID != nil:
+	case t.Type == TypeTransfer:
+		errs.add("type", "transfers are created with POST /transfers")
+	case !validTransactionType(t.Type):
+		errs.add("type", "must be %q or %q", TypeIncome, TypeExpense)
+	}
+
+	if !t.DateTime.IsZero() && v.FutureDates.MaxAhead != nil {
+		now := time.Now
+		if v.Now != nil {
+			now = v.Now
+		}
+		if t.DateTime.After(now().Add(*v.FutureDates.MaxAhead)) {
+			if *v.FutureDates.MaxAhead == 0 {
+				errs.add("dateTime", "must not be in the future")
+			} else {
+				errs.add("dateTime", "must not be more than %s in the future", *v.FutureDates.MaxAhead)
+			}
+		}
+	}
+}
+
+// validate returns a *validationError describing every rule t breaks, or nil.
+func (v validationRules) validate(t Transaction) error {
+	var errs validationError
+	v.check(&errs, t)
+	return errs.err()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidationCollectsEveryFieldError(t *testing.T) {
+	s := newTestServer()
+	s.rules.FutureDates, _ = parseFutureDatePolicy("reject")
+
+	body := `{"description":"   ","amount":"0","currency":"ABCD","type":"expense","dateTime":"2999-01-01T00:00:00Z"}`
+	w := do(s.createTransaction, http.MethodPost, "/transactions", body, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("create: got %d %s, want 400", w.Code, w.Body)
+	}
+	var got errorBody
+	decode(t, w, &got)
+	if got.Error.Code != CodeValidationFailed {
+		t.Errorf("code = %q, want %q", got.Error.Code, CodeValidationFailed)
+	}
+	var fields []string
+	for _, f := range got.Error.Fields {
+		fields = append(fields, f.Field)
+		if !strings.Contains(got.Error.Message, f.Field+": "+f.Message) {
+			t.Errorf("message %q does not mention %s: %s", got.Error.Message, f.Field, f.Message)
+		}
+	}
+	if want := "description,currency,amount,dateTime"; strings.Join(fields, ",") != want {
+		t.Errorf("fields = %v, want %s", fields, want)
+	}
+}
+
+func TestValidationLimits(t *testing.T) {
+	now := time.Date(2025, 3, 29, 12, 0, 0, 0, time.UTC)
+	reject, _ := parseFutureDatePolicy("reject")
+	threeDays, _ := parseFutureDatePolicy("72h")
+
+	tests := []struct {
+		name   string
+		rules  futureDatePolicy
+		change func(tx *Transaction)
+		// field is the one reported, or empty for a valid transaction.
+		field string
+	}{
+		{"valid", futureDatePolicy{}, func(tx *Transaction) {}, ""},
+		{"description of 200 characters", futureDatePolicy{}, func(tx *Transaction) { tx.Description = strings.Repeat("é", 200) }, ""},
+		{"description of 201 characters", futureDatePolicy{}, func(tx *Transaction) { tx.Description = strings.Repeat("é", 201) }, "description"},
+		{"empty description", futureDatePolicy{}, func(tx *Transaction) { tx.Description = "" }, "description"},
+		{"smallest amount", futureDatePolicy{}, func(tx *Transaction) { tx.Amount = mustMoney(t, "0.01") }, ""},
+		{"zero amount", futureDatePolicy{}, func(tx *Transaction) { tx.Amount = mustMoney(t, "0.00") }, "amount"},
+		{"negative amount", futureDatePolicy{}, func(tx *Transaction) { tx.Amount = mustMoney(t, "-1") }, "amount"},
+		{"largest amount", futureDatePolicy{}, func(tx *Transaction) { tx.Amount = mustMoney(t, "999999999999.99") }, ""},
+		{"amount at the limit", futureDatePolicy{}, func(tx *Transaction) { tx.Amount = mustMoney(t, "1000000000000") }, "amount"},
+		{"minor unit", futureDatePolicy{}, func(tx *Transaction) { tx.Amount = mustMoney(t, "12.50") }, ""},
+		{"trailing zeros beyond the minor unit", futureDatePolicy{}, func(tx *Transaction) { tx.Amount = mustMoney(t, "12.5000") }, ""},
+		{"beyond the minor unit", futureDatePolicy{}, func(tx *Transaction) { tx.Amount = mustMoney(t, "12.505") }, "amount"},
+		{"JPY has no minor unit", futureDatePolicy{}, func(tx *Transaction) { tx.Currency, tx.Amount = "JPY", mustMoney(t, "1.5") }, "amount"},
+		{"unknown type", futureDatePolicy{}, func(tx *Transaction) { tx.Type = "refund" }, "type"},
+		{"far future allowed", futureDatePolicy{}, func(tx *Transaction) { tx.DateTime = now.AddDate(10, 0, 0) }, ""},
+		{"now with future dates rejected", reject, func(tx *Transaction) { tx.DateTime = now }, ""},
+		{"just after now with future dates rejected", reject, func(tx *Transaction) { tx.DateTime = now.Add(time.Second) }, "dateTime"},
+		{"at the future limit", threeDays, func(tx *Transaction) { tx.DateTime = now.Add(72 * time.Hour) }, ""},
+		{"past the future limit", threeDays, func(tx *Transaction) { tx.DateTime = now.Add(72*time.Hour + time.Second) }, "dateTime"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := validationRules{DefaultCurrency: "INR", FutureDates: tt.rules, Now: func() time.Time { return now }}
+			tx := Transaction{Description: "Rent", Amount: mustMoney(t, "4500"), Currency: "INR", Type: TypeExpense, DateTime: now.AddDate(0, 0, -1)}
+			tt.change(&tx)
+
+			err := rules.validate(tx)
+			switch verr, _ := err.(*validationError); {
+			case tt.field == "" && err != nil:
+				t.Errorf("validate = %v, want no error", err)
+			case tt.field != "" && (verr == nil || len(verr.Fields) != 1 || verr.Fields[0].Field != tt.field):
+				t.Errorf("validate = %v, want one error for %s", err, tt.field)
+			}
+		})
+	}
+}